@@ -19,6 +19,9 @@ func (e EnvMap) SetupEnv() EnvMap {
 	for k, v := range e {
 		if ev, found := os.LookupEnv(k); found {
 			restoreMap[k] = StringPointer(ev)
+		} else {
+			// Unset variables are recorded as nil so that restoring removes them again.
+			restoreMap[k] = nil
 		}
 		var err error
 		if v != nil {
@@ -0,0 +1,491 @@
+// dbctl is the administration tool for the scan database.
+// It reads the same DATABASE_* environment variables as the processor to connect to the database.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/export"
+	"github.com/censys/scan-takehome/internal/fingerprint"
+	"github.com/censys/scan-takehome/internal/retention"
+	"github.com/censys/scan-takehome/pkg/models"
+
+	// import the database implementations for the registration side effect
+	_ "github.com/censys/scan-takehome/internal/database/bolt"
+	_ "github.com/censys/scan-takehome/internal/database/clickhouse"
+	_ "github.com/censys/scan-takehome/internal/database/fanout"
+	_ "github.com/censys/scan-takehome/internal/database/noop"
+	_ "github.com/censys/scan-takehome/internal/database/psql"
+	_ "github.com/censys/scan-takehome/internal/database/redis"
+)
+
+// command is a single dbctl sub-command.
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string, out io.Writer) error
+}
+
+var commands = map[string]command{
+	"export": {
+		summary: "write the latest scan entries as JSON lines",
+		run:     runExport,
+	},
+	"export-parquet": {
+		summary: "write the scan data (and history) to Parquet files partitioned by date and service",
+		run:     runExportParquet,
+	},
+	"expire": {
+		summary: "remove services which have not been scanned within the retention policy",
+		run:     runExpire,
+	},
+	"fingerprint": {
+		summary: "test fingerprint rules against banners or scan entries (as written by export)",
+		run:     runFingerprint,
+	},
+	"import": {
+		summary: "upsert scan entries from JSON lines (as written by export)",
+		run:     runImport,
+	},
+	"maintain": {
+		summary: "create upcoming history partitions and drop expired ones",
+		run:     runMaintain,
+	},
+	"migrate": {
+		summary: "apply (up) or list (status) the embedded schema migrations",
+		run:     runMigrate,
+	},
+	"snapshot": {
+		summary: "write a compacted copy of an embedded database to a new file",
+		run:     runSnapshot,
+	},
+}
+
+func usage(out io.Writer) {
+	fmt.Fprintln(out, "usage: dbctl <command> [options]")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-15s %s\n", name, commands[name].summary)
+	}
+}
+
+// run dispatches args to the matching sub-command.
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || strings.HasPrefix(args[0], "-") {
+		usage(out)
+		if len(args) == 0 {
+			return errors.New("no command given")
+		}
+		return nil
+	}
+	cmd, found := commands[args[0]]
+	if !found {
+		usage(out)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return cmd.run(ctx, args[1:], out)
+}
+
+func runMaintain(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("maintain", flag.ContinueOnError)
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, ok := db.(dal.Maintainer)
+	if !ok {
+		return errors.New("the configured database does not support maintenance")
+	}
+	if err = m.Maintain(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "maintenance complete")
+	return nil
+}
+
+func runMigrate(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() { fmt.Fprintln(out, "usage: dbctl migrate up|status") }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	action := fs.Arg(0)
+	if action != "up" && action != "status" {
+		fs.Usage()
+		return fmt.Errorf("unknown migrate action: %q", action)
+	}
+	runner, err := database.NewMigrator()
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	if action == "up" {
+		applied, err := runner.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %s (%s)\n", m.Version, m.Description)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migration(s) applied\n", len(applied))
+		return nil
+	}
+
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATE\tINSTALLED ON")
+	for _, s := range statuses {
+		installed := ""
+		if !s.InstalledOn.IsZero() {
+			installed = s.InstalledOn.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Version, s.Description, s.State, installed)
+	}
+	return w.Flush()
+}
+
+func runExpire(ctx context.Context, args []string, out io.Writer) error {
+	cfg := retention.ConfigFromEnv()
+	fs := flag.NewFlagSet("expire", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dryRun := fs.Bool("dry-run", false, "list the services which would be removed without removing them")
+	fs.DurationVar(&cfg.MaxAge, "max-age", cfg.MaxAge, "maximum age for all services (overrides RETENTION_MAX_AGE)")
+	fs.StringVar(&cfg.ServiceMaxAge, "service-max-age", cfg.ServiceMaxAge, "per service maximum ages, e.g. HTTP=720h (overrides RETENTION_SERVICE_MAX_AGE)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid retention configuration: %w", err)
+	}
+	policy, _ := cfg.Policy()
+	if !policy.Enabled() {
+		return errors.New("no retention policy configured")
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	expirer, ok := db.(dal.Expirer)
+	if !ok {
+		return errors.New("the configured database does not support expiry")
+	}
+
+	var visit func(*models.ScanEntry) error
+	if *dryRun {
+		visit = func(e *models.ScanEntry) error {
+			_, err := fmt.Fprintf(out, "%s\t%d\t%s\t%s\n", e.IP, e.Port, e.Service, time.Unix(e.ScanTimestamp, 0).UTC().Format(time.RFC3339))
+			return err
+		}
+	}
+	count, err := retention.NewJob(expirer, policy, 0).RunOnce(ctx, time.Now(), *dryRun, visit)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(out, "%d service(s) would be removed\n", count)
+	} else {
+		fmt.Fprintf(out, "%d service(s) removed\n", count)
+	}
+	return nil
+}
+
+func runSnapshot(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.SetOutput(out)
+	output := fs.String("o", "", "the file to write the snapshot to; it must not exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return errors.New("no snapshot file given (-o)")
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	snapshotter, ok := db.(dal.Snapshotter)
+	if !ok {
+		return errors.New("the configured database does not support snapshots")
+	}
+	if err = snapshotter.Snapshot(ctx, *output); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "snapshot written to %s\n", *output)
+	return nil
+}
+
+func runExport(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(out)
+	output := fs.String("o", "-", "the file to write to, or - for standard output")
+	var q dal.Query
+	fs.StringVar(&q.Service, "service", "", "only export the entries of the service")
+	fs.Int64Var(&q.ChangedSince, "changed-since", 0, "only export the entries last scanned at or after the unix timestamp")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	reader, ok := db.(dal.Reader)
+	if !ok {
+		return errors.New("the configured database does not support reads")
+	}
+	if *output == "-" {
+		_, err = export.ExportJSONL(ctx, out, reader, q)
+		return err
+	}
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	count, err := export.ExportJSONL(ctx, f, reader, q)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d entries exported to %s\n", count, *output)
+	return nil
+}
+
+func runExportParquet(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export-parquet", flag.ContinueOnError)
+	fs.SetOutput(out)
+	opts := export.ParquetOptions{}
+	fs.StringVar(&opts.Dir, "dir", "", "the directory to write the datasets and their watermarks to")
+	fs.BoolVar(&opts.Incremental, "incremental", false, "only export the entries scanned since the last export")
+	fs.DurationVar(&opts.Lookback, "lookback", 0, "how far before the watermark an incremental export starts, to include late scans")
+	fs.BoolVar(&opts.History, "history", true, "also export the history of databases which keep it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.Dir == "" {
+		return errors.New("no export directory given (-dir)")
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	reader, ok := db.(dal.Reader)
+	if !ok {
+		return errors.New("the configured database does not support reads")
+	}
+	results, err := export.ExportParquet(ctx, reader, opts)
+	for _, r := range results {
+		fmt.Fprintf(out, "%s: %d row(s) exported to %d file(s), watermark %d\n", r.Dataset, r.Rows, r.Files, r.Watermark)
+	}
+	return err
+}
+
+func runImport(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	input := fs.String("i", "-", "the file to read from, or - for standard input")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	in := io.Reader(os.Stdin)
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// Entries are upserted, so only those newer than the stored entries are written and an import can be repeated
+	count := 0
+	if batcher, ok := db.(dal.BatchUpserter); ok {
+		// Databases which batch their writes are given whole batches, rather than waiting to batch single upserts
+		batchSize := config.ConfigFromEnv().MaxBatchSize()
+		batch := []*models.ScanEntry{}
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := batcher.UpsertBatch(ctx, batch); err != nil {
+				return err
+			}
+			count += len(batch)
+			batch = batch[:0]
+			return nil
+		}
+		err = export.ReadJSONL(in, func(entry *models.ScanEntry) error {
+			if batch = append(batch, entry); len(batch) >= batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+	} else {
+		err = export.ReadJSONL(in, func(entry *models.ScanEntry) error {
+			if err := db.Upsert(ctx, entry); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+	}
+	fmt.Fprintf(out, "%d entries imported\n", count)
+	return err
+}
+
+func runFingerprint(_ context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: dbctl fingerprint [-rules <file>] -service <service> <banner>...")
+		fmt.Fprintln(out, "       dbctl fingerprint [-rules <file>] -i <file>")
+		fs.PrintDefaults()
+	}
+	rulesFile := fs.String("rules", fingerprint.ConfigFromEnv().File, "the rule file (overrides FINGERPRINT_RULES)")
+	service := fs.String("service", "", "the service of the banners given as arguments")
+	input := fs.String("i", "", "the file of scan entries to read the services and banners from, or - for standard input")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *rulesFile == "" {
+		return errors.New("no rule file given (-rules or FINGERPRINT_RULES)")
+	}
+	if (*input == "") == (fs.NArg() == 0) {
+		fs.Usage()
+		return errors.New("either banners or an input file (-i) must be given")
+	}
+	if fs.NArg() > 0 && *service == "" {
+		return errors.New("no service given for the banners (-service)")
+	}
+	f, err := os.Open(*rulesFile)
+	if err != nil {
+		return err
+	}
+	rules, err := fingerprint.Parse(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BANNER\tSERVICE\tRULE\tVENDOR\tPRODUCT\tVERSION\tCPE")
+	total, matched := 0, 0
+	test := func(name, service, banner string) {
+		total++
+		rule, fp := rules.Match(service, banner)
+		if rule == nil {
+			fmt.Fprintf(w, "%s\t%s\tno match\t\t\t\t\n", name, service)
+			return
+		}
+		matched++
+		fmt.Fprintf(w, "%s\t%s\tline %d\t%s\t%s\t%s\t%s\n", name, service, rule.Line, fp.Vendor, fp.Product, fp.Version, fp.CPE)
+	}
+	if *input == "" {
+		for _, arg := range fs.Args() {
+			banner, err := unescape(arg)
+			if err != nil {
+				return fmt.Errorf("invalid banner %q: %w", arg, err)
+			}
+			test(arg, *service, banner)
+		}
+	} else {
+		in := io.Reader(os.Stdin)
+		if *input != "-" {
+			f, err := os.Open(*input)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		err = export.ReadJSONL(in, func(entry *models.ScanEntry) error {
+			test(netip.AddrPortFrom(entry.IP, uint16(entry.Port)).String(), entry.Service, entry.Response)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d of %d banner(s) matched\n", matched, total)
+	return nil
+}
+
+// unescape interprets the Go escape sequences of a banner given as an argument, e.g. \r\n, so that banners spanning
+// several lines can be given on the command line.
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for s != "" {
+		r, multibyte, rest, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", err
+		}
+		if multibyte {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte(byte(r))
+		}
+		s = rest
+	}
+	return b.String(), nil
+}
+
+func main() {
+	zap.ReplaceGlobals(zap.L().Named("dbctl"))
+	// Interrupting a long running command (export, import, snapshot, expire…) cancels it, so it stops cleanly and
+	// closes the database; a second interrupt kills it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Stdout)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+// Run this test inside the main package to validate the command dispatch
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/bolt"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/memory"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// batchingDB is a memory database which records the size of each batch written to it.
+type batchingDB struct {
+	*memory.DB
+}
+
+var batchSizes []int
+
+func (db *batchingDB) UpsertBatch(ctx context.Context, entries []*models.ScanEntry) error {
+	batchSizes = append(batchSizes, len(entries))
+	for _, e := range entries {
+		if err := db.Upsert(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	database.RegisterDB("batching", func(cfg *config.Config) (dal.Scan, error) {
+		return &batchingDB{DB: memory.NewDB()}, nil
+	})
+}
+
+var _ = Describe("Dbctl", func() {
+	var (
+		out        *bytes.Buffer
+		restoreMap EnvMap
+		noopEnv    = EnvMap{
+			"DATABASE_TYPE":     StringPointer("noop"),
+			"DATABASE_HOST":     StringPointer("localhost"),
+			"DATABASE_USER":     StringPointer("user"),
+			"DATABASE_PASSWORD": StringPointer("password"),
+			"DATABASE_PORT":     StringPointer("5432"),
+			"DATABASE_NAME":     StringPointer("dbname"),
+		}
+	)
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		restoreMap = noopEnv.SetupEnv()
+	})
+	AfterEach(func() {
+		restoreMap.SetupEnv()
+	})
+	Context("command dispatch", func() {
+		It("should print usage and fail without a command", func() {
+			Expect(run(context.Background(), nil, out)).To(MatchError("no command given"))
+			Expect(out.String()).To(ContainSubstring("usage: dbctl <command> [options]"))
+		})
+		It("should print usage for help", func() {
+			Expect(run(context.Background(), []string{"help"}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("maintain"))
+		})
+		It("should fail for an unknown command", func() {
+			Expect(run(context.Background(), []string{"bogus"}, out)).To(MatchError("unknown command: bogus"))
+		})
+	})
+	Context("maintain", func() {
+		It("should fail when the database does not support maintenance", func() {
+			err := run(context.Background(), []string{"maintain"}, out)
+			Expect(err).To(MatchError("the configured database does not support maintenance"))
+		})
+		It("should fail with an invalid database configuration", func() {
+			restore := EnvMap{"DATABASE_HOST": nil}.SetupEnv()
+			defer restore.SetupEnv()
+			err := run(context.Background(), []string{"maintain"}, out)
+			Expect(err).To(MatchError(MatchRegexp(`invalid database configuration: .*'Config\.Host'`)))
+		})
+	})
+	Context("expire", func() {
+		It("should fail without a retention policy", func() {
+			restore := EnvMap{"RETENTION_MAX_AGE": nil, "RETENTION_SERVICE_MAX_AGE": nil}.SetupEnv()
+			defer restore.SetupEnv()
+			err := run(context.Background(), []string{"expire", "-dry-run"}, out)
+			Expect(err).To(MatchError("no retention policy configured"))
+		})
+		It("should fail with an invalid retention policy", func() {
+			err := run(context.Background(), []string{"expire", "-service-max-age", "SSH"}, out)
+			Expect(err).To(MatchError(MatchRegexp(`invalid retention configuration: invalid service max age "SSH"`)))
+		})
+		It("should fail when the database does not support expiry", func() {
+			err := run(context.Background(), []string{"expire", "-max-age", "24h"}, out)
+			Expect(err).To(MatchError("the configured database does not support expiry"))
+		})
+	})
+	Context("migrate", func() {
+		It("should fail for an unknown action", func() {
+			err := run(context.Background(), []string{"migrate", "down"}, out)
+			Expect(err).To(MatchError(`unknown migrate action: "down"`))
+			Expect(out.String()).To(ContainSubstring("usage: dbctl migrate up|status"))
+		})
+		It("should fail for a database type without migrations", func() {
+			err := run(context.Background(), []string{"migrate", "status"}, out)
+			Expect(err).To(MatchError("database type has no migrations: noop"))
+		})
+	})
+	Context("snapshot, export and import", func() {
+		var (
+			dir     string
+			entries = []*models.ScanEntry{
+				{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Service: "HTTP", ScanTimestamp: 100, Response: "http"},
+				{IP: netip.MustParseAddr("10.0.0.2"), Port: 22, Service: "SSH", ScanTimestamp: 200, ScanNanos: 5, Response: "ssh"},
+			}
+		)
+		// useBolt configures dbctl to use the bolt database at path
+		useBolt := func(path string) {
+			restore := EnvMap{"DATABASE_TYPE": StringPointer(bolt.DB_BOLT), "DATABASE_PATH": StringPointer(path)}.SetupEnv()
+			DeferCleanup(restore.SetupEnv)
+		}
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			db, err := bolt.Open(filepath.Join(dir, "edge.db"))
+			Expect(err).ToNot(HaveOccurred())
+			for _, e := range entries {
+				Expect(db.Upsert(context.Background(), e)).To(Succeed())
+			}
+			db.Close()
+		})
+		It("should ship the entries of a snapshot to another database", func() {
+			useBolt(filepath.Join(dir, "edge.db"))
+			Expect(run(context.Background(), []string{"snapshot", "-o", filepath.Join(dir, "snapshot.db")}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("snapshot written to"))
+
+			useBolt(filepath.Join(dir, "snapshot.db"))
+			Expect(run(context.Background(), []string{"export", "-o", filepath.Join(dir, "scans.jsonl")}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("2 entries exported to"))
+
+			useBolt(filepath.Join(dir, "central.db"))
+			Expect(run(context.Background(), []string{"import", "-i", filepath.Join(dir, "scans.jsonl")}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("2 entries imported"))
+
+			central, err := bolt.Open(filepath.Join(dir, "central.db"))
+			Expect(err).ToNot(HaveOccurred())
+			defer central.Close()
+			for _, e := range entries {
+				Expect(central.Get(context.Background(), e.IP, e.Port, e.Service)).To(Equal(e))
+			}
+		})
+		It("should export the entries matching the filters to standard output", func() {
+			useBolt(filepath.Join(dir, "edge.db"))
+			Expect(run(context.Background(), []string{"export", "-service", "SSH"}, out)).To(Succeed())
+			Expect(out.String()).To(Equal(`{"ip":"10.0.0.2","port":22,"service":"SSH","timestamp":200,"timestamp_nanos":5,"response":"ssh"}` + "\n"))
+		})
+		It("should not overwrite an existing export", func() {
+			useBolt(filepath.Join(dir, "edge.db"))
+			Expect(os.WriteFile(filepath.Join(dir, "scans.jsonl"), nil, 0o644)).To(Succeed())
+			Expect(run(context.Background(), []string{"export", "-o", filepath.Join(dir, "scans.jsonl")}, out)).To(MatchError(os.ErrExist))
+		})
+		It("should report the line of an entry which cannot be imported", func() {
+			useBolt(filepath.Join(dir, "edge.db"))
+			input := filepath.Join(dir, "invalid.jsonl")
+			Expect(os.WriteFile(input, []byte(`{"ip":"10.0.0.3","port":80,"service":"HTTP","timestamp":100,"response":"ok"}`+"\n"+`{"ip":"10.0.0.3","port":80,"service":"HTTP","timestamp":0,"response":"ok"}`+"\n"), 0o644)).To(Succeed())
+			err := run(context.Background(), []string{"import", "-i", input}, out)
+			Expect(err).To(MatchError(models.ErrInvalidEntry))
+			Expect(err).To(MatchError(HavePrefix("line 2: ")))
+			Expect(out.String()).To(ContainSubstring("1 entries imported"))
+		})
+		It("should import in batches to databases which batch their writes", func() {
+			useBolt(filepath.Join(dir, "edge.db"))
+			Expect(run(context.Background(), []string{"export", "-o", filepath.Join(dir, "scans.jsonl")}, out)).To(Succeed())
+			restore := EnvMap{"DATABASE_TYPE": StringPointer("batching"), "DATABASE_BATCH_SIZE": StringPointer("1")}.SetupEnv()
+			defer restore.SetupEnv()
+			batchSizes = nil
+			Expect(run(context.Background(), []string{"import", "-i", filepath.Join(dir, "scans.jsonl")}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("2 entries imported"))
+			Expect(batchSizes).To(Equal([]int{1, 1}))
+		})
+		It("should fail to snapshot a database which does not support snapshots", func() {
+			err := run(context.Background(), []string{"snapshot", "-o", filepath.Join(dir, "snapshot.db")}, out)
+			Expect(err).To(MatchError("the configured database does not support snapshots"))
+		})
+		It("should export the entries to Parquet files", func() {
+			useBolt(filepath.Join(dir, "edge.db"))
+			lake := filepath.Join(dir, "lake")
+			Expect(run(context.Background(), []string{"export-parquet", "-dir", lake, "-incremental"}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("scan_data: 2 row(s) exported to 2 file(s), watermark 200"))
+			Expect(filepath.Join(lake, "scan_data", "date=1970-01-01", "service=SSH")).To(BeADirectory())
+			Expect(filepath.Join(lake, "_watermark.json")).To(BeARegularFile())
+		})
+		It("should fail to export Parquet files without a directory", func() {
+			err := run(context.Background(), []string{"export-parquet"}, out)
+			Expect(err).To(MatchError("no export directory given (-dir)"))
+		})
+		It("should fail to export a database which does not support reads", func() {
+			err := run(context.Background(), []string{"export"}, out)
+			Expect(err).To(MatchError("the configured database does not support reads"))
+		})
+	})
+	Context("fingerprint", func() {
+		var rules string
+		BeforeEach(func() {
+			rules = filepath.Join(GinkgoT().TempDir(), "fingerprint.rules")
+			Expect(os.WriteFile(rules, []byte("# SSH\n"+`SSH m|^SSH-[\d.]+-OpenSSH_([\w.]+)| vendor=openbsd product=openssh version=$1`+"\n"+
+				`HTTP m|^server: nginx/([\d.]+)|im vendor=f5 product=nginx version=$1`+"\n"), 0o644)).To(Succeed())
+		})
+		It("should fingerprint the banners given as arguments", func() {
+			err := run(context.Background(), []string{"fingerprint", "-rules", rules, "-service", "HTTP",
+				`HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\n`, "HTTP/1.1 404 Not Found"}, out)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out.String()).To(MatchRegexp(`HTTP\s+line 3\s+f5\s+nginx\s+1\.18\.0\s+cpe:2\.3:a:f5:nginx:1\.18\.0:\*`))
+			Expect(out.String()).To(MatchRegexp(`HTTP/1\.1 404 Not Found\s+HTTP\s+no match`))
+			Expect(out.String()).To(HaveSuffix("1 of 2 banner(s) matched\n"))
+		})
+		It("should fingerprint the scan entries of an export", func() {
+			input := filepath.Join(GinkgoT().TempDir(), "scans.jsonl")
+			Expect(os.WriteFile(input, []byte(`{"ip":"10.0.0.2","port":22,"service":"SSH","timestamp":200,"response":"SSH-2.0-OpenSSH_9.6 Ubuntu"}`+"\n"), 0o644)).To(Succeed())
+			Expect(run(context.Background(), []string{"fingerprint", "-rules", rules, "-i", input}, out)).To(Succeed())
+			Expect(out.String()).To(MatchRegexp(`10\.0\.0\.2:22\s+SSH\s+line 2\s+openbsd\s+openssh\s+9\.6\s`))
+			Expect(out.String()).To(HaveSuffix("1 of 1 banner(s) matched\n"))
+		})
+		It("should use the rule file of the environment", func() {
+			restore := EnvMap{"FINGERPRINT_RULES": StringPointer(rules)}.SetupEnv()
+			defer restore.SetupEnv()
+			Expect(run(context.Background(), []string{"fingerprint", "-service", "ssh", "SSH-2.0-OpenSSH_9.6"}, out)).To(Succeed())
+			Expect(out.String()).To(HaveSuffix("1 of 1 banner(s) matched\n"))
+		})
+		DescribeTable("should fail with invalid arguments",
+			func(args []string, expected string) {
+				args = append([]string{"fingerprint", "-rules", rules}, args...)
+				Expect(run(context.Background(), args, out)).To(MatchError(expected))
+			},
+			Entry("without banners", nil, "either banners or an input file (-i) must be given"),
+			Entry("with both banners and an input file", []string{"-i", "-", "banner"}, "either banners or an input file (-i) must be given"),
+			Entry("without a service", []string{"banner"}, "no service given for the banners (-service)"),
+			Entry("with an invalid escape", []string{"-service", "HTTP", `a\`}, `invalid banner "a\\": invalid syntax`),
+		)
+		It("should fail without a rule file", func() {
+			restore := EnvMap{"FINGERPRINT_RULES": nil}.SetupEnv()
+			defer restore.SetupEnv()
+			err := run(context.Background(), []string{"fingerprint", "-service", "SSH", "SSH-2.0-OpenSSH_9.6"}, out)
+			Expect(err).To(MatchError("no rule file given (-rules or FINGERPRINT_RULES)"))
+		})
+		It("should report an invalid rule", func() {
+			Expect(os.WriteFile(rules, []byte("SSH m|^SSH-|\n"), 0o644)).To(Succeed())
+			err := run(context.Background(), []string{"fingerprint", "-rules", rules, "-service", "SSH", "SSH-2.0"}, out)
+			Expect(err).To(MatchError(ContainSubstring("invalid fingerprint rule on line 1: missing product")))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+// Package coalesce is a write-behind buffer in front of a database which keeps only the newest entry of each service
+// (ip, port, service) within a flush window, so that a service which is rescanned many times per window is written
+// once. Every entry added is reported done, with the result of writing the entry which superseded it, only once the
+// surviving entry has been written, so that the messages of coalesced entries can be acknowledged safely.
+// Databases which keep a history of every observation (dal.HistoryUpserter) record the superseded entries in it
+// together with the surviving entry.
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	// FlushConcurrency is the number of concurrent upserts used to flush databases which do not write batches.
+	FlushConcurrency = 16
+)
+
+var (
+	// ErrClosed is reported for entries added once the buffer is closed.
+	ErrClosed = errors.New("coalescing buffer is closed")
+)
+
+type key struct {
+	ip      netip.Addr
+	port    uint32
+	service string
+}
+
+// pending is the newest entry of a service and the callbacks of every entry it superseded.
+// The superseded entries are only kept for databases which record them in their history.
+type pending struct {
+	entry      *models.ScanEntry
+	superseded []*models.ScanEntry
+	done       []func(err error)
+}
+
+// Buffer coalesces the entries written to a database within each flush window.
+// The pending entries are written every window, or as soon as maxPending services are pending.
+type Buffer struct {
+	db         dal.Scan
+	history    dal.HistoryUpserter
+	window     time.Duration
+	maxPending int
+
+	mu      sync.Mutex
+	pending map[key]*pending
+	closed  bool
+
+	full    chan struct{}
+	closing chan struct{}
+	stopped chan struct{}
+}
+
+// New starts a buffer which flushes to db every window, or once maxPending services are pending.
+// Databases which implement dal.BatchUpserter are written in batches of up to maxPending entries, unless they
+// implement dal.HistoryUpserter, in which case each service is written with the entries it superseded.
+func New(db dal.Scan, window time.Duration, maxPending int) *Buffer {
+	history, _ := db.(dal.HistoryUpserter)
+	b := &Buffer{
+		history:    history,
+		db:         db,
+		window:     window,
+		maxPending: maxPending,
+		pending:    map[key]*pending{},
+		full:       make(chan struct{}, 1),
+		closing:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add adds the entry to the buffer, replacing the pending entry of its service if it is newer.
+// done is called once the surviving entry of the service has been written, with the error of the write if any;
+// it is called from the goroutine flushing the buffer, so it must not block.
+func (b *Buffer) Add(entry *models.ScanEntry, done func(err error)) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		done(ErrClosed)
+		return
+	}
+	k := key{ip: entry.IP, port: entry.Port, service: entry.Service}
+	p, found := b.pending[k]
+	if !found {
+		p = &pending{entry: entry}
+		b.pending[k] = p
+	} else {
+		older := entry
+		if entry.NewerThan(p.entry) {
+			older, p.entry = p.entry, entry
+		}
+		if b.history != nil {
+			p.superseded = append(p.superseded, older)
+		}
+	}
+	p.done = append(p.done, done)
+	full := len(b.pending) >= b.maxPending
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Len returns the number of services pending.
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Close writes the pending entries and stops the buffer; entries added afterwards are reported done with ErrClosed.
+func (b *Buffer) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.closing)
+	}
+	b.mu.Unlock()
+	<-b.stopped
+}
+
+func (b *Buffer) run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.full:
+			b.flush()
+		case <-b.closing:
+			b.flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending entries and reports the entries of each service done.
+func (b *Buffer) flush() {
+	b.mu.Lock()
+	flushing := b.pending
+	b.pending = map[key]*pending{}
+	b.mu.Unlock()
+	if len(flushing) == 0 {
+		return
+	}
+	all := make([]*pending, 0, len(flushing))
+	for _, p := range flushing {
+		all = append(all, p)
+	}
+	// The buffer is written independently of the context of any message, and the database applies its write timeout
+	ctx := context.Background()
+	if batcher, ok := b.db.(dal.BatchUpserter); ok && b.history == nil {
+		for start := 0; start < len(all); start += b.maxPending {
+			batch := all[start:min(start+b.maxPending, len(all))]
+			entries := make([]*models.ScanEntry, len(batch))
+			for i, p := range batch {
+				entries[i] = p.entry
+			}
+			err := batcher.UpsertBatch(ctx, entries)
+			if err != nil {
+				zap.S().Errorw("failed to write coalesced batch", "error", err, "entries", len(entries))
+			}
+			for _, p := range batch {
+				p.report(err)
+			}
+		}
+		return
+	}
+	work := make(chan *pending)
+	var wg sync.WaitGroup
+	for range min(FlushConcurrency, len(all)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				err := b.upsert(ctx, p)
+				if err != nil {
+					zap.S().Errorw("failed to write coalesced entry", "error", err, "entry", p.entry)
+				}
+				p.report(err)
+			}
+		}()
+	}
+	for _, p := range all {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+}
+
+// upsert writes the newest entry of the service, with the entries it superseded if the database records them.
+func (b *Buffer) upsert(ctx context.Context, p *pending) error {
+	if len(p.superseded) > 0 {
+		return b.history.UpsertWithHistory(ctx, p.entry, p.superseded)
+	}
+	return b.db.Upsert(ctx, p.entry)
+}
+
+// report reports every entry of the service done.
+func (p *pending) report(err error) {
+	for _, done := range p.done {
+		done(err)
+	}
+}
@@ -0,0 +1,163 @@
+package coalesce_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/coalesce"
+	"github.com/censys/scan-takehome/internal/database/memory"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// batchDB is a memory database which also writes batches, recording the size of each.
+type batchDB struct {
+	*memory.DB
+	mu      sync.Mutex
+	batches []int
+}
+
+func (db *batchDB) UpsertBatch(ctx context.Context, entries []*models.ScanEntry) error {
+	db.mu.Lock()
+	db.batches = append(db.batches, len(entries))
+	db.mu.Unlock()
+	for _, e := range entries {
+		if err := db.Upsert(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyDB is a batching database which also keeps a history of every observation.
+type historyDB struct {
+	batchDB
+	history []*models.ScanEntry
+}
+
+func (db *historyDB) UpsertWithHistory(ctx context.Context, entry *models.ScanEntry, superseded []*models.ScanEntry) error {
+	if err := db.Upsert(ctx, entry); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.history = append(db.history, superseded...)
+	return nil
+}
+
+// results collects the results reported for the entries added to a buffer.
+type results struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *results) done(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *results) reported() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error{}, r.errs...)
+}
+
+var _ = Describe("Buffer", func() {
+	var (
+		db      *memory.DB
+		reports *results
+	)
+	entry := func(port uint32, timestamp int64, response string) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr("10.0.0.1"), Port: port, Service: "HTTP", ScanTimestamp: timestamp, Response: response}
+	}
+	BeforeEach(func() {
+		db = memory.NewDB()
+		reports = &results{}
+	})
+
+	It("should write only the newest entry of a service, reporting every entry once it is written", func() {
+		b := coalesce.New(db, time.Hour, 100)
+		b.Add(entry(80, 200, "newer"), reports.done)
+		b.Add(entry(80, 300, "newest"), reports.done)
+		b.Add(entry(80, 100, "older"), reports.done)
+		b.Add(entry(22, 100, "other"), reports.done)
+		Expect(b.Len()).To(Equal(2))
+		Expect(db.Entries()).To(BeEmpty())
+		Expect(reports.reported()).To(BeEmpty())
+
+		b.Close()
+		Expect(db.Entries()).To(ConsistOf(entry(80, 300, "newest"), entry(22, 100, "other")))
+		Expect(reports.reported()).To(Equal([]error{nil, nil, nil, nil}))
+	})
+	It("should flush every window", func() {
+		b := coalesce.New(db, 10*time.Millisecond, 100)
+		defer b.Close()
+		b.Add(entry(80, 100, "flushed"), reports.done)
+		Eventually(reports.reported).Should(Equal([]error{nil}))
+		Expect(db.Entries()).To(Equal([]*models.ScanEntry{entry(80, 100, "flushed")}))
+	})
+	It("should flush as soon as the most services are pending", func() {
+		b := coalesce.New(db, time.Hour, 2)
+		defer b.Close()
+		b.Add(entry(80, 100, "a"), reports.done)
+		b.Add(entry(80, 200, "b"), reports.done)
+		Consistently(reports.reported).WithTimeout(20 * time.Millisecond).Should(BeEmpty())
+		b.Add(entry(22, 100, "c"), reports.done)
+		Eventually(reports.reported).Should(HaveLen(3))
+	})
+	It("should write databases which support it in batches", func() {
+		batching := &batchDB{DB: db}
+		b := coalesce.New(batching, time.Hour, 2)
+		for port := range uint32(5) {
+			b.Add(entry(port+1, 100, "batched"), reports.done)
+		}
+		b.Close()
+		Expect(db.Entries()).To(HaveLen(5))
+		Expect(batching.batches).To(SatisfyAll(HaveEach(BeNumerically("<=", 2)), WithTransform(sum, Equal(5))))
+	})
+	It("should record the superseded entries of databases which keep a history", func() {
+		historian := &historyDB{batchDB: batchDB{DB: db}}
+		b := coalesce.New(historian, time.Hour, 100)
+		b.Add(entry(80, 200, "newer"), reports.done)
+		b.Add(entry(80, 300, "newest"), reports.done)
+		b.Add(entry(80, 100, "older"), reports.done)
+		b.Add(entry(22, 100, "other"), reports.done)
+		b.Close()
+		Expect(db.Entries()).To(ConsistOf(entry(80, 300, "newest"), entry(22, 100, "other")))
+		Expect(historian.history).To(ConsistOf(entry(80, 200, "newer"), entry(80, 100, "older")))
+		Expect(historian.batches).To(BeEmpty())
+		Expect(reports.reported()).To(Equal([]error{nil, nil, nil, nil}))
+	})
+	It("should report the error of a failed write to every entry it superseded", func() {
+		db.SetFaults(memory.Faults{FailNext: 1})
+		b := coalesce.New(db, time.Hour, 100)
+		b.Add(entry(80, 100, "older"), reports.done)
+		b.Add(entry(80, 200, "newer"), reports.done)
+		b.Close()
+		Expect(reports.reported()).To(HaveLen(2))
+		Expect(reports.reported()).To(HaveEach(HaveOccurred()))
+		Expect(db.Entries()).To(BeEmpty())
+	})
+	It("should reject entries once closed", func() {
+		b := coalesce.New(db, time.Hour, 100)
+		b.Close()
+		b.Close()
+		b.Add(entry(80, 100, "late"), reports.done)
+		Expect(reports.reported()).To(HaveLen(1))
+		Expect(errors.Is(reports.reported()[0], coalesce.ErrClosed)).To(BeTrue())
+	})
+})
+
+func sum(sizes []int) int {
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	return total
+}
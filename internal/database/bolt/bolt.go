@@ -0,0 +1,297 @@
+// Package bolt is an embedded database on a single bbolt file, for processors at sites without a database server.
+// It keeps the latest entry of each service with the same latest-wins semantics as postgres and supports reads,
+// expiry and snapshots (see dal.Snapshotter), which can be exported and imported into the central database with dbctl.
+//
+// The file is locked while it is open, so only one process may use it at a time.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/routing"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	DB_BOLT = "bolt"
+
+	// DefaultLockTimeout is how long opening the file waits for another process to release it.
+	DefaultLockTimeout = time.Second
+	// CompactTxSize is the size of the transactions used to copy entries into a snapshot.
+	CompactTxSize = 64 << 20
+)
+
+var (
+	scansBucket = []byte("scans")
+)
+
+func init() {
+	database.RegisterDB(DB_BOLT, New)
+}
+
+// DB is a bolt database which is safe for concurrent use.
+type DB struct {
+	db   *bbolt.DB
+	path string
+}
+
+// New opens (or creates) the database at cfg.Path.
+func New(cfg *config.Config) (dal.Scan, error) {
+	return Open(cfg.Path)
+}
+
+// Open opens (or creates) the database at path, creating its directory if required.
+func Open(path string) (*DB, error) {
+	db, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(scansBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db, path: path}, nil
+}
+
+// openFile opens (or creates) the bolt file at path.
+func openFile(path string) (*bbolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{
+		Timeout: DefaultLockTimeout,
+		// The freelist is rebuilt when the file is opened rather than written by every transaction
+		NoFreelistSync: true,
+		FreelistType:   bbolt.FreelistMapType,
+	})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("failed to open %s: the file is in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func (db *DB) Close() {
+	db.db.Close()
+}
+
+func (db *DB) observe(operation string, start time.Time, err error) {
+	metrics.ObserveDatabase(operation, routing.RolePrimary, db.path, start, err)
+}
+
+func (db *DB) Upsert(ctx context.Context, entry *models.ScanEntry) error {
+	start := time.Now()
+	err := db.upsert(ctx, entry)
+	db.observe("upsert", start, err)
+	return err
+}
+
+func (db *DB) upsert(ctx context.Context, entry *models.ScanEntry) error {
+	if err := models.ValidateStorable(entry); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		// A write which waited for the lock past the end of its context is abandoned
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(scansBucket)
+		key := encodeKey(entry.IP, entry.Port, entry.Service)
+		if value := bucket.Get(key); value != nil {
+			stored, err := decodeEntry(key, value)
+			if err != nil {
+				return err
+			}
+			if !entry.NewerThan(stored) {
+				return nil
+			}
+		}
+		return bucket.Put(key, encodeValue(entry))
+	})
+}
+
+func (db *DB) Get(ctx context.Context, ip netip.Addr, port uint32, service string) (*models.ScanEntry, error) {
+	start := time.Now()
+	entry, err := db.get(ctx, ip, port, service)
+	db.observe("get", start, err)
+	return entry, err
+}
+
+func (db *DB) get(ctx context.Context, ip netip.Addr, port uint32, service string) (entry *models.ScanEntry, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	err = db.db.View(func(tx *bbolt.Tx) error {
+		key := encodeKey(models.CanonicalAddr(ip), port, service)
+		value := tx.Bucket(scansBucket).Get(key)
+		if value == nil {
+			return dal.ErrNotFound
+		}
+		entry, err = decodeEntry(key, value)
+		return err
+	})
+	return entry, err
+}
+
+func (db *DB) List(ctx context.Context, q dal.Query) ([]*models.ScanEntry, error) {
+	start := time.Now()
+	entries, err := db.list(ctx, q)
+	db.observe("list", start, err)
+	return entries, err
+}
+
+func (db *DB) list(ctx context.Context, q dal.Query) ([]*models.ScanEntry, error) {
+	entries := []*models.ScanEntry{}
+	err := db.stream(ctx, q, func(entry *models.ScanEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Stream visits the entries within a single read transaction, which does not block writes.
+func (db *DB) Stream(ctx context.Context, q dal.Query, visit func(*models.ScanEntry) error) error {
+	start := time.Now()
+	err := db.stream(ctx, q, visit)
+	db.observe("stream", start, err)
+	return err
+}
+
+func (db *DB) stream(ctx context.Context, q dal.Query, visit func(*models.ScanEntry) error) error {
+	var prefix []byte
+	if q.IP.IsValid() {
+		prefix = ipPrefix(models.CanonicalAddr(q.IP))
+	}
+	return db.db.View(func(tx *bbolt.Tx) error {
+		visited := 0
+		c := tx.Bucket(scansBucket).Cursor()
+		for key, value := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			entry, err := decodeEntry(key, value)
+			if err != nil {
+				return err
+			}
+			if (q.Service != "" && entry.Service != q.Service) || entry.ScanTimestamp < q.ChangedSince {
+				continue
+			}
+			if err = visit(entry); err != nil {
+				return err
+			}
+			if visited++; q.Limit > 0 && visited == q.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// selected reports whether the entry is selected by rule.
+func selected(entry *models.ScanEntry, rule dal.ExpiryRule) bool {
+	if entry.ScanTimestamp >= rule.Before {
+		return false
+	}
+	if rule.Service != "" {
+		return entry.Service == rule.Service
+	}
+	return !slices.Contains(rule.Exclude, entry.Service)
+}
+
+func (db *DB) Expire(ctx context.Context, rule dal.ExpiryRule, dryRun bool, visit func(*models.ScanEntry) error) (int64, error) {
+	var count int64
+	expire := func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(scansBucket)
+		keys := [][]byte{}
+		err := bucket.ForEach(func(key, value []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			entry, err := decodeEntry(key, value)
+			if err != nil {
+				return err
+			}
+			if !selected(entry, rule) {
+				return nil
+			}
+			if visit != nil {
+				if err = visit(entry); err != nil {
+					return err
+				}
+			}
+			keys = append(keys, bytes.Clone(key))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		count = int64(len(keys))
+		if dryRun {
+			return nil
+		}
+		// Keys are deleted once the iteration is complete, as deleting while iterating skips entries
+		for _, key := range keys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if dryRun {
+		err = db.db.View(expire)
+	} else {
+		err = db.db.Update(expire)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Snapshot writes a compacted copy of the database to a new file at path.
+// The copy is consistent: writes made while it is taken are not included.
+func (db *DB) Snapshot(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("snapshot %s already exists", path)
+	}
+	// The buckets are created by Compact, so the file is opened without them
+	dst, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if err = bbolt.Compact(dst, db.db, CompactTxSize); err != nil {
+		return fmt.Errorf("failed to write snapshot %s: %w", path, err)
+	}
+	return nil
+}
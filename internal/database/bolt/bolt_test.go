@@ -0,0 +1,182 @@
+package bolt_test
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	bbolt "go.etcd.io/bbolt"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/bolt"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/dbtest"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Bolt", func() {
+	var (
+		db   *bolt.DB
+		path string
+		ctx  = context.Background()
+	)
+	entry := func(ip string, port uint32, service string, timestamp int64, response string) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr(ip), Port: port, Service: service, ScanTimestamp: timestamp, Response: response}
+	}
+	list := func(db *bolt.DB) []*models.ScanEntry {
+		entries, err := db.List(ctx, dal.Query{})
+		Expect(err).ToNot(HaveOccurred())
+		return entries
+	}
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "scans.db")
+		var err error
+		db, err = bolt.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(func() { db.Close() })
+	})
+
+	Describe("Conformance", func() {
+		dbtest.Conformance(func() (dal.Scan, error) {
+			return bolt.Open(filepath.Join(GinkgoT().TempDir(), "conformance.db"))
+		}, dbtest.Options{})
+	})
+
+	It("should be registered as a database type", func() {
+		restoreMap := EnvMap{
+			"DATABASE_TYPE":     StringPointer(bolt.DB_BOLT),
+			"DATABASE_PATH":     StringPointer(filepath.Join(GinkgoT().TempDir(), "registered", "scans.db")),
+			"DATABASE_HOST":     nil,
+			"DATABASE_USER":     nil,
+			"DATABASE_PASSWORD": nil,
+			"DATABASE_PORT":     nil,
+			"DATABASE_NAME":     nil,
+		}.SetupEnv()
+		defer restoreMap.SetupEnv()
+		scanDB, err := database.New()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scanDB).To(BeAssignableToTypeOf(db))
+		scanDB.Close()
+	})
+
+	It("should keep the entries when reopened", func() {
+		e := entry("10.0.0.1", 80, "HTTP", 100, "persisted")
+		e.ScanNanos = 999999999
+		Expect(db.Upsert(ctx, e)).To(Succeed())
+		db.Close()
+		var err error
+		db, err = bolt.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(list(db)).To(Equal([]*models.ScanEntry{e}))
+	})
+
+	It("should not open a file which is in use", func() {
+		_, err := bolt.Open(path)
+		Expect(err).To(MatchError(ContainSubstring("in use by another process")))
+	})
+
+	It("should fail to read an entry in an unknown encoding", func() {
+		Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "stored"))).To(Succeed())
+		db.Close()
+		raw, err := bbolt.Open(path, 0o600, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(raw.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte("scans")).ForEach(func(k, _ []byte) error {
+				return tx.Bucket([]byte("scans")).Put(k, []byte{0xff})
+			})
+		})).To(Succeed())
+		Expect(raw.Close()).To(Succeed())
+
+		db, err = bolt.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Get(ctx, netip.MustParseAddr("10.0.0.1"), 80, "HTTP")
+		Expect(err).To(MatchError(bolt.ErrCorruptEntry))
+	})
+
+	It("should read entries stored in version 1 of the encoding", func() {
+		Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "stored"))).To(Succeed())
+		db.Close()
+		raw, err := bbolt.Open(path, 0o600, nil)
+		Expect(err).ToNot(HaveOccurred())
+		// version 1, scan_date 100 (zigzag varint), scan_nanos 42, asn 64496, then the length prefixed fields
+		v1 := []byte{bolt.EncodingV1, 200, 1, 42, 240, 247, 3}
+		for _, s := range []string{"Example", "NL", `{"server":"nginx"}`, "", "f5", "nginx", "1.18.0", "cpe:2.3:a:f5:nginx:1.18.0"} {
+			v1 = append(append(v1, byte(len(s))), s...)
+		}
+		v1 = append(v1, "stored"...)
+		Expect(raw.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte("scans")).ForEach(func(k, _ []byte) error {
+				return tx.Bucket([]byte("scans")).Put(k, v1)
+			})
+		})).To(Succeed())
+		Expect(raw.Close()).To(Succeed())
+
+		db, err = bolt.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		expected := entry("10.0.0.1", 80, "HTTP", 100, "stored")
+		expected.ScanNanos, expected.ASN, expected.ASOrg, expected.Country = 42, 64496, "Example", "NL"
+		expected.Parsed = map[string]string{"server": "nginx"}
+		expected.Fingerprint = models.Fingerprint{Vendor: "f5", Product: "nginx", Version: "1.18.0", CPE: "cpe:2.3:a:f5:nginx:1.18.0"}
+		Expect(db.Get(ctx, netip.MustParseAddr("10.0.0.1"), 80, "HTTP")).To(Equal(expected))
+	})
+
+	Context("reads", func() {
+		BeforeEach(func() {
+			Expect(db.Upsert(ctx, entry("10.0.0.10", 80, "HTTP", 400, "http"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.2", 80, "HTTP", 300, "http"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 443, "HTTPS", 200, "https"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 22, "SSH", 100, "ssh"))).To(Succeed())
+		})
+		DescribeTable("should list the entries matching the query, ordered by ip, port and service",
+			func(q dal.Query, expected ...*models.ScanEntry) {
+				found, err := db.List(ctx, q)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(found).To(Equal(expected))
+			},
+			Entry("everything", dal.Query{},
+				entry("10.0.0.1", 22, "SSH", 100, "ssh"), entry("10.0.0.1", 443, "HTTPS", 200, "https"),
+				entry("10.0.0.10", 80, "HTTP", 400, "http"), entry("10.0.0.2", 80, "HTTP", 300, "http")),
+			Entry("by ip, excluding ips which share its text as a prefix", dal.Query{IP: netip.MustParseAddr("::ffff:10.0.0.1")},
+				entry("10.0.0.1", 22, "SSH", 100, "ssh"), entry("10.0.0.1", 443, "HTTPS", 200, "https")),
+			Entry("by service changed since", dal.Query{Service: "HTTP", ChangedSince: 350},
+				entry("10.0.0.10", 80, "HTTP", 400, "http")),
+			Entry("with a limit", dal.Query{Limit: 1},
+				entry("10.0.0.1", 22, "SSH", 100, "ssh")),
+		)
+		It("should expire entries selected by the rule", func() {
+			visited := []*models.ScanEntry{}
+			count, err := db.Expire(ctx, dal.ExpiryRule{Exclude: []string{"SSH"}, Before: 350}, true, func(e *models.ScanEntry) error {
+				visited = append(visited, e)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(2)))
+			Expect(visited).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 443, "HTTPS", 200, "https"), entry("10.0.0.2", 80, "HTTP", 300, "http")}))
+			Expect(list(db)).To(HaveLen(4))
+
+			count, err = db.Expire(ctx, dal.ExpiryRule{Exclude: []string{"SSH"}, Before: 350}, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(2)))
+			Expect(list(db)).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 22, "SSH", 100, "ssh"), entry("10.0.0.10", 80, "HTTP", 400, "http")}))
+		})
+		It("should write a snapshot which can be opened as a database", func() {
+			snapshotPath := filepath.Join(GinkgoT().TempDir(), "snapshot.db")
+			Expect(db.Snapshot(ctx, snapshotPath)).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.3", 80, "HTTP", 500, "after the snapshot"))).To(Succeed())
+
+			snapshot, err := bolt.Open(snapshotPath)
+			Expect(err).ToNot(HaveOccurred())
+			defer snapshot.Close()
+			Expect(list(snapshot)).To(Equal(list(db)[:4]))
+		})
+		It("should not overwrite an existing file with a snapshot", func() {
+			snapshotPath := filepath.Join(GinkgoT().TempDir(), "snapshot.db")
+			Expect(os.WriteFile(snapshotPath, []byte("existing"), 0o600)).To(Succeed())
+			Expect(db.Snapshot(ctx, snapshotPath)).To(MatchError(ContainSubstring("already exists")))
+		})
+	})
+})
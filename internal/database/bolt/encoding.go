@@ -0,0 +1,184 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// Entries are stored in the scans bucket under a key of
+//
+//	ip (canonical text form) 0x00 port (4 bytes, big endian) service
+//
+// so that the keys sort in the same order as dal.Reader.List returns entries and the entries of an ip share a prefix.
+// The value holds the remaining fields in a compact binary form, prefixed by its encoding version:
+//
+//	version 1: 0x01 scan_date (varint) scan_nanos (uvarint) asn (uvarint) as_org country parsed (JSON) parse_error
+//	           vendor product version cpe response (remaining bytes)
+//
+// where the fields from as_org to cpe are each prefixed by their length (uvarint).
+//
+// New versions must be added alongside the existing ones, as entries are only re-encoded when they are replaced.
+const (
+	keySeparator = 0x00
+	portLen      = 4
+
+	EncodingV1      byte = 1
+	EncodingCurrent      = EncodingV1
+)
+
+var (
+	// ErrCorruptEntry is returned when a stored key or value cannot be decoded.
+	ErrCorruptEntry = errors.New("corrupt bolt entry")
+)
+
+// encodeKey returns the key of the service of the entry.
+func encodeKey(ip netip.Addr, port uint32, service string) []byte {
+	key := binary.BigEndian.AppendUint32(ipPrefix(ip), port)
+	return append(key, service...)
+}
+
+// ipPrefix returns the prefix shared by the keys of every service of ip.
+func ipPrefix(ip netip.Addr) []byte {
+	return append([]byte(ip.String()), keySeparator)
+}
+
+// encodeValue returns the value stored for the entry, in the current encoding.
+func encodeValue(entry *models.ScanEntry) []byte {
+	parsed := entry.MarshalParsed()
+	fp := entry.Fingerprint
+	value := make([]byte, 0, 1+11*binary.MaxVarintLen64+len(entry.ASOrg)+len(entry.Country)+len(parsed)+
+		len(entry.ParseError)+len(fp.Vendor)+len(fp.Product)+len(fp.Version)+len(fp.CPE)+len(entry.Response))
+	value = append(value, EncodingCurrent)
+	value = binary.AppendVarint(value, entry.ScanTimestamp)
+	value = binary.AppendUvarint(value, uint64(entry.ScanNanos))
+	value = binary.AppendUvarint(value, uint64(entry.ASN))
+	value = appendString(value, entry.ASOrg)
+	value = appendString(value, entry.Country)
+	value = appendString(value, string(parsed))
+	value = appendString(value, entry.ParseError)
+	for _, s := range []string{fp.Vendor, fp.Product, fp.Version, fp.CPE} {
+		value = appendString(value, s)
+	}
+	return append(value, entry.Response...)
+}
+
+// appendString appends s prefixed by its length.
+func appendString(value []byte, s string) []byte {
+	value = binary.AppendUvarint(value, uint64(len(s)))
+	return append(value, s...)
+}
+
+// readString reads a string prefixed by its length, returning the remaining bytes.
+func readString(value []byte) (string, []byte, bool) {
+	size, n := binary.Uvarint(value)
+	if n <= 0 || uint64(len(value)-n) < size {
+		return "", nil, false
+	}
+	return string(value[n : n+int(size)]), value[n+int(size):], true
+}
+
+// decodeEntry decodes a stored key and value.
+func decodeEntry(key, value []byte) (*models.ScanEntry, error) {
+	entry := &models.ScanEntry{}
+	sep := bytes.IndexByte(key, keySeparator)
+	if sep < 0 || len(key) < sep+1+portLen {
+		return nil, fmt.Errorf("%w: invalid key %q", ErrCorruptEntry, key)
+	}
+	var err error
+	if entry.IP, err = netip.ParseAddr(string(key[:sep])); err != nil {
+		return nil, fmt.Errorf("%w: invalid key %q: %w", ErrCorruptEntry, key, err)
+	}
+	entry.Port = binary.BigEndian.Uint32(key[sep+1:])
+	entry.Service = string(key[sep+1+portLen:])
+
+	if len(value) == 0 {
+		return nil, fmt.Errorf("%w: empty value for key %q", ErrCorruptEntry, key)
+	}
+	switch value[0] {
+	case EncodingV1:
+		return decodeV1(entry, value[1:])
+	default:
+		return nil, fmt.Errorf("%w: unknown encoding version %d for key %q", ErrCorruptEntry, value[0], key)
+	}
+}
+
+func decodeV1(entry *models.ScanEntry, value []byte) (*models.ScanEntry, error) {
+	value, err := decodeScanTime(entry, value)
+	if err == nil {
+		value, err = decodeEnrichment(entry, value)
+	}
+	if err == nil {
+		value, err = decodeParsed(entry, value)
+	}
+	if err == nil {
+		value, err = decodeFingerprint(entry, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry.Response = string(value)
+	return entry, nil
+}
+
+// decodeParsed decodes the parsed fields and parse error, returning the remaining bytes.
+func decodeParsed(entry *models.ScanEntry, value []byte) ([]byte, error) {
+	parsed, value, ok := readString(value)
+	if !ok || entry.UnmarshalParsed([]byte(parsed)) != nil {
+		return nil, fmt.Errorf("%w: invalid parsed fields", ErrCorruptEntry)
+	}
+	if entry.ParseError, value, ok = readString(value); !ok {
+		return nil, fmt.Errorf("%w: invalid parse error", ErrCorruptEntry)
+	}
+	return value, nil
+}
+
+// decodeFingerprint decodes the vendor, product, version and cpe, returning the remaining bytes.
+func decodeFingerprint(entry *models.ScanEntry, value []byte) ([]byte, error) {
+	fp := &entry.Fingerprint
+	var ok bool
+	for _, s := range []*string{&fp.Vendor, &fp.Product, &fp.Version, &fp.CPE} {
+		if *s, value, ok = readString(value); !ok {
+			return nil, fmt.Errorf("%w: invalid fingerprint", ErrCorruptEntry)
+		}
+	}
+	return value, nil
+}
+
+// decodeEnrichment decodes the asn, as org and country, returning the remaining bytes.
+func decodeEnrichment(entry *models.ScanEntry, value []byte) ([]byte, error) {
+	asn, n := binary.Uvarint(value)
+	if n <= 0 || asn > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: invalid asn", ErrCorruptEntry)
+	}
+	entry.ASN = uint32(asn)
+	var ok bool
+	if entry.ASOrg, value, ok = readString(value[n:]); !ok {
+		return nil, fmt.Errorf("%w: invalid as org", ErrCorruptEntry)
+	}
+	if entry.Country, value, ok = readString(value); !ok {
+		return nil, fmt.Errorf("%w: invalid country", ErrCorruptEntry)
+	}
+	return value, nil
+}
+
+// decodeScanTime decodes the scan date and nanos, returning the remaining bytes.
+func decodeScanTime(entry *models.ScanEntry, value []byte) ([]byte, error) {
+	timestamp, n := binary.Varint(value)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: invalid scan date", ErrCorruptEntry)
+	}
+	value = value[n:]
+	nanos, n := binary.Uvarint(value)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: invalid scan nanos", ErrCorruptEntry)
+	}
+	entry.ScanTimestamp = timestamp
+	entry.ScanNanos = int64(nanos)
+	return value[n:], nil
+}
@@ -0,0 +1,110 @@
+package clickhouse
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// Batcher groups concurrent upserts into batches (group commit), as clickhouse is built for a few large inserts
+// rather than many small ones. Each upsert waits until its batch has been written and returns the result of the
+// batch, so a message is only acknowledged once its entry is stored.
+//
+// A batch is written once it holds size entries, or interval after its first entry was added.
+type Batcher struct {
+	write    func(entries []*models.ScanEntry) error
+	size     int
+	interval time.Duration
+
+	requests  chan batchRequest
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type batchRequest struct {
+	entry  *models.ScanEntry
+	result chan error
+}
+
+// NewBatcher starts a batcher which writes its batches with write.
+func NewBatcher(size int, interval time.Duration, write func(entries []*models.ScanEntry) error) *Batcher {
+	b := &Batcher{
+		write:    write,
+		size:     size,
+		interval: interval,
+		// Requests are unbuffered so that none can be accepted once the batcher is closing
+		requests: make(chan batchRequest),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add adds the entry to the next batch and waits for the batch to be written.
+// If ctx is done first the context error is returned, although the entry may still be written with its batch.
+func (b *Batcher) Add(ctx context.Context, entry *models.ScanEntry) error {
+	req := batchRequest{entry: entry, result: make(chan error, 1)}
+	select {
+	case b.requests <- req:
+	case <-b.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close writes the pending batch and stops the batcher; it is safe to call more than once.
+// Entries added once the batcher is closing are rejected with ErrClosed.
+func (b *Batcher) Close() {
+	b.closeOnce.Do(func() { close(b.closing) })
+	<-b.done
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	pending := []batchRequest{}
+	timer := time.NewTimer(b.interval)
+	timer.Stop()
+	flush := func() {
+		timer.Stop()
+		if len(pending) == 0 {
+			return
+		}
+		entries := make([]*models.ScanEntry, len(pending))
+		for i, req := range pending {
+			entries[i] = req.entry
+		}
+		err := b.write(entries)
+		for _, req := range pending {
+			req.result <- err
+		}
+		pending = []batchRequest{}
+	}
+	for {
+		select {
+		case req := <-b.requests:
+			if len(pending) == 0 {
+				timer.Reset(b.interval)
+			}
+			pending = append(pending, req)
+			if len(pending) >= b.size {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		case <-b.closing:
+			flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package clickhouse_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/clickhouse"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Batcher", func() {
+	var (
+		mu       sync.Mutex
+		batches  [][]*models.ScanEntry
+		writeErr error
+		ctx      = context.Background()
+	)
+	write := func(entries []*models.ScanEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, entries)
+		return writeErr
+	}
+	written := func() [][]*models.ScanEntry {
+		mu.Lock()
+		defer mu.Unlock()
+		return batches
+	}
+	entry := func(port uint32) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr("10.0.0.1"), Port: port, Service: "HTTP", ScanTimestamp: 100, Response: "ok"}
+	}
+	// addAll adds the entries concurrently and returns the result of each
+	addAll := func(b *clickhouse.Batcher, entries ...*models.ScanEntry) []error {
+		errs := make([]error, len(entries))
+		var wg sync.WaitGroup
+		for i, e := range entries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = b.Add(ctx, e)
+			}()
+		}
+		wg.Wait()
+		return errs
+	}
+	BeforeEach(func() {
+		batches = nil
+		writeErr = nil
+	})
+
+	It("should write a batch once it is full", func() {
+		b := clickhouse.NewBatcher(3, time.Hour, write)
+		defer b.Close()
+		Expect(addAll(b, entry(1), entry(2), entry(3))).To(Equal([]error{nil, nil, nil}))
+		Expect(written()).To(HaveLen(1))
+		Expect(written()[0]).To(ConsistOf(entry(1), entry(2), entry(3)))
+	})
+	It("should write a partial batch after the interval", func() {
+		b := clickhouse.NewBatcher(100, 10*time.Millisecond, write)
+		defer b.Close()
+		Expect(b.Add(ctx, entry(1))).To(Succeed())
+		Expect(written()).To(Equal([][]*models.ScanEntry{{entry(1)}}))
+	})
+	It("should return the error of the batch to every entry in it", func() {
+		writeErr = errors.New("insert failed")
+		b := clickhouse.NewBatcher(2, time.Hour, write)
+		defer b.Close()
+		Expect(addAll(b, entry(1), entry(2))).To(Equal([]error{writeErr, writeErr}))
+	})
+	It("should write the pending batch when closed and reject later entries", func() {
+		b := clickhouse.NewBatcher(100, time.Hour, write)
+		result := make(chan error, 1)
+		go func() { result <- b.Add(ctx, entry(1)) }()
+		// The entry waits for its batch until the batcher is closed
+		Consistently(result).WithTimeout(50 * time.Millisecond).ShouldNot(Receive())
+		b.Close()
+		b.Close()
+		Eventually(result).Should(Receive(BeNil()))
+		Expect(written()).To(Equal([][]*models.ScanEntry{{entry(1)}}))
+		Expect(b.Add(ctx, entry(2))).To(MatchError(clickhouse.ErrClosed))
+	})
+	It("should stop waiting when the context is done", func() {
+		b := clickhouse.NewBatcher(100, time.Hour, write)
+		defer b.Close()
+		cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		Expect(b.Add(cancelCtx, entry(1))).To(MatchError(context.DeadlineExceeded))
+	})
+})
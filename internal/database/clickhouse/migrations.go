@@ -0,0 +1,154 @@
+package clickhouse
+
+import (
+	"context"
+	"embed"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/migrate"
+)
+
+const (
+	CreateHistoryStmt = `CREATE TABLE IF NOT EXISTS ` + migrate.HistoryTable + ` (
+    installed_rank Int32,
+    version Nullable(String),
+    description String,
+    type String,
+    script String,
+    checksum Nullable(Int32),
+    installed_by String,
+    installed_on DateTime DEFAULT now(),
+    execution_time Int32,
+    success Bool
+) ENGINE = MergeTree ORDER BY installed_rank`
+	SelectAppliedStmt = "SELECT version, description, ifNull(checksum, 0), success, installed_on FROM " + migrate.HistoryTable +
+		" WHERE version IS NOT NULL ORDER BY installed_rank"
+	InsertAppliedStmt = "INSERT INTO " + migrate.HistoryTable + " (installed_rank, version, description, type, script, checksum, installed_by, execution_time, success) " +
+		"SELECT toInt32(count() + 1), ?, ?, 'SQL', ?, ?, currentUser(), ?, true FROM " + migrate.HistoryTable
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Migrations returns the embedded clickhouse migrations in version order.
+func Migrations() ([]migrate.Migration, error) {
+	return migrate.Load(migrationFS, "migrations")
+}
+
+// NewMigrator creates a migration runner for the embedded clickhouse migrations.
+func NewMigrator(cfg *config.Config) (migrate.Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := Options(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// A configured statement timeout is meant for the processor's writes, not for long running schema changes
+	delete(opts.Settings, "max_execution_time")
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewRunner(&migrationExecutor{conn: conn}, migrations), nil
+}
+
+// migrationExecutor applies migrations to clickhouse using a Flyway compatible schema history table.
+// Clickhouse has no transactions, so a migration which fails part way is not rolled back and is not recorded;
+// migrations are written to be idempotent (IF NOT EXISTS) so that they can be run again.
+type migrationExecutor struct {
+	conn driver.Conn
+}
+
+func (e *migrationExecutor) Lock(ctx context.Context) (func(), error) {
+	// Clickhouse has no advisory locks; migrations are expected to be run by a single migrate job
+	return func() {}, nil
+}
+
+func (e *migrationExecutor) EnsureHistory(ctx context.Context) error {
+	return e.conn.Exec(ctx, CreateHistoryStmt)
+}
+
+func (e *migrationExecutor) Applied(ctx context.Context) ([]migrate.Applied, error) {
+	var exists uint8
+	if err := e.conn.QueryRow(ctx, "EXISTS TABLE "+migrate.HistoryTable).Scan(&exists); err != nil || exists == 0 {
+		return nil, err
+	}
+	rows, err := e.conn.Query(ctx, SelectAppliedStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := []migrate.Applied{}
+	for rows.Next() {
+		var a migrate.Applied
+		if err := rows.Scan(&a.Version, &a.Description, &a.Checksum, &a.Success, &a.InstalledOn); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+func (e *migrationExecutor) Apply(ctx context.Context, m migrate.Migration) error {
+	start := time.Now()
+	// The native protocol runs a single statement per query
+	for _, stmt := range SplitStatements(m.SQL) {
+		if err := e.conn.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	elapsed := int32(time.Since(start).Milliseconds())
+	return e.conn.Exec(ctx, InsertAppliedStmt, m.Version, m.Description, m.Script, m.Checksum, elapsed)
+}
+
+func (e *migrationExecutor) Close() {
+	e.conn.Close()
+}
+
+// SplitStatements splits a migration into its statements, separated by semicolons outside of quotes and comments.
+// Line comments are removed and empty statements are skipped.
+func SplitStatements(sql string) []string {
+	statements := []string{}
+	var current strings.Builder
+	var quote rune
+	runes := []rune(sql)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			current.WriteRune(r)
+			if r == '\\' && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			current.WriteRune(r)
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case r == ';':
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
@@ -0,0 +1,16 @@
+-- The latest entry of each service is kept by the ReplacingMergeTree engine, which keeps the row with the greatest
+-- version for each (ip, port, service) as parts are merged; reads use FINAL to see the result before the merge.
+-- The version is derived from scan_date: (scan_date * 10^9 + scan_nanos) in the upper 64 bits and the leading
+-- 64 bits of response_hash in the lower, so that scans are ordered as by the other databases.
+-- The table is not partitioned, as rows are only replaced within a partition.
+CREATE TABLE IF NOT EXISTS scan_data (
+    ip String,
+    port UInt32,
+    service LowCardinality(String),
+    scan_date Int64,
+    scan_nanos UInt32,
+    response_hash FixedString(32),
+    response String CODEC(ZSTD(3)),
+    version UInt128
+) ENGINE = ReplacingMergeTree(version)
+ORDER BY (ip, port, service);
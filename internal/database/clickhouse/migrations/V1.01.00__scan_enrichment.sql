@@ -0,0 +1,6 @@
+-- The autonomous system and country of the ip, looked up by the processor from MMDB databases when it stores a scan.
+-- They are empty (0 and '') when no database is configured or the ip is not found, and for scans stored before.
+ALTER TABLE scan_data
+    ADD COLUMN IF NOT EXISTS asn UInt32 DEFAULT 0 AFTER response,
+    ADD COLUMN IF NOT EXISTS as_org LowCardinality(String) DEFAULT '' AFTER asn,
+    ADD COLUMN IF NOT EXISTS country LowCardinality(String) DEFAULT '' AFTER as_org;
@@ -0,0 +1,5 @@
+-- The fields the processor extracted from the response with the parser of the service (empty for services without a
+-- parser), and why the response could not be parsed ('' if it was), e.g. parsed['server'] for HTTP services.
+ALTER TABLE scan_data
+    ADD COLUMN IF NOT EXISTS parsed Map(LowCardinality(String), String) AFTER country,
+    ADD COLUMN IF NOT EXISTS parse_error String DEFAULT '' AFTER parsed;
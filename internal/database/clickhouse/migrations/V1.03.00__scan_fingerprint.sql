@@ -0,0 +1,7 @@
+-- The software the processor identified from the response with the fingerprint rules ('' when no rule matched), and
+-- its CPE 2.3 name, e.g. cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*.
+ALTER TABLE scan_data
+    ADD COLUMN IF NOT EXISTS vendor LowCardinality(String) DEFAULT '' AFTER parse_error,
+    ADD COLUMN IF NOT EXISTS product LowCardinality(String) DEFAULT '' AFTER vendor,
+    ADD COLUMN IF NOT EXISTS product_version String DEFAULT '' AFTER product,
+    ADD COLUMN IF NOT EXISTS cpe String DEFAULT '' AFTER product_version;
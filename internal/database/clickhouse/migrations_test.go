@@ -0,0 +1,35 @@
+package clickhouse_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/clickhouse"
+)
+
+var _ = Describe("Migrations", func() {
+	It("should embed the clickhouse migrations in version order", func() {
+		migrations, err := clickhouse.Migrations()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(migrations)).To(BeNumerically(">=", 4))
+		Expect(migrations[0].Script).To(Equal("V1.00.00__scan_data.sql"))
+		Expect(clickhouse.SplitStatements(migrations[0].SQL)).To(HaveLen(1))
+		Expect(migrations[1].Script).To(Equal("V1.01.00__scan_enrichment.sql"))
+		Expect(clickhouse.SplitStatements(migrations[1].SQL)).To(HaveLen(1))
+		Expect(migrations[2].Script).To(Equal("V1.02.00__scan_parsed.sql"))
+		Expect(clickhouse.SplitStatements(migrations[2].SQL)).To(HaveLen(1))
+		Expect(migrations[3].Script).To(Equal("V1.03.00__scan_fingerprint.sql"))
+		Expect(clickhouse.SplitStatements(migrations[3].SQL)).To(HaveLen(1))
+	})
+	DescribeTable("should split a migration into statements",
+		func(sql string, expected ...string) {
+			Expect(clickhouse.SplitStatements(sql)).To(Equal(expected))
+		},
+		Entry("without a trailing semicolon", "SELECT 1", "SELECT 1"),
+		Entry("skipping empty statements", "SELECT 1;\n;\nSELECT 2;\n", "SELECT 1", "SELECT 2"),
+		Entry("ignoring semicolons in quotes", `SELECT 'a;b', "c;d", `+"`e;f`"+`;`, `SELECT 'a;b', "c;d", `+"`e;f`"),
+		Entry("ignoring escaped quotes", `SELECT 'it\'s;';SELECT 2`, `SELECT 'it\'s;'`, "SELECT 2"),
+		Entry("removing line comments", "-- a comment; with a semicolon\nSELECT 1; -- trailing\n", "SELECT 1"),
+		Entry("keeping dashes in quotes", "SELECT '--';", "SELECT '--'"),
+	)
+})
@@ -1,14 +1,23 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/caarlos0/env"
 	"github.com/go-playground/validator/v10"
 )
 
 // Config holds the configuration database for a postgres connection.
-// Note: This is a minimal configuration setup due to time constraints.
+// The connection may be given as individual parts or as a complete URL (DATABASE_URL), in which case the parts are ignored.
+// Embedded databases are given by their file (DATABASE_PATH) instead, and ignore the parts as well.
 type Config struct {
 	// DBType indicates the type of database (e.g., "postgres").
 	// This field is included for extensibility to support multiple database types in the future.
@@ -19,8 +28,72 @@ type Config struct {
 	Pass   string `env:"DATABASE_PASSWORD" validate:"required"`
 	Port   uint   `env:"DATABASE_PORT" validate:"required,port"`
 	DBName string `env:"DATABASE_NAME" validate:"required"`
+
+	// ReplicaHosts are read replicas (host or host:port) which serve reads; they share the credentials and settings
+	// of the primary connection above, including its port when a replica does not specify one.
+	ReplicaHosts []string `env:"DATABASE_REPLICA_HOSTS" envSeparator:"," validate:"dive,hostname_port|hostname|ip"`
+	// ReplicaCheckInterval is how often the health of the replicas is checked; zero uses DefaultReplicaCheckInterval.
+	ReplicaCheckInterval time.Duration `env:"DATABASE_REPLICA_CHECK_INTERVAL" validate:"min=0"`
+
+	// FanoutSinks lists the databases written to by the fanout database type (e.g. "postgres:required,clickhouse:best-effort:clickhouse://...").
+	FanoutSinks string `env:"DATABASE_FANOUT_SINKS" validate:"required_if=DBType fanout"`
+
+	// Path is the file of an embedded database (e.g. DATABASE_TYPE=bolt), which needs none of the connection settings.
+	Path string `env:"DATABASE_PATH" validate:"required_if=DBType bolt"`
+
+	// URL is a complete connection URL which overrides the individual connection parts above.
+	// Any of the TLS, pool and timeout settings below which are set are added to (or replace those in) the URL.
+	URL string `env:"DATABASE_URL" validate:"omitempty,url"`
+
+	// SSLMode is the postgres sslmode; when unset, DefaultSSLMode is used unless the URL provides one.
+	SSLMode     string `env:"DATABASE_SSLMODE" validate:"omitempty,oneof=disable allow prefer require verify-ca verify-full"`
+	SSLRootCert string `env:"DATABASE_SSLROOTCERT" validate:"omitempty,file"`
+	SSLCert     string `env:"DATABASE_SSLCERT" validate:"required_with=SSLKey,omitempty,file"`
+	SSLKey      string `env:"DATABASE_SSLKEY" validate:"required_with=SSLCert,omitempty,file"`
+
+	// PoolMinConns and PoolMaxConns size the connection pool; zero uses the driver defaults.
+	PoolMinConns int `env:"DATABASE_POOL_MIN_CONNS" validate:"min=0"`
+	PoolMaxConns int `env:"DATABASE_POOL_MAX_CONNS" validate:"min=0"`
+	// ConnMaxLifetime is how long a pooled connection is used before it is replaced; zero uses the driver default.
+	ConnMaxLifetime time.Duration `env:"DATABASE_CONN_MAX_LIFETIME" validate:"min=0"`
+	// StatementTimeout aborts any statement which runs for longer; zero uses the server setting.
+	StatementTimeout time.Duration `env:"DATABASE_STATEMENT_TIMEOUT" validate:"min=0"`
+
+	// HistoryPartitionsAhead is the number of upcoming monthly history partitions kept ready by maintenance.
+	// Zero uses DefaultHistoryPartitionsAhead.
+	HistoryPartitionsAhead int `env:"DATABASE_HISTORY_PARTITIONS_AHEAD" validate:"min=0"`
+	// HistoryRetentionMonths is the number of complete months of history kept before a partition is dropped.
+	// Zero keeps history forever.
+	HistoryRetentionMonths int `env:"DATABASE_HISTORY_RETENTION_MONTHS" validate:"min=0"`
+
+	// BatchSize is the most entries written together by databases which batch their writes (e.g. clickhouse);
+	// zero uses DefaultBatchSize.
+	BatchSize int `env:"DATABASE_BATCH_SIZE" validate:"min=0"`
+	// BatchInterval is the longest an entry waits for its batch to fill before the batch is written;
+	// zero uses DefaultBatchInterval.
+	BatchInterval time.Duration `env:"DATABASE_BATCH_INTERVAL" validate:"min=0"`
+
+	// TTL expires entries of databases which support it (e.g. redis) once they have not been replaced for that long;
+	// zero keeps entries until they are replaced.
+	TTL time.Duration `env:"DATABASE_TTL" validate:"min=0"`
+
+	// WriteTimeout bounds how long a single write may take, in addition to any deadline of the caller's context.
+	// Zero uses DefaultWriteTimeout and a negative value disables the timeout.
+	WriteTimeout time.Duration `env:"DATABASE_WRITE_TIMEOUT"`
 }
 
+const (
+	DefaultHistoryPartitionsAhead = 3
+	DefaultWriteTimeout           = 5 * time.Second
+	DefaultSSLMode                = "disable"
+	DefaultReplicaCheckInterval   = 10 * time.Second
+	DefaultBatchSize              = 10000
+	DefaultBatchInterval          = time.Second
+)
+
+// connectionParts are the fields which are replaced by the URL (or not used by an embedded database) when it is set.
+var connectionParts = []string{"Host", "User", "Pass", "Port", "DBName"}
+
 // ConfigFromEnv configures the postgres from the environment.
 func ConfigFromEnv() *Config {
 	cfg := &Config{}
@@ -30,10 +103,178 @@ func ConfigFromEnv() *Config {
 
 func (c *Config) Validate() error {
 	validate := validator.New(validator.WithRequiredStructEnabled())
-	return validate.Struct(c)
+	var err error
+	if c.URL != "" || c.Path != "" {
+		err = validate.StructExcept(c, connectionParts...)
+	} else {
+		err = validate.Struct(c)
+	}
+	if c.PoolMaxConns > 0 && c.PoolMinConns > c.PoolMaxConns {
+		err = errors.Join(err, fmt.Errorf("pool min connections (%d) must not exceed pool max connections (%d)", c.PoolMinConns, c.PoolMaxConns))
+	}
+	return err
+}
+
+// PartitionsAhead returns the configured HistoryPartitionsAhead or the default if it is not set.
+func (c *Config) PartitionsAhead() int {
+	if c.HistoryPartitionsAhead == 0 {
+		return DefaultHistoryPartitionsAhead
+	}
+	return c.HistoryPartitionsAhead
 }
 
+// ReplicaCheckEvery returns the configured ReplicaCheckInterval or the default if it is not set.
+func (c *Config) ReplicaCheckEvery() time.Duration {
+	if c.ReplicaCheckInterval == 0 {
+		return DefaultReplicaCheckInterval
+	}
+	return c.ReplicaCheckInterval
+}
+
+// MaxBatchSize returns the configured BatchSize or the default if it is not set.
+func (c *Config) MaxBatchSize() int {
+	if c.BatchSize == 0 {
+		return DefaultBatchSize
+	}
+	return c.BatchSize
+}
+
+// BatchFlushEvery returns the configured BatchInterval or the default if it is not set.
+func (c *Config) BatchFlushEvery() time.Duration {
+	if c.BatchInterval == 0 {
+		return DefaultBatchInterval
+	}
+	return c.BatchInterval
+}
+
+// WriteTimeLimit returns the configured WriteTimeout, the default if it is not set, or zero if it is disabled.
+func (c *Config) WriteTimeLimit() time.Duration {
+	switch {
+	case c.WriteTimeout == 0:
+		return DefaultWriteTimeout
+	case c.WriteTimeout < 0:
+		return 0
+	}
+	return c.WriteTimeout
+}
+
+// ConnectionString returns the connection URL with the user name, password and database name escaped.
+// The TLS, pool and timeout settings are added as query parameters, which are understood by pgx.
 func (c *Config) ConnectionString() string {
-	// Normally sslmode would be configurable, but for this take home assignment we will disable it.
-	return fmt.Sprintf("%s://%s:%s@%s:%d/%s?sslmode=disable", c.DBType, c.User, c.Pass, c.Host, c.Port, c.DBName)
+	u := &url.URL{
+		Scheme: c.DBType,
+		User:   url.UserPassword(c.User, c.Pass),
+		Host:   net.JoinHostPort(c.Host, strconv.FormatUint(uint64(c.Port), 10)),
+		Path:   "/" + c.DBName,
+	}
+	query := url.Values{"sslmode": {DefaultSSLMode}}
+	if c.URL != "" {
+		parsed, err := url.Parse(c.URL)
+		if err != nil {
+			// Validate rejects an invalid URL, so it is returned as is for the driver to report
+			return c.URL
+		}
+		u, query = parsed, parsed.Query()
+	}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("sslmode", c.SSLMode)
+	set("sslrootcert", c.SSLRootCert)
+	set("sslcert", c.SSLCert)
+	set("sslkey", c.SSLKey)
+	if c.PoolMinConns > 0 {
+		set("pool_min_conns", strconv.Itoa(c.PoolMinConns))
+	}
+	if c.PoolMaxConns > 0 {
+		set("pool_max_conns", strconv.Itoa(c.PoolMaxConns))
+	}
+	if c.ConnMaxLifetime > 0 {
+		set("pool_max_conn_lifetime", c.ConnMaxLifetime.String())
+	}
+	if c.StatementTimeout > 0 {
+		// Unknown parameters are sent to the server as run-time parameters, which take milliseconds by default
+		set("statement_timeout", strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10))
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ReplicaConnectionStrings returns a connection string for each of the ReplicaHosts, which is the ConnectionString
+// with the host (and port, if given) replaced.
+func (c *Config) ReplicaConnectionStrings() []string {
+	primary, err := url.Parse(c.ConnectionString())
+	if err != nil {
+		return nil
+	}
+	conns := make([]string, 0, len(c.ReplicaHosts))
+	for _, host := range c.ReplicaHosts {
+		replica := *primary
+		replica.Host = host
+		if _, _, err := net.SplitHostPort(host); err != nil && primary.Port() != "" {
+			replica.Host = net.JoinHostPort(host, primary.Port())
+		}
+		conns = append(conns, replica.String())
+	}
+	return conns
+}
+
+// TLSConfig returns the TLS configuration for the SSLMode, for databases whose drivers do not understand the
+// postgres settings; it is nil if TLS is not configured.
+// require encrypts without verifying the server, verify-ca verifies the server certificate chain but not its host
+// name, and verify-full verifies both.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	switch c.SSLMode {
+	case "", "disable", "allow", "prefer":
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.SSLMode != "verify-full"}
+	if c.SSLMode == "verify-ca" {
+		// crypto/tls always verifies the host name with the chain, so the chain is verified here instead
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, tlsConfig.RootCAs)
+		}
+	}
+	if c.SSLRootCert != "" {
+		pem, err := os.ReadFile(c.SSLRootCert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.SSLRootCert)
+		}
+	}
+	if c.SSLCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.SSLCert, c.SSLKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// verifyChain verifies the certificate chain presented by a server against the root certificates, or the system roots
+// if nil, without verifying the host name.
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server presented no certificates")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
 }
@@ -0,0 +1,15 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// BatchUpserter is implemented by databases which store many entries in a single write more efficiently than one
+// entry at a time (e.g. clickhouse).
+type BatchUpserter interface {
+	// UpsertBatch stores every entry as Upsert would. If an error is returned some of the entries may have been
+	// stored; as upserts are idempotent the whole batch can be retried.
+	UpsertBatch(ctx context.Context, entries []*models.ScanEntry) error
+}
@@ -0,0 +1,23 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// ExpiryRule selects the entries of a single service which were last scanned before Before (unix seconds).
+// An empty Service selects every service which is not listed in Exclude.
+type ExpiryRule struct {
+	Service string
+	Exclude []string
+	Before  int64
+}
+
+// Expirer is implemented by databases which can remove stale entries.
+type Expirer interface {
+	// Expire removes the entries selected by rule and returns the number of entries removed.
+	// When dryRun is set nothing is removed and the number of entries which would have been removed is returned.
+	// If visit is not nil it is called for every selected entry.
+	Expire(ctx context.Context, rule ExpiryRule, dryRun bool, visit func(*models.ScanEntry) error) (int64, error)
+}
@@ -0,0 +1,15 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// HistoryUpserter is implemented by databases which keep every observation in a history (e.g. postgres), so that
+// writers which only store the newest of several observations of a service can still record the others.
+type HistoryUpserter interface {
+	// UpsertWithHistory stores the entry as Upsert would, and records the superseded observations of the same service
+	// in the history in the same write, without affecting the latest entry.
+	UpsertWithHistory(ctx context.Context, entry *models.ScanEntry, superseded []*models.ScanEntry) error
+}
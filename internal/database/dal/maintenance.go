@@ -0,0 +1,11 @@
+package dal
+
+import (
+	"context"
+)
+
+// Maintainer is implemented by databases which require periodic housekeeping (e.g. partition management).
+// Maintain must be safe to call repeatedly and concurrently from multiple processors.
+type Maintainer interface {
+	Maintain(ctx context.Context) error
+}
@@ -0,0 +1,30 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// ErrNotFound is returned when a requested scan entry does not exist.
+var ErrNotFound = errors.New("scan entry not found")
+
+// Query selects the entries returned by Reader.List; zero value fields match every entry.
+type Query struct {
+	IP      netip.Addr
+	Service string
+	// ChangedSince only matches entries last scanned at or after the unix timestamp.
+	ChangedSince int64
+	// Limit caps the number of entries returned; zero returns every matching entry.
+	Limit int
+}
+
+// Reader is implemented by databases which can serve queries for the latest scan entries.
+type Reader interface {
+	// Get returns the latest entry for the service, or ErrNotFound.
+	Get(ctx context.Context, ip netip.Addr, port uint32, service string) (*models.ScanEntry, error)
+	// List returns the entries matched by q, ordered by ip, port and service.
+	List(ctx context.Context, q Query) ([]*models.ScanEntry, error)
+}
@@ -1,12 +1,16 @@
 package dal
 
 import (
-	"github.com/censys/scan-takehome/internal/database/models"
+	"context"
+
+	"github.com/censys/scan-takehome/pkg/models"
 )
 
 // Scan represents the actions which can be taken on the Scan database
 type Scan interface {
-	Upsert(entry *models.ScanEntry) error
+	// Upsert stores the entry unless a more recent scan of the same service is already stored.
+	// Implementations must stop and return an error once ctx is done.
+	Upsert(ctx context.Context, entry *models.ScanEntry) error
 
 	Close()
 }
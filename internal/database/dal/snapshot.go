@@ -0,0 +1,12 @@
+package dal
+
+import (
+	"context"
+)
+
+// Snapshotter is implemented by embedded databases which can write a consistent, compacted copy of themselves,
+// e.g. so that the data of a site without a database server can be shipped back to the central database.
+type Snapshotter interface {
+	// Snapshot writes the copy to a new file at path; it fails if the file already exists.
+	Snapshot(ctx context.Context, path string) error
+}
@@ -0,0 +1,36 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// Streamer is implemented by readers which can visit the entries matched by a query without holding them all in memory.
+type Streamer interface {
+	// Stream calls visit for every entry matched by q, in the order of Reader.List, stopping at the first error.
+	Stream(ctx context.Context, q Query, visit func(*models.ScanEntry) error) error
+}
+
+// HistoryStreamer is implemented by databases which keep every observation rather than only the latest entries.
+type HistoryStreamer interface {
+	// StreamHistory calls visit for every observation matched by q, in no particular order, stopping at the first error.
+	StreamHistory(ctx context.Context, q Query, visit func(*models.ScanEntry) error) error
+}
+
+// Stream calls visit for every entry matched by q, streaming them if the reader is a Streamer and listing them otherwise.
+func Stream(ctx context.Context, reader Reader, q Query, visit func(*models.ScanEntry) error) error {
+	if streamer, ok := reader.(Streamer); ok {
+		return streamer.Stream(ctx, q, visit)
+	}
+	entries, err := reader.List(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = visit(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ErrTransient matches errors which may succeed if the operation is retried, such as lost connections, timeouts and
+// serialization failures; databases mark the errors they recognize as transient with Transient.
+var ErrTransient = errors.New("transient database error")
+
+// transientError marks an error as transient while keeping its message.
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientError) Unwrap() error {
+	return e.err
+}
+
+func (e transientError) Is(target error) bool {
+	return target == ErrTransient
+}
+
+// Transient marks err as transient; a nil error is returned as nil.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err: err}
+}
+
+// IsTransient reports whether the operation which failed with err may succeed if it is retried: the error is marked
+// as transient by the database, is a timeout, or is a network error such as a reset or refused connection.
+// Cancellation is not transient, as the caller no longer wants the result.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrTransient) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -1,16 +1,25 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/censys/scan-takehome/internal/database/config"
 	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/migrate"
 )
 
 type dbInitializers map[string]func(cfg *config.Config) (dal.Scan, error)
 
+type migratorInitializers map[string]func(cfg *config.Config) (migrate.Migrator, error)
+
 var (
 	initializers = dbInitializers{}
+	migrators    = migratorInitializers{}
+
+	// ErrNoMigrations is returned by NewMigrator for database types which do not register migrations.
+	ErrNoMigrations = errors.New("database type has no migrations")
 )
 
 // RegisterDB registers a database initializer for a given database type.
@@ -21,13 +30,17 @@ func RegisterDB(dbType string, initializer func(cfg *config.Config) (dal.Scan, e
 }
 
 func New() (dal.Scan, error) {
-	var found bool
-
 	cfg := config.ConfigFromEnv()
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid database configuration: %w", err)
 	}
 
+	return Open(cfg)
+}
+
+// Open creates the database registered for cfg.DBType from an already validated configuration.
+// It allows composite databases (e.g. fanout) to create the databases they wrap.
+func Open(cfg *config.Config) (dal.Scan, error) {
 	initializer, found := initializers[cfg.DBType]
 	if !found {
 		return nil, fmt.Errorf("unknown database type: %s", cfg.DBType)
@@ -35,3 +48,52 @@ func New() (dal.Scan, error) {
 
 	return initializer(cfg)
 }
+
+// RegisterMigrator registers a schema migration runner initializer for a given database type.
+// Database types without a schema (e.g. noop) do not need to register a migrator.
+func RegisterMigrator(dbType string, initializer func(cfg *config.Config) (migrate.Migrator, error)) {
+	migrators[dbType] = initializer
+}
+
+// NewMigrator creates the migration runner for the configured database type.
+// ErrNoMigrations is returned if the database type does not register a migrator.
+func NewMigrator() (migrate.Migrator, error) {
+	cfg := config.ConfigFromEnv()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+
+	return OpenMigrator(cfg)
+}
+
+// OpenMigrator creates the migration runner registered for cfg.DBType from an already validated configuration, as
+// Open creates the database. ErrNoMigrations is returned if the database type does not register a migrator.
+func OpenMigrator(cfg *config.Config) (migrate.Migrator, error) {
+	initializer, found := migrators[cfg.DBType]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNoMigrations, cfg.DBType)
+	}
+
+	return initializer(cfg)
+}
+
+// VerifySchema returns an error if the configured database has pending migrations, or an invalid schema history.
+// Database types without migrations are always considered up to date.
+func VerifySchema(ctx context.Context) error {
+	runner, err := NewMigrator()
+	if errors.Is(err, ErrNoMigrations) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+	pending, err := runner.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind: %d pending migration(s), starting with %s (%s)", len(pending), pending[0].Version, pending[0].Description)
+	}
+	return nil
+}
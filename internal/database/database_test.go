@@ -1,6 +1,9 @@
 package database_test
 
 import (
+	"context"
+	"errors"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
@@ -54,3 +57,39 @@ var _ = Describe("Database Initialization", func() {
 		}, func() dal.Scan { db, _ := noop.New(nil); return db }()),
 	)
 })
+
+var _ = Describe("Database Migrations", func() {
+	var (
+		noopEnv = EnvMap{
+			"DATABASE_TYPE":     StringPointer("noop"),
+			"DATABASE_HOST":     StringPointer("localhost"),
+			"DATABASE_USER":     StringPointer("user"),
+			"DATABASE_PASSWORD": StringPointer("password"),
+			"DATABASE_PORT":     StringPointer("5432"),
+			"DATABASE_NAME":     StringPointer("dbname"),
+		}
+		restoreMap EnvMap
+	)
+	BeforeEach(func() {
+		restoreMap = noopEnv.SetupEnv()
+	})
+	AfterEach(func() {
+		restoreMap.SetupEnv()
+	})
+	It("should return ErrNoMigrations for a database type without migrations", func() {
+		runner, err := database.NewMigrator()
+		Expect(errors.Is(err, database.ErrNoMigrations)).To(BeTrue())
+		Expect(err).To(MatchError("database type has no migrations: noop"))
+		Expect(runner).To(BeNil())
+	})
+	It("should consider a database type without migrations up to date", func() {
+		Expect(database.VerifySchema(context.Background())).To(Succeed())
+	})
+	It("should return an error for an invalid configuration", func() {
+		restore := EnvMap{"DATABASE_HOST": nil}.SetupEnv()
+		defer restore.SetupEnv()
+		_, err := database.NewMigrator()
+		Expect(err).To(MatchError(MatchRegexp(`invalid database configuration: .*'Config\.Host'`)))
+		Expect(database.VerifySchema(context.Background())).To(MatchError(MatchRegexp(`invalid database configuration`)))
+	})
+})
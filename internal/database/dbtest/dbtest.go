@@ -0,0 +1,262 @@
+// Package dbtest is a conformance suite which every dal.Scan implementation runs against itself, so that each
+// database proves the same latest-wins semantics:
+//
+//	var _ = Describe("Conformance", func() {
+//		dbtest.Conformance(func() (dal.Scan, error) { return mydb.New(cfg) }, dbtest.Options{})
+//	})
+//
+// The entries stored are read back through dal.Reader, which the database must implement unless it discards
+// every entry (see Options.Discards).
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// Options describes the behaviour expected of the database under test.
+type Options struct {
+	// Discards is set for databases which accept and discard every write (e.g. noop); only the specs which do not
+	// read entries back, reject writes or observe cancellation are run.
+	Discards bool
+	// ConcurrentWriters is the number of concurrent writers to a single entry; zero uses DefaultConcurrentWriters.
+	ConcurrentWriters int
+}
+
+const (
+	DefaultConcurrentWriters = 20
+)
+
+// Conformance registers the conformance specs in the current container.
+// newDB is called before every spec and must return an empty database; the database is closed after the spec.
+func Conformance(newDB func() (dal.Scan, error), opts Options) {
+	var (
+		db  dal.Scan
+		ctx context.Context
+	)
+	writers := opts.ConcurrentWriters
+	if writers == 0 {
+		writers = DefaultConcurrentWriters
+	}
+	entry := func(ip string, port uint32, service string, timestamp int64, response string) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr(ip), Port: port, Service: service, ScanTimestamp: timestamp, Response: response}
+	}
+	// stored returns the entry stored for the service of e, or nil.
+	stored := func(e *models.ScanEntry) *models.ScanEntry {
+		reader, ok := db.(dal.Reader)
+		Expect(ok).To(BeTrue(), "the database must implement dal.Reader to run the conformance suite")
+		found, err := reader.Get(ctx, e.IP, e.Port, e.Service)
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return found
+	}
+	readsBack := func() {
+		if opts.Discards {
+			Skip("the database discards every entry")
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		db, err = newDB()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db).ToNot(BeNil())
+		DeferCleanup(func() { db.Close() })
+	})
+
+	Context("conformance: upserts", func() {
+		It("should accept a new entry", func() {
+			e := entry("10.0.0.1", 80, "HTTP", 100, "first")
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			if !opts.Discards {
+				Expect(stored(e)).To(Equal(e))
+			}
+		})
+		It("should replace an entry with a newer scan", func() {
+			readsBack()
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "older"))).To(Succeed())
+			newer := entry("10.0.0.1", 80, "HTTP", 200, "newer")
+			Expect(db.Upsert(ctx, newer)).To(Succeed())
+			Expect(stored(newer)).To(Equal(newer))
+		})
+		It("should ignore an older scan delivered out of order", func() {
+			readsBack()
+			newer := entry("10.0.0.1", 80, "HTTP", 200, "newer")
+			Expect(db.Upsert(ctx, newer)).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "older"))).To(Succeed())
+			Expect(stored(newer)).To(Equal(newer))
+		})
+		It("should order scans within the same second by their sub-second part", func() {
+			readsBack()
+			later := entry("10.0.0.1", 80, "HTTP", 100, "later")
+			later.ScanNanos = 500
+			earlier := entry("10.0.0.1", 80, "HTTP", 100, "earlier")
+			earlier.ScanNanos = 499
+			Expect(db.Upsert(ctx, later)).To(Succeed())
+			Expect(db.Upsert(ctx, earlier)).To(Succeed())
+			Expect(stored(later)).To(Equal(later))
+		})
+		It("should converge on the same entry for scans at the same instant regardless of delivery order", func() {
+			readsBack()
+			// sha256("first") = a793..., sha256("second") = 1636..., so "first" wins the tie
+			first := entry("10.0.0.1", 80, "HTTP", 100, "first")
+			second := entry("10.0.0.1", 80, "HTTP", 100, "second")
+			Expect(db.Upsert(ctx, second)).To(Succeed())
+			Expect(db.Upsert(ctx, first)).To(Succeed())
+			Expect(db.Upsert(ctx, second)).To(Succeed())
+			Expect(stored(first)).To(Equal(first))
+
+			other := entry("10.0.0.2", 80, "HTTP", 100, "first")
+			Expect(db.Upsert(ctx, other)).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.2", 80, "HTTP", 100, "second"))).To(Succeed())
+			Expect(stored(other)).To(Equal(other))
+		})
+		It("should not change the stored entry when a scan is redelivered", func() {
+			readsBack()
+			e := entry("10.0.0.1", 80, "HTTP", 100, "redelivered")
+			e.ScanNanos = 42
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			Expect(stored(e)).To(Equal(e))
+		})
+		It("should store the enrichment of an entry and replace it with that of a newer scan", func() {
+			readsBack()
+			e := entry("10.0.0.1", 80, "HTTP", 100, "enriched")
+			e.ASN, e.ASOrg, e.Country = 64496, "Example Networks", "NL"
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			Expect(stored(e)).To(Equal(e))
+			newer := entry("10.0.0.1", 80, "HTTP", 200, "not found")
+			Expect(db.Upsert(ctx, newer)).To(Succeed())
+			Expect(stored(newer)).To(Equal(newer))
+		})
+		It("should store the parsed fields of an entry and replace them with those of a newer scan", func() {
+			readsBack()
+			e := entry("10.0.0.1", 22, "SSH", 100, "SSH-2.0-OpenSSH_9.6 Ubuntu")
+			e.Parsed = map[string]string{"protocol": "2.0", "software": "OpenSSH_9.6", "comments": "Ubuntu"}
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			Expect(stored(e)).To(Equal(e))
+			newer := entry("10.0.0.1", 22, "SSH", 200, "Protocol mismatch.")
+			newer.ParseError = "malformed banner: no SSH identification string"
+			Expect(db.Upsert(ctx, newer)).To(Succeed())
+			Expect(stored(newer)).To(Equal(newer))
+		})
+		It("should store the fingerprint of an entry and replace it with that of a newer scan", func() {
+			readsBack()
+			e := entry("10.0.0.1", 22, "SSH", 100, "SSH-2.0-OpenSSH_9.6 Ubuntu")
+			e.Fingerprint = models.Fingerprint{
+				Vendor: "openbsd", Product: "openssh", Version: "9.6", CPE: "cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*",
+			}
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			Expect(stored(e)).To(Equal(e))
+			newer := entry("10.0.0.1", 22, "SSH", 200, "SSH-2.0-unknown")
+			Expect(db.Upsert(ctx, newer)).To(Succeed())
+			Expect(stored(newer)).To(Equal(newer))
+		})
+		It("should store scans taken after 2038, whose timestamps do not fit in 32 bits", func() {
+			readsBack()
+			older := entry("10.0.0.1", 80, "HTTP", 1<<31, "2038")
+			Expect(db.Upsert(ctx, older)).To(Succeed())
+			Expect(stored(older)).To(Equal(older))
+			newer := entry("10.0.0.1", 80, "HTTP", 1<<32, "2106")
+			Expect(db.Upsert(ctx, newer)).To(Succeed())
+			Expect(stored(newer)).To(Equal(newer))
+		})
+		It("should keep the entries of each ip, port and service separately", func() {
+			readsBack()
+			entries := []*models.ScanEntry{
+				entry("10.0.0.1", 80, "HTTP", 100, "base"),
+				entry("10.0.0.2", 80, "HTTP", 50, "other ip"),
+				entry("10.0.0.1", 8080, "HTTP", 50, "other port"),
+				entry("10.0.0.1", 80, "HTTPS", 50, "other service"),
+				entry("2001:db8::1", 80, "HTTP", 50, "ipv6"),
+			}
+			for _, e := range entries {
+				Expect(db.Upsert(ctx, e)).To(Succeed())
+			}
+			for _, e := range entries {
+				Expect(stored(e)).To(Equal(e))
+			}
+		})
+		It("should keep the newest entry when written concurrently", func() {
+			readsBack()
+			var wg sync.WaitGroup
+			for i := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+					Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", int64(i+1), fmt.Sprint(i+1)))).To(Succeed())
+				}()
+			}
+			wg.Wait()
+			newest := entry("10.0.0.1", 80, "HTTP", int64(writers), fmt.Sprint(writers))
+			Expect(stored(newest)).To(Equal(newest))
+		})
+		It("should not store the transport", func() {
+			readsBack()
+			e := entry("10.0.0.1", 53, "DNS", 100, "dns")
+			e.Transport = models.TransportUDP
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			e.Transport = ""
+			Expect(stored(e)).To(Equal(e))
+		})
+	})
+
+	Context("conformance: validation", func() {
+		DescribeTable("should reject an entry which cannot be stored",
+			func(modify func(e *models.ScanEntry)) {
+				readsBack()
+				e := entry("10.0.0.1", 80, "HTTP", 100, "invalid")
+				modify(e)
+				Expect(db.Upsert(ctx, e)).To(MatchError(models.ErrInvalidEntry))
+				e.IP = models.CanonicalAddr(e.IP)
+				if e.IP.IsValid() {
+					Expect(stored(e)).To(BeNil())
+				}
+			},
+			Entry("missing ip", func(e *models.ScanEntry) { e.IP = netip.Addr{} }),
+			Entry("non-canonical ip", func(e *models.ScanEntry) { e.IP = netip.MustParseAddr("::ffff:10.0.0.1") }),
+			Entry("missing service", func(e *models.ScanEntry) { e.Service = "" }),
+			Entry("missing timestamp", func(e *models.ScanEntry) { e.ScanTimestamp = 0 }),
+			Entry("missing response", func(e *models.ScanEntry) { e.Response = "" }),
+			Entry("negative nanos", func(e *models.ScanEntry) { e.ScanNanos = -1 }),
+			Entry("nanos of a second or more", func(e *models.ScanEntry) { e.ScanNanos = 1_000_000_000 }),
+		)
+		It("should reject a nil entry", func() {
+			readsBack()
+			Expect(db.Upsert(ctx, nil)).To(MatchError(models.ErrInvalidEntry))
+		})
+	})
+
+	Context("conformance: cancellation and close", func() {
+		It("should not store an entry once the context is cancelled", func() {
+			readsBack()
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			e := entry("10.0.0.1", 80, "HTTP", 100, "cancelled")
+			Expect(db.Upsert(cancelled, e)).To(MatchError(context.Canceled))
+			Expect(stored(e)).To(BeNil())
+		})
+		It("should reject writes once closed", func() {
+			readsBack()
+			db.Close()
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "closed"))).ToNot(Succeed())
+		})
+		It("should allow close to be called more than once", func() {
+			db.Close()
+			Expect(db.Close).ToNot(Panic())
+		})
+	})
+}
@@ -0,0 +1,144 @@
+// Package export converts scan entries to and from portable formats, so that the entries of one database can be
+// imported into another (e.g. from the embedded database of an edge site into the central postgres).
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	// MaxLineSize is the longest JSON lines record which can be read.
+	MaxLineSize = 16 << 20
+)
+
+// Record is the portable form of a models.ScanEntry.
+type Record struct {
+	IP             string `json:"ip"`
+	Port           uint32 `json:"port"`
+	Service        string `json:"service"`
+	Timestamp      int64  `json:"timestamp"`
+	TimestampNanos int64  `json:"timestamp_nanos,omitempty"`
+	Response       string `json:"response"`
+	ASN            uint32 `json:"asn,omitempty"`
+	ASOrg          string `json:"as_org,omitempty"`
+	Country        string `json:"country,omitempty"`
+	// Parsed and ParseError are the result of parsing the response (see models.ScanEntry).
+	Parsed     map[string]string `json:"parsed,omitempty"`
+	ParseError string            `json:"parse_error,omitempty"`
+	// Vendor, Product, ProductVersion and CPE are the fingerprint of the response (see models.Fingerprint).
+	Vendor         string `json:"vendor,omitempty"`
+	Product        string `json:"product,omitempty"`
+	ProductVersion string `json:"product_version,omitempty"`
+	CPE            string `json:"cpe,omitempty"`
+}
+
+// NewRecord returns the record of the entry.
+func NewRecord(entry *models.ScanEntry) Record {
+	return Record{
+		IP:             entry.IP.String(),
+		Port:           entry.Port,
+		Service:        entry.Service,
+		Timestamp:      entry.ScanTimestamp,
+		TimestampNanos: entry.ScanNanos,
+		Response:       entry.Response,
+		ASN:            entry.ASN,
+		ASOrg:          entry.ASOrg,
+		Country:        entry.Country,
+		Parsed:         entry.Parsed,
+		ParseError:     entry.ParseError,
+		Vendor:         entry.Fingerprint.Vendor,
+		Product:        entry.Fingerprint.Product,
+		ProductVersion: entry.Fingerprint.Version,
+		CPE:            entry.Fingerprint.CPE,
+	}
+}
+
+// Entry returns the entry of the record; the entry is not validated.
+func (r Record) Entry() (*models.ScanEntry, error) {
+	ip, err := models.ParseIP(r.IP)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ScanEntry{
+		IP:            ip,
+		Port:          r.Port,
+		Service:       r.Service,
+		ScanTimestamp: r.Timestamp,
+		ScanNanos:     r.TimestampNanos,
+		Response:      r.Response,
+		ASN:           r.ASN,
+		ASOrg:         r.ASOrg,
+		Country:       r.Country,
+		Parsed:        r.Parsed,
+		ParseError:    r.ParseError,
+		Fingerprint: models.Fingerprint{
+			Vendor:  r.Vendor,
+			Product: r.Product,
+			Version: r.ProductVersion,
+			CPE:     r.CPE,
+		},
+	}, nil
+}
+
+// WriteJSONL writes the entries to w as JSON lines, one record per line.
+func WriteJSONL(w io.Writer, entries []*models.ScanEntry) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, entry := range entries {
+		if err := enc.Encode(NewRecord(entry)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ExportJSONL writes the entries of the reader matched by q to w as JSON lines, returning the number written.
+// Entries are streamed from readers which implement dal.Streamer, so that exporting a large table does not hold it
+// in memory.
+func ExportJSONL(ctx context.Context, w io.Writer, reader dal.Reader, q dal.Query) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	count := 0
+	err := dal.Stream(ctx, reader, q, func(entry *models.ScanEntry) error {
+		if err := enc.Encode(NewRecord(entry)); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// ReadJSONL reads JSON lines records from r and calls fn with the entry of each; blank lines are skipped.
+// Reading stops at the first record which cannot be read or for which fn returns an error.
+func ReadJSONL(r io.Reader, fn func(entry *models.ScanEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), MaxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		entry, err := record.Entry()
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err = fn(entry); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,94 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/export"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("JSON lines", func() {
+	entries := []*models.ScanEntry{
+		{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Service: "HTTP", ScanTimestamp: 100, ScanNanos: 5, Response: "HTTP/1.1 200 OK\r\n"},
+		{IP: netip.MustParseAddr("2001:db8::1"), Port: 22, Service: "SSH", ScanTimestamp: 200, Response: "SSH-2.0-OpenSSH_9.6",
+			ASN: 64496, ASOrg: "Example Networks", Country: "NL", Parsed: map[string]string{"protocol": "2.0", "software": "OpenSSH_9.6"},
+			Fingerprint: models.Fingerprint{Vendor: "openbsd", Product: "openssh", Version: "9.6", CPE: "cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*"}},
+	}
+	read := func(input string) ([]*models.ScanEntry, error) {
+		read := []*models.ScanEntry{}
+		err := export.ReadJSONL(strings.NewReader(input), func(e *models.ScanEntry) error {
+			read = append(read, e)
+			return nil
+		})
+		return read, err
+	}
+
+	It("should read the entries which were written", func() {
+		buf := &bytes.Buffer{}
+		Expect(export.WriteJSONL(buf, entries)).To(Succeed())
+		Expect(strings.Count(buf.String(), "\n")).To(Equal(2))
+		Expect(buf.String()).To(HavePrefix(`{"ip":"10.0.0.1","port":80,"service":"HTTP","timestamp":100,"timestamp_nanos":5,"response":"HTTP/1.1 200 OK\r\n"}`))
+		Expect(read(buf.String())).To(Equal(entries))
+	})
+	It("should skip blank lines and canonicalize the ip", func() {
+		Expect(read("\n" + `{"ip":"::ffff:10.0.0.1","port":80,"service":"HTTP","timestamp":100,"response":"ok"}` + "\n\n")).To(Equal([]*models.ScanEntry{
+			{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Service: "HTTP", ScanTimestamp: 100, Response: "ok"},
+		}))
+	})
+	DescribeTable("should report the line of a record which cannot be read",
+		func(input, expected string) {
+			_, err := read(input)
+			Expect(err).To(MatchError(MatchRegexp(expected)))
+		},
+		Entry("invalid json", `{"ip":"10.0.0.1","port":80,"service":"HTTP","timestamp":100,"response":"ok"}`+"\n{", "^line 2: "),
+		Entry("invalid ip", `{"ip":"bogus"}`, "^line 1: .*bogus"),
+	)
+	It("should stop at the first error returned for an entry", func() {
+		failed := errors.New("failed")
+		buf := &bytes.Buffer{}
+		Expect(export.WriteJSONL(buf, entries)).To(Succeed())
+		calls := 0
+		err := export.ReadJSONL(buf, func(*models.ScanEntry) error {
+			calls++
+			return failed
+		})
+		Expect(err).To(MatchError(failed))
+		Expect(err).To(MatchError("line 1: failed"))
+		Expect(calls).To(Equal(1))
+	})
+	It("should stream the entries of a streaming reader without listing them", func() {
+		buf := &bytes.Buffer{}
+		count, err := export.ExportJSONL(context.Background(), buf, streamingReader(entries), dal.Query{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(2))
+		Expect(read(buf.String())).To(Equal(entries))
+	})
+})
+
+// streamingReader only supports streaming its entries, failing any attempt to list them.
+type streamingReader []*models.ScanEntry
+
+func (r streamingReader) Get(context.Context, netip.Addr, uint32, string) (*models.ScanEntry, error) {
+	return nil, dal.ErrNotFound
+}
+
+func (r streamingReader) List(context.Context, dal.Query) ([]*models.ScanEntry, error) {
+	return nil, errors.New("entries must be streamed")
+}
+
+func (r streamingReader) Stream(_ context.Context, _ dal.Query, visit func(*models.ScanEntry) error) error {
+	for _, entry := range r {
+		if err := visit(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
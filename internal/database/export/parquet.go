@@ -0,0 +1,284 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// The datasets written by ExportParquet, each to a directory of the same name.
+const (
+	DatasetLatest  = "scan_data"
+	DatasetHistory = "scan_history"
+
+	// WatermarkFile holds the watermark of each dataset in the export directory.
+	// It is prefixed with an underscore so that it is ignored by readers of the datasets.
+	WatermarkFile = "_watermark.json"
+)
+
+// ParquetRow is a scan entry as written to a Parquet file; the parsed fields are written as JSON, or null if there are
+// none, and the version of the fingerprint as product_version.
+type ParquetRow struct {
+	IP             string    `parquet:"ip"`
+	Port           int32     `parquet:"port"`
+	Service        string    `parquet:"service,dict"`
+	ScanDate       int64     `parquet:"scan_date"`
+	ScanNanos      int64     `parquet:"scan_nanos"`
+	ScanTime       time.Time `parquet:"scan_time,timestamp(microsecond)"`
+	Response       string    `parquet:"response"`
+	ASN            int64     `parquet:"asn"`
+	ASOrg          string    `parquet:"as_org,dict"`
+	Country        string    `parquet:"country,dict"`
+	Parsed         string    `parquet:"parsed,optional,json"`
+	ParseError     string    `parquet:"parse_error"`
+	Vendor         string    `parquet:"vendor,dict"`
+	Product        string    `parquet:"product,dict"`
+	ProductVersion string    `parquet:"product_version"`
+	CPE            string    `parquet:"cpe"`
+}
+
+// NewParquetRow returns the row of the entry.
+func NewParquetRow(entry *models.ScanEntry) ParquetRow {
+	return ParquetRow{
+		IP:             entry.IP.String(),
+		Port:           int32(entry.Port),
+		Service:        entry.Service,
+		ScanDate:       entry.ScanTimestamp,
+		ScanNanos:      entry.ScanNanos,
+		ScanTime:       time.Unix(entry.ScanTimestamp, entry.ScanNanos).UTC(),
+		Response:       entry.Response,
+		ASN:            int64(entry.ASN),
+		ASOrg:          entry.ASOrg,
+		Country:        entry.Country,
+		Parsed:         string(entry.MarshalParsed()),
+		ParseError:     entry.ParseError,
+		Vendor:         entry.Fingerprint.Vendor,
+		Product:        entry.Fingerprint.Product,
+		ProductVersion: entry.Fingerprint.Version,
+		CPE:            entry.Fingerprint.CPE,
+	}
+}
+
+// PartitionDir returns the directory, relative to the dataset, of the partition holding the entry:
+// date=YYYY-MM-DD/service=<service>, where the date is the UTC date of the scan and the service is path escaped.
+func PartitionDir(entry *models.ScanEntry) string {
+	date := time.Unix(entry.ScanTimestamp, 0).UTC().Format(time.DateOnly)
+	return filepath.Join("date="+date, "service="+url.PathEscape(entry.Service))
+}
+
+// partitionFile is a Parquet file being written to a partition; it is written under a hidden name and only given
+// its final name once complete, so that readers never see a partial file.
+type partitionFile struct {
+	f      *os.File
+	writer *parquet.GenericWriter[ParquetRow]
+	path   string
+}
+
+// PartitionedWriter writes entries to one Parquet file per partition of a dataset directory.
+// Each writer adds a new file to the partitions it writes, so successive exports never replace earlier files.
+type PartitionedWriter struct {
+	dir   string
+	name  string
+	files map[string]*partitionFile
+	rows  int64
+}
+
+// NewPartitionedWriter creates a writer of the files named name (e.g. part-<run>.parquet) in the partitions of dir.
+func NewPartitionedWriter(dir, name string) *PartitionedWriter {
+	return &PartitionedWriter{dir: dir, name: name, files: map[string]*partitionFile{}}
+}
+
+// Write writes the entry to the file of its partition.
+func (w *PartitionedWriter) Write(entry *models.ScanEntry) error {
+	partition := PartitionDir(entry)
+	file, found := w.files[partition]
+	if !found {
+		dir := filepath.Join(w.dir, partition)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, w.name)
+		f, err := os.OpenFile(filepath.Join(dir, "."+w.name+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		file = &partitionFile{f: f, writer: parquet.NewGenericWriter[ParquetRow](f, parquet.Compression(&parquet.Zstd)), path: path}
+		w.files[partition] = file
+	}
+	if _, err := file.writer.Write([]ParquetRow{NewParquetRow(entry)}); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", file.path, err)
+	}
+	w.rows++
+	return nil
+}
+
+// Rows returns the number of rows written.
+func (w *PartitionedWriter) Rows() int64 {
+	return w.rows
+}
+
+// Files returns the number of files written.
+func (w *PartitionedWriter) Files() int {
+	return len(w.files)
+}
+
+// Close completes every file and gives it its final name.
+// If any file cannot be completed, every file is removed and an error is returned.
+func (w *PartitionedWriter) Close() error {
+	var errs []error
+	for _, file := range w.files {
+		if err := file.writer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := file.f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		w.Abort()
+		return err
+	}
+	for _, file := range w.files {
+		if err := os.Rename(file.f.Name(), file.path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Abort removes every incomplete file.
+func (w *PartitionedWriter) Abort() {
+	for _, file := range w.files {
+		file.f.Close()
+		os.Remove(file.f.Name())
+	}
+}
+
+// Watermarks holds the latest scan date (unix seconds) exported for each dataset.
+type Watermarks map[string]int64
+
+// ReadWatermarks reads the watermarks of the export directory; there are none before the first export.
+func ReadWatermarks(dir string) (Watermarks, error) {
+	data, err := os.ReadFile(filepath.Join(dir, WatermarkFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Watermarks{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	watermarks := Watermarks{}
+	if err = json.Unmarshal(data, &watermarks); err != nil {
+		return nil, fmt.Errorf("invalid watermark file: %w", err)
+	}
+	return watermarks, nil
+}
+
+// WriteWatermarks replaces the watermarks of the export directory.
+func WriteWatermarks(dir string, watermarks Watermarks) error {
+	data, err := json.MarshalIndent(watermarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+WatermarkFile+".tmp")
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, WatermarkFile))
+}
+
+// ParquetOptions configures ExportParquet.
+type ParquetOptions struct {
+	// Dir is the export directory, holding a directory per dataset and the watermarks.
+	Dir string
+	// Incremental only exports the entries scanned at or after the watermark of each dataset.
+	// As the watermark is inclusive, entries scanned in the same second as the watermark are exported again.
+	Incremental bool
+	// Lookback moves the start of an incremental export back, to include scans which were delivered late.
+	Lookback time.Duration
+	// History also exports the history of databases which keep it.
+	History bool
+	// Run names the files written by the export; it defaults to the time the export started.
+	Run string
+}
+
+// ParquetResult describes the export of a dataset.
+type ParquetResult struct {
+	Dataset   string
+	Rows      int64
+	Files     int
+	Watermark int64
+}
+
+// ExportParquet exports the latest entries (and optionally the history) of the database to partitioned Parquet
+// files in opts.Dir, updating the watermark of each dataset once its files are complete.
+func ExportParquet(ctx context.Context, reader dal.Reader, opts ParquetOptions) ([]ParquetResult, error) {
+	if opts.Run == "" {
+		opts.Run = time.Now().UTC().Format("20060102T150405.000000000Z")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	watermarks, err := ReadWatermarks(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	datasets := map[string]func(q dal.Query, visit func(*models.ScanEntry) error) error{
+		DatasetLatest: func(q dal.Query, visit func(*models.ScanEntry) error) error {
+			return dal.Stream(ctx, reader, q, visit)
+		},
+	}
+	if history, ok := reader.(dal.HistoryStreamer); ok && opts.History {
+		datasets[DatasetHistory] = func(q dal.Query, visit func(*models.ScanEntry) error) error {
+			return history.StreamHistory(ctx, q, visit)
+		}
+	} else if opts.History {
+		zap.S().Infow("the database does not keep history, only exporting the latest entries")
+	}
+
+	results := []ParquetResult{}
+	for _, dataset := range []string{DatasetLatest, DatasetHistory} {
+		stream, found := datasets[dataset]
+		if !found {
+			continue
+		}
+		q := dal.Query{}
+		watermark, exported := watermarks[dataset]
+		if opts.Incremental && exported {
+			q.ChangedSince = max(watermark-int64(opts.Lookback.Seconds()), 0)
+		}
+		w := NewPartitionedWriter(filepath.Join(opts.Dir, dataset), "part-"+opts.Run+".parquet")
+		err := stream(q, func(entry *models.ScanEntry) error {
+			watermark = max(watermark, entry.ScanTimestamp)
+			return w.Write(entry)
+		})
+		if err != nil {
+			w.Abort()
+			return results, fmt.Errorf("failed to export %s: %w", dataset, err)
+		}
+		if err = w.Close(); err != nil {
+			return results, fmt.Errorf("failed to export %s: %w", dataset, err)
+		}
+		if w.Rows() > 0 {
+			// The watermark of each dataset is saved as soon as its files are complete, so that a failure exporting
+			// a later dataset does not cause this one to be exported again
+			watermarks[dataset] = watermark
+			if err = WriteWatermarks(opts.Dir, watermarks); err != nil {
+				return results, err
+			}
+		}
+		results = append(results, ParquetResult{Dataset: dataset, Rows: w.Rows(), Files: w.Files(), Watermark: watermarks[dataset]})
+	}
+	return results, nil
+}
@@ -0,0 +1,171 @@
+package export_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/export"
+	"github.com/censys/scan-takehome/internal/database/memory"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// historyDB is a memory database which also keeps every observation, as postgres does.
+type historyDB struct {
+	*memory.DB
+	history []*models.ScanEntry
+	// historyErr is returned once every observation has been visited
+	historyErr error
+}
+
+func (db *historyDB) Upsert(ctx context.Context, entry *models.ScanEntry) error {
+	db.history = append(db.history, entry)
+	return db.DB.Upsert(ctx, entry)
+}
+
+func (db *historyDB) StreamHistory(ctx context.Context, q dal.Query, visit func(*models.ScanEntry) error) error {
+	for _, entry := range db.history {
+		if entry.ScanTimestamp < q.ChangedSince {
+			continue
+		}
+		if err := visit(entry); err != nil {
+			return err
+		}
+	}
+	return db.historyErr
+}
+
+var _ = Describe("Parquet", func() {
+	const (
+		day1 = int64(1767225600) // 2026-01-01T00:00:00Z
+		day2 = day1 + 24*60*60
+	)
+	var (
+		db  *historyDB
+		dir string
+		ctx = context.Background()
+	)
+	entry := func(ip string, port uint32, service string, timestamp int64, response string) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr(ip), Port: port, Service: service, ScanTimestamp: timestamp, Response: response}
+	}
+	upsert := func(entries ...*models.ScanEntry) {
+		for _, e := range entries {
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+		}
+	}
+	// rows reads every row of the Parquet files in a partition of a dataset
+	rows := func(dataset, partition string) []export.ParquetRow {
+		files, err := filepath.Glob(filepath.Join(dir, dataset, partition, "*.parquet"))
+		Expect(err).ToNot(HaveOccurred())
+		all := []export.ParquetRow{}
+		for _, file := range files {
+			read, err := parquet.ReadFile[export.ParquetRow](file)
+			Expect(err).ToNot(HaveOccurred())
+			all = append(all, read...)
+		}
+		return all
+	}
+	BeforeEach(func() {
+		db = &historyDB{DB: memory.NewDB()}
+		dir = GinkgoT().TempDir()
+	})
+
+	It("should partition the entries by date and service", func() {
+		e := entry("10.0.0.1", 80, "HTTP", day1+10, "http")
+		e.ScanNanos = 500000
+		ssh := entry("10.0.0.1", 22, "SSH", day1+20, "SSH-2.0-OpenSSH_9.6")
+		ssh.Parsed = map[string]string{"protocol": "2.0", "software": "OpenSSH_9.6"}
+		ssh.Fingerprint = models.Fingerprint{Vendor: "openbsd", Product: "openssh", Version: "9.6", CPE: "cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*"}
+		upsert(e, ssh, entry("10.0.0.2", 80, "HTTP", day2+30, "http"))
+		results, err := export.ExportParquet(ctx, db, export.ParquetOptions{Dir: dir, Run: "run1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal([]export.ParquetResult{{Dataset: export.DatasetLatest, Rows: 3, Files: 3, Watermark: day2 + 30}}))
+
+		Expect(filepath.Join(dir, "scan_data", "date=2026-01-01", "service=HTTP", "part-run1.parquet")).To(BeARegularFile())
+		Expect(rows("scan_data", "date=2026-01-01/service=HTTP")).To(Equal([]export.ParquetRow{{
+			IP: "10.0.0.1", Port: 80, Service: "HTTP", ScanDate: day1 + 10, ScanNanos: 500000,
+			ScanTime: time.Unix(day1+10, 500000).UTC(), Response: "http",
+		}}))
+		Expect(rows("scan_data", "date=2026-01-01/service=SSH")).To(ConsistOf(And(
+			HaveField("Parsed", `{"protocol":"2.0","software":"OpenSSH_9.6"}`),
+			HaveField("ProductVersion", "9.6"),
+			HaveField("CPE", "cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*"),
+		)))
+		Expect(rows("scan_data", "date=2026-01-02/service=HTTP")).To(HaveLen(1))
+		// No incomplete files are left behind
+		hidden, err := filepath.Glob(filepath.Join(dir, "scan_data", "*", "*", ".*"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hidden).To(BeEmpty())
+	})
+
+	It("should escape services which are not safe in a path", func() {
+		Expect(export.PartitionDir(entry("10.0.0.1", 80, "HTTP/2 proxy", day1, "ok"))).To(Equal("date=2026-01-01/service=HTTP%2F2%20proxy"))
+	})
+
+	It("should export the history of databases which keep it", func() {
+		upsert(entry("10.0.0.1", 80, "HTTP", day1, "first"), entry("10.0.0.1", 80, "HTTP", day2, "second"))
+		results, err := export.ExportParquet(ctx, db, export.ParquetOptions{Dir: dir, History: true, Run: "run1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal([]export.ParquetResult{
+			{Dataset: export.DatasetLatest, Rows: 1, Files: 1, Watermark: day2},
+			{Dataset: export.DatasetHistory, Rows: 2, Files: 2, Watermark: day2},
+		}))
+		Expect(rows("scan_history", "date=2026-01-01/service=HTTP")[0].Response).To(Equal("first"))
+	})
+
+	It("should only export the latest entries of databases without history", func() {
+		upsert(entry("10.0.0.1", 80, "HTTP", day1, "first"))
+		results, err := export.ExportParquet(ctx, db.DB, export.ParquetOptions{Dir: dir, History: true, Run: "run1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(1))
+		Expect(filepath.Join(dir, "scan_history")).ToNot(BeADirectory())
+	})
+
+	Context("incremental exports", func() {
+		BeforeEach(func() {
+			upsert(entry("10.0.0.1", 80, "HTTP", day1, "first"))
+			_, err := export.ExportParquet(ctx, db, export.ParquetOptions{Dir: dir, Incremental: true, Run: "run1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(export.ReadWatermarks(dir)).To(Equal(export.Watermarks{export.DatasetLatest: day1}))
+		})
+		It("should only export the entries scanned since the watermark", func() {
+			upsert(entry("10.0.0.2", 80, "HTTP", day1+60, "second"), entry("10.0.0.3", 80, "HTTP", day1-60, "late"))
+			results, err := export.ExportParquet(ctx, db, export.ParquetOptions{Dir: dir, Incremental: true, Run: "run2"})
+			Expect(err).ToNot(HaveOccurred())
+			// The entry at the watermark is exported again, while the late entry is before it
+			Expect(results).To(Equal([]export.ParquetResult{{Dataset: export.DatasetLatest, Rows: 2, Files: 1, Watermark: day1 + 60}}))
+			Expect(rows("scan_data", "date=2026-01-01/service=HTTP")).To(HaveLen(3))
+			Expect(export.ReadWatermarks(dir)).To(Equal(export.Watermarks{export.DatasetLatest: day1 + 60}))
+		})
+		It("should include late scans within the lookback", func() {
+			upsert(entry("10.0.0.3", 80, "HTTP", day1-60, "late"))
+			results, err := export.ExportParquet(ctx, db, export.ParquetOptions{Dir: dir, Incremental: true, Lookback: time.Hour, Run: "run2"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results[0].Rows).To(Equal(int64(2)))
+			Expect(results[0].Watermark).To(Equal(day1))
+		})
+		It("should keep the watermark when nothing is exported", func() {
+			_, err := export.ExportParquet(ctx, memory.NewDB(), export.ParquetOptions{Dir: dir, Incremental: true, Run: "run2"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(export.ReadWatermarks(dir)).To(Equal(export.Watermarks{export.DatasetLatest: day1}))
+		})
+	})
+
+	It("should remove incomplete files and keep the watermark of a dataset which fails to export", func() {
+		upsert(entry("10.0.0.1", 80, "HTTP", day1, "first"))
+		db.historyErr = errors.New("connection lost")
+		results, err := export.ExportParquet(ctx, db, export.ParquetOptions{Dir: dir, History: true, Run: "run1"})
+		Expect(err).To(MatchError(db.historyErr))
+		Expect(results).To(HaveLen(1))
+		Expect(export.ReadWatermarks(dir)).To(Equal(export.Watermarks{export.DatasetLatest: day1}))
+		Expect(os.ReadDir(filepath.Join(dir, "scan_history", "date=2026-01-01", "service=HTTP"))).To(BeEmpty())
+	})
+})
@@ -0,0 +1,400 @@
+package fanout_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/dbtest"
+	"github.com/censys/scan-takehome/internal/database/fanout"
+	"github.com/censys/scan-takehome/internal/database/memory"
+	"github.com/censys/scan-takehome/internal/database/migrate"
+	_ "github.com/censys/scan-takehome/internal/database/noop"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// fakeSink records the calls made to it and fails with err when it is set.
+type fakeSink struct {
+	mu       sync.Mutex
+	err      error
+	upserted []*models.ScanEntry
+	expired  []dal.ExpiryRule
+	maintain int
+	closed   bool
+	cfg      *config.Config
+	// block holds writes until it is closed or their context is done
+	block chan struct{}
+}
+
+func (f *fakeSink) Upsert(ctx context.Context, entry *models.ScanEntry) error {
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.upserted = append(f.upserted, entry)
+	return nil
+}
+
+// upserts returns the entries written to the sink.
+func (f *fakeSink) upserts() []*models.ScanEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.upserted)
+}
+
+// historySink is a fakeSink which also keeps a history of the superseded observations written with an entry.
+type historySink struct {
+	fakeSink
+	history []*models.ScanEntry
+}
+
+func (h *historySink) UpsertWithHistory(ctx context.Context, entry *models.ScanEntry, superseded []*models.ScanEntry) error {
+	if err := h.Upsert(ctx, entry); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = append(h.history, superseded...)
+	return nil
+}
+
+// fakeMigrator records whether it was run.
+type fakeMigrator struct {
+	migrations []migrate.Migration
+	applied    []migrate.Migration
+	closed     bool
+}
+
+func (f *fakeMigrator) Status(_ context.Context) ([]migrate.Status, error) { return nil, nil }
+
+func (f *fakeMigrator) Pending(_ context.Context) ([]migrate.Migration, error) {
+	return f.migrations[len(f.applied):], nil
+}
+
+func (f *fakeMigrator) Up(_ context.Context) ([]migrate.Migration, error) {
+	pending := f.migrations[len(f.applied):]
+	f.applied = f.migrations
+	return pending, nil
+}
+
+func (f *fakeMigrator) Close() { f.closed = true }
+
+func (f *fakeSink) Close() { f.closed = true }
+
+func (f *fakeSink) Maintain(_ context.Context) error {
+	f.maintain++
+	return f.err
+}
+
+func (f *fakeSink) Expire(_ context.Context, rule dal.ExpiryRule, _ bool, visit func(*models.ScanEntry) error) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expired = append(f.expired, rule)
+	if visit != nil {
+		for _, e := range f.upserted {
+			if err := visit(e); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return int64(len(f.upserted)), f.err
+}
+
+func (f *fakeSink) Get(_ context.Context, ip netip.Addr, port uint32, service string) (*models.ScanEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.upserted {
+		if e.IP == ip && e.Port == port && e.Service == service {
+			return e, nil
+		}
+	}
+	return nil, dal.ErrNotFound
+}
+
+func (f *fakeSink) List(_ context.Context, _ dal.Query) ([]*models.ScanEntry, error) {
+	return f.upserts(), nil
+}
+
+var _ = Describe("Fanout", func() {
+	var (
+		entry = &models.ScanEntry{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Service: "HTTP", ScanTimestamp: 100, Response: "ok"}
+		ctx   = context.Background()
+	)
+
+	Describe("Conformance", func() {
+		dbtest.Conformance(func() (dal.Scan, error) {
+			return fanout.NewFromSinks([]fanout.Sink{
+				{Name: "required", Policy: fanout.PolicyRequired, DB: memory.NewDB()},
+				{Name: "best-effort", Policy: fanout.PolicyBestEffort, DB: memory.NewDB()},
+			})
+		}, dbtest.Options{})
+	})
+
+	DescribeTable("ParseSinks",
+		func(spec string, expected []fanout.SinkSpec, expectedErrRegex ...string) {
+			sinks, err := fanout.ParseSinks(spec)
+			if len(expectedErrRegex) == 0 {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sinks).To(Equal(expected))
+			} else {
+				Expect(err).To(HaveOccurred())
+				for _, regex := range expectedErrRegex {
+					Expect(err.Error()).To(MatchRegexp(regex))
+				}
+			}
+		},
+		Entry("required and best-effort sinks with a url", "postgres:required, clickhouse:best-effort:clickhouse://user:p@ss@clickhouse:9000/scans", []fanout.SinkSpec{
+			{Type: "postgres", Policy: fanout.PolicyRequired},
+			{Type: "clickhouse", Policy: fanout.PolicyBestEffort, URL: "clickhouse://user:p@ss@clickhouse:9000/scans"},
+		}),
+		Entry("missing policy", "postgres", nil, `invalid fanout sink "postgres": expected type:policy\[:url\]`),
+		Entry("unknown policy", "postgres:sometimes", nil, `invalid fanout sink "postgres:sometimes": unknown policy "sometimes"`),
+		Entry("nested fanout", "fanout:required", nil, `fanout sinks cannot be nested`),
+		Entry("no required sink", "postgres:best-effort", nil, fanout.ErrNoRequiredSink.Error()),
+		Entry("empty", "", nil, fanout.ErrNoRequiredSink.Error()),
+	)
+
+	Context("initialization", func() {
+		var created []*fakeSink
+		BeforeEach(func() {
+			created = nil
+			database.RegisterDB("fanout-fake", func(cfg *config.Config) (dal.Scan, error) {
+				sink := &fakeSink{cfg: cfg}
+				created = append(created, sink)
+				return sink, nil
+			})
+		})
+		envMap := func(sinks string) EnvMap {
+			return EnvMap{
+				"DATABASE_TYPE":         StringPointer(fanout.DB_FANOUT),
+				"DATABASE_HOST":         StringPointer("localhost"),
+				"DATABASE_USER":         StringPointer("user"),
+				"DATABASE_PASSWORD":     StringPointer("password"),
+				"DATABASE_PORT":         StringPointer("5432"),
+				"DATABASE_NAME":         StringPointer("dbname"),
+				"DATABASE_FANOUT_SINKS": StringPointer(sinks),
+			}
+		}
+		It("should create every sink from the database configuration", func() {
+			restoreMap := envMap("fanout-fake:required,fanout-fake:best-effort:postgres://other:5432/db").SetupEnv()
+			defer restoreMap.SetupEnv()
+			db, err := database.New()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(HaveLen(2))
+			Expect(created[0].cfg.DBType).To(Equal("fanout-fake"))
+			Expect(created[0].cfg.URL).To(BeEmpty())
+			Expect(created[0].cfg.Host).To(Equal("localhost"))
+			Expect(created[1].cfg.URL).To(Equal("postgres://other:5432/db"))
+			db.Close()
+			Expect(created[0].closed).To(BeTrue())
+			Expect(created[1].closed).To(BeTrue())
+		})
+		It("should close the created sinks when a sink cannot be created", func() {
+			restoreMap := envMap("fanout-fake:required,unknown_db:best-effort").SetupEnv()
+			defer restoreMap.SetupEnv()
+			_, err := database.New()
+			Expect(err).To(MatchError(MatchRegexp(`failed to create fanout sink unknown_db: unknown database type: unknown_db`)))
+			Expect(created).To(HaveLen(1))
+			Expect(created[0].closed).To(BeTrue())
+		})
+		It("should reject an invalid sink specification", func() {
+			restoreMap := envMap("fanout-fake:best-effort").SetupEnv()
+			defer restoreMap.SetupEnv()
+			_, err := database.New()
+			Expect(err).To(MatchError(fanout.ErrNoRequiredSink))
+		})
+		Context("migrations", func() {
+			var migrators []*fakeMigrator
+			BeforeEach(func() {
+				migrators = nil
+				database.RegisterMigrator("fanout-fake", func(cfg *config.Config) (migrate.Migrator, error) {
+					m := &fakeMigrator{migrations: []migrate.Migration{{Version: "1", Description: cfg.URL}}}
+					migrators = append(migrators, m)
+					return m, nil
+				})
+			})
+			It("should migrate every sink which has migrations", func() {
+				restoreMap := envMap("fanout-fake:required,noop:best-effort,fanout-fake:best-effort:postgres://other:5432/db").SetupEnv()
+				defer restoreMap.SetupEnv()
+				Expect(database.VerifySchema(ctx)).To(MatchError(ContainSubstring("2 pending migration(s)")))
+				migrators = nil
+				runner, err := database.NewMigrator()
+				Expect(err).ToNot(HaveOccurred())
+				applied, err := runner.Up(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(applied).To(HaveExactElements(
+					HaveField("Description", "fanout-fake: "),
+					HaveField("Description", "fanout-fake-3: postgres://other:5432/db"),
+				))
+				runner.Close()
+				Expect(migrators).To(HaveLen(2))
+				for _, m := range migrators {
+					Expect(m.closed).To(BeTrue())
+				}
+			})
+			It("should have no migrations when no sink has them", func() {
+				restoreMap := envMap("noop:required").SetupEnv()
+				defer restoreMap.SetupEnv()
+				_, err := database.NewMigrator()
+				Expect(err).To(MatchError(database.ErrNoMigrations))
+				Expect(database.VerifySchema(ctx)).To(Succeed())
+			})
+		})
+	})
+
+	Context("writes", func() {
+		var (
+			required   *fakeSink
+			bestEffort *fakeSink
+			db         dal.Scan
+		)
+		BeforeEach(func() {
+			required, bestEffort = &fakeSink{}, &fakeSink{}
+			var err error
+			db, err = fanout.NewFromSinks([]fanout.Sink{
+				{Name: "required", Policy: fanout.PolicyRequired, DB: required},
+				{Name: "best-effort", Policy: fanout.PolicyBestEffort, DB: bestEffort},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("should write to every sink", func() {
+			Expect(db.Upsert(ctx, entry)).To(Succeed())
+			Expect(required.upserts()).To(ConsistOf(entry))
+			Eventually(bestEffort.upserts).Should(ConsistOf(entry))
+		})
+		It("should write the superseded observations to the sinks which keep a history", func() {
+			historian := &historySink{}
+			db, err := fanout.NewFromSinks([]fanout.Sink{
+				{Name: "required", Policy: fanout.PolicyRequired, DB: historian},
+				{Name: "best-effort", Policy: fanout.PolicyBestEffort, DB: bestEffort},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			older := &models.ScanEntry{IP: entry.IP, Port: entry.Port, Service: entry.Service, ScanTimestamp: entry.ScanTimestamp - 1, Response: "older"}
+			Expect(db.(dal.HistoryUpserter).UpsertWithHistory(ctx, entry, []*models.ScanEntry{older})).To(Succeed())
+			Expect(historian.upserts()).To(ConsistOf(entry))
+			Expect(historian.history).To(ConsistOf(older))
+			Eventually(bestEffort.upserts).Should(ConsistOf(entry))
+		})
+		It("should succeed and count the failure when a best-effort sink fails", func() {
+			failures := metrics.DatabaseSinkFailures.WithLabelValues("best-effort", string(fanout.PolicyBestEffort))
+			before := testutil.ToFloat64(failures)
+			bestEffort.err = errors.New("column store unavailable")
+			Expect(db.Upsert(ctx, entry)).To(Succeed())
+			Expect(required.upserts()).To(ConsistOf(entry))
+			Eventually(func() float64 { return testutil.ToFloat64(failures) }).Should(Equal(before + 1))
+		})
+		It("should not wait for a best-effort sink, nor cancel its write with the caller's context", func() {
+			bestEffort.block = make(chan struct{})
+			ctx, cancel := context.WithCancel(ctx)
+			Expect(db.Upsert(ctx, entry)).To(Succeed())
+			cancel()
+			Expect(required.upserts()).To(ConsistOf(entry))
+			Consistently(bestEffort.upserts, 20*time.Millisecond).Should(BeEmpty())
+			close(bestEffort.block)
+			// Close waits for the writes to best-effort sinks
+			db.Close()
+			Expect(bestEffort.upserts()).To(ConsistOf(entry))
+		})
+		It("should time out a hung best-effort sink", func() {
+			failures := metrics.DatabaseSinkFailures.WithLabelValues("slow", string(fanout.PolicyBestEffort))
+			before := testutil.ToFloat64(failures)
+			bestEffort.block = make(chan struct{})
+			restoreMap := EnvMap{
+				"DATABASE_TYPE":          StringPointer(fanout.DB_FANOUT),
+				"DATABASE_HOST":          StringPointer("localhost"),
+				"DATABASE_USER":          StringPointer("user"),
+				"DATABASE_PASSWORD":      StringPointer("password"),
+				"DATABASE_PORT":          StringPointer("5432"),
+				"DATABASE_NAME":          StringPointer("dbname"),
+				"DATABASE_FANOUT_SINKS":  StringPointer("fanout-required:required,slow:best-effort"),
+				"DATABASE_WRITE_TIMEOUT": StringPointer("10ms"),
+			}.SetupEnv()
+			defer restoreMap.SetupEnv()
+			database.RegisterDB("fanout-required", func(*config.Config) (dal.Scan, error) { return required, nil })
+			database.RegisterDB("slow", func(*config.Config) (dal.Scan, error) { return bestEffort, nil })
+			db, err := database.New()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(db.Upsert(ctx, entry)).To(Succeed())
+			db.Close()
+			Expect(testutil.ToFloat64(failures)).To(Equal(before + 1))
+			Expect(bestEffort.upserts()).To(BeEmpty())
+		})
+		It("should fail when a required sink fails", func() {
+			sinkErr := errors.New("postgres unavailable")
+			required.err = sinkErr
+			err := db.Upsert(ctx, entry)
+			Expect(err).To(MatchError(sinkErr))
+			Expect(err.Error()).To(Equal("fanout sink required: postgres unavailable"))
+			Eventually(bestEffort.upserts).Should(ConsistOf(entry))
+		})
+		It("should require at least one required sink", func() {
+			_, err := fanout.NewFromSinks([]fanout.Sink{{Name: "best-effort", Policy: fanout.PolicyBestEffort, DB: bestEffort}})
+			Expect(err).To(MatchError(fanout.ErrNoRequiredSink))
+		})
+		It("should maintain every sink, ignoring best-effort failures", func() {
+			bestEffort.err = errors.New("column store unavailable")
+			Expect(db.(dal.Maintainer).Maintain(ctx)).To(Succeed())
+			Expect(required.maintain).To(Equal(1))
+			Expect(bestEffort.maintain).To(Equal(1))
+		})
+		It("should expire from every sink, visiting and counting the primary", func() {
+			Expect(db.Upsert(ctx, entry)).To(Succeed())
+			Eventually(bestEffort.upserts).Should(HaveLen(1))
+			visited := []*models.ScanEntry{}
+			count, err := db.(dal.Expirer).Expire(ctx, dal.ExpiryRule{Before: 200}, false, func(e *models.ScanEntry) error {
+				visited = append(visited, e)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(1)))
+			Expect(visited).To(ConsistOf(entry))
+			Expect(required.expired).To(HaveLen(1))
+			Expect(bestEffort.expired).To(HaveLen(1))
+		})
+		It("should only expire from the primary in a dry run", func() {
+			_, err := db.(dal.Expirer).Expire(ctx, dal.ExpiryRule{Before: 200}, true, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(required.expired).To(HaveLen(1))
+			Expect(bestEffort.expired).To(BeEmpty())
+		})
+		It("should read from the primary", func() {
+			Expect(required.Upsert(ctx, entry)).To(Succeed())
+			found, err := db.(dal.Reader).Get(ctx, entry.IP, entry.Port, entry.Service)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(Equal(entry))
+			entries, err := db.(dal.Reader).List(ctx, dal.Query{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(ConsistOf(entry))
+		})
+		It("should not support reads when the primary does not", func() {
+			noop, err := database.Open(&config.Config{DBType: "noop"})
+			Expect(err).ToNot(HaveOccurred())
+			db, err = fanout.NewFromSinks([]fanout.Sink{{Name: "noop", Policy: fanout.PolicyRequired, DB: noop}})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = db.(dal.Reader).Get(ctx, entry.IP, entry.Port, entry.Service)
+			Expect(err).To(MatchError(fanout.ErrNotSupported))
+			_, err = db.(dal.Expirer).Expire(ctx, dal.ExpiryRule{}, true, nil)
+			Expect(err).To(MatchError(fanout.ErrNotSupported))
+		})
+	})
+})
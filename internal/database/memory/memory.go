@@ -0,0 +1,234 @@
+// Package memory is an in-memory database with the same latest-wins semantics as the persistent databases.
+// It is intended for tests: the stored entries can be inspected, and failures and latency can be injected (see Faults).
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	DB_MEMORY = "memory"
+)
+
+var (
+	// ErrInjected is returned by operations failed by Faults which do not specify an error.
+	ErrInjected = errors.New("injected memory database failure")
+	// ErrClosed is returned by operations on a closed database.
+	ErrClosed = errors.New("memory database is closed")
+)
+
+func init() {
+	database.RegisterDB(DB_MEMORY, New)
+}
+
+// Faults configures the failures and latency injected into the database operations.
+type Faults struct {
+	// Latency delays every operation; the operation is abandoned with the context error if the context is done first.
+	Latency time.Duration
+	// Err is returned by failed operations; ErrInjected is returned when it is nil.
+	Err error
+	// FailNext fails the next n operations.
+	FailNext int
+	// FailRate fails each operation with the given probability (0 to 1).
+	FailRate float64
+	// FailWhen fails the upserts of the entries for which it returns true.
+	FailWhen func(entry *models.ScanEntry) bool
+}
+
+// key identifies the latest entry of a service.
+type key struct {
+	ip      netip.Addr
+	port    uint32
+	service string
+}
+
+// DB is an in-memory database which is safe for concurrent use.
+type DB struct {
+	mu      sync.RWMutex
+	entries map[key]models.ScanEntry
+	faults  Faults
+	closed  bool
+}
+
+// NewDB creates an empty in-memory database.
+func NewDB() *DB {
+	return &DB{entries: map[key]models.ScanEntry{}}
+}
+
+// New creates an empty in-memory database; the configuration is not used.
+func New(_ *config.Config) (dal.Scan, error) {
+	return NewDB(), nil
+}
+
+// SetFaults replaces the faults injected into subsequent operations.
+func (db *DB) SetFaults(faults Faults) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.faults = faults
+}
+
+// Entries returns a copy of every stored entry, ordered as by List.
+func (db *DB) Entries() []*models.ScanEntry {
+	entries, _ := db.List(context.Background(), dal.Query{})
+	return entries
+}
+
+func (db *DB) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+}
+
+// inject applies the configured latency and returns the error of an injected failure, if any.
+func (db *DB) inject(ctx context.Context, entry *models.ScanEntry) error {
+	db.mu.Lock()
+	faults := db.faults
+	fail := faults.FailNext > 0 ||
+		(faults.FailRate > 0 && rand.Float64() < faults.FailRate) ||
+		(entry != nil && faults.FailWhen != nil && faults.FailWhen(entry))
+	if faults.FailNext > 0 {
+		db.faults.FailNext--
+	}
+	db.mu.Unlock()
+
+	if faults.Latency > 0 {
+		timer := time.NewTimer(faults.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if fail {
+		return cmp.Or(faults.Err, ErrInjected)
+	}
+	return nil
+}
+
+func (db *DB) Upsert(ctx context.Context, entry *models.ScanEntry) error {
+	if err := models.ValidateStorable(entry); err != nil {
+		return err
+	}
+	if err := db.inject(ctx, entry); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	k := key{ip: entry.IP, port: entry.Port, service: entry.Service}
+	if stored, found := db.entries[k]; found && !entry.NewerThan(&stored) {
+		return nil
+	}
+	stored := *entry
+	// Transport is not persisted by the other databases either
+	stored.Transport = ""
+	db.entries[k] = stored
+	return nil
+}
+
+func (db *DB) Get(ctx context.Context, ip netip.Addr, port uint32, service string) (*models.ScanEntry, error) {
+	if err := db.inject(ctx, nil); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	stored, found := db.entries[key{ip: models.CanonicalAddr(ip), port: port, service: service}]
+	if !found {
+		return nil, dal.ErrNotFound
+	}
+	return &stored, nil
+}
+
+// matches reports whether the entry is selected by q.
+func matches(entry *models.ScanEntry, q dal.Query) bool {
+	return (!q.IP.IsValid() || entry.IP == models.CanonicalAddr(q.IP)) &&
+		(q.Service == "" || entry.Service == q.Service) &&
+		entry.ScanTimestamp >= q.ChangedSince
+}
+
+// compareEntries orders entries by ip, port and service; the ip is compared in its text form, as it is stored by postgres.
+func compareEntries(a, b *models.ScanEntry) int {
+	return cmp.Or(
+		cmp.Compare(a.IP.String(), b.IP.String()),
+		cmp.Compare(a.Port, b.Port),
+		cmp.Compare(a.Service, b.Service),
+	)
+}
+
+func (db *DB) List(ctx context.Context, q dal.Query) ([]*models.ScanEntry, error) {
+	if err := db.inject(ctx, nil); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	entries := []*models.ScanEntry{}
+	for _, stored := range db.entries {
+		if matches(&stored, q) {
+			entries = append(entries, &stored)
+		}
+	}
+	slices.SortFunc(entries, compareEntries)
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
+
+func (db *DB) Expire(ctx context.Context, rule dal.ExpiryRule, dryRun bool, visit func(*models.ScanEntry) error) (int64, error) {
+	if err := db.inject(ctx, nil); err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return 0, ErrClosed
+	}
+	selected := []key{}
+	for k, stored := range db.entries {
+		if stored.ScanTimestamp >= rule.Before {
+			continue
+		}
+		if rule.Service != "" && stored.Service != rule.Service {
+			continue
+		}
+		if rule.Service == "" && slices.Contains(rule.Exclude, stored.Service) {
+			continue
+		}
+		if visit != nil {
+			if err := visit(&stored); err != nil {
+				return 0, err
+			}
+		}
+		selected = append(selected, k)
+	}
+	if !dryRun {
+		for _, k := range selected {
+			delete(db.entries, k)
+		}
+	}
+	return int64(len(selected)), nil
+}
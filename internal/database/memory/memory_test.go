@@ -0,0 +1,175 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/dbtest"
+	"github.com/censys/scan-takehome/internal/database/memory"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Memory", func() {
+	var (
+		db  *memory.DB
+		ctx = context.Background()
+	)
+	entry := func(ip string, port uint32, service string, timestamp int64, response string) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr(ip), Port: port, Service: service, ScanTimestamp: timestamp, Response: response}
+	}
+	BeforeEach(func() {
+		db = memory.NewDB()
+	})
+
+	Describe("Conformance", func() {
+		dbtest.Conformance(func() (dal.Scan, error) { return memory.New(nil) }, dbtest.Options{})
+	})
+
+	It("should be registered as a database type", func() {
+		restoreMap := EnvMap{
+			"DATABASE_TYPE":     StringPointer(memory.DB_MEMORY),
+			"DATABASE_HOST":     StringPointer("localhost"),
+			"DATABASE_USER":     StringPointer("user"),
+			"DATABASE_PASSWORD": StringPointer("password"),
+			"DATABASE_PORT":     StringPointer("5432"),
+			"DATABASE_NAME":     StringPointer("dbname"),
+		}.SetupEnv()
+		defer restoreMap.SetupEnv()
+		scanDB, err := database.New()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scanDB).To(BeAssignableToTypeOf(db))
+		scanDB.Close()
+	})
+
+	Context("upserts", func() {
+		It("should keep the latest entry of each service", func() {
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "first"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 200, "newer"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 150, "older"))).To(Succeed())
+			// The response hash of "tie" is smaller than that of "newer", so the tie is broken in favour of "newer"
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 200, "tie"))).To(Succeed())
+			Expect(db.Entries()).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 80, "HTTP", 200, "newer")}))
+		})
+		It("should store entries without the transport", func() {
+			e := entry("10.0.0.1", 53, "DNS", 100, "dns")
+			e.Transport = models.TransportUDP
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			Expect(db.Entries()).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 53, "DNS", 100, "dns")}))
+		})
+		It("should not be changed by modifying an entry after it is stored", func() {
+			e := entry("10.0.0.1", 80, "HTTP", 100, "stored")
+			Expect(db.Upsert(ctx, e)).To(Succeed())
+			e.Response = "modified"
+			Expect(db.Entries()[0].Response).To(Equal("stored"))
+		})
+		It("should keep the newest entry when written concurrently", func() {
+			var wg sync.WaitGroup
+			for i := range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+					Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", int64(i+1), fmt.Sprint(i+1)))).To(Succeed())
+				}()
+			}
+			wg.Wait()
+			Expect(db.Entries()).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 80, "HTTP", 50, "50")}))
+		})
+		It("should fail once closed", func() {
+			db.Close()
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "closed"))).To(MatchError(memory.ErrClosed))
+			_, err := db.Get(ctx, netip.MustParseAddr("10.0.0.1"), 80, "HTTP")
+			Expect(err).To(MatchError(memory.ErrClosed))
+		})
+	})
+
+	Context("reads", func() {
+		BeforeEach(func() {
+			Expect(db.Upsert(ctx, entry("10.0.0.2", 80, "HTTP", 300, "http"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 200, "http"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 22, "SSH", 100, "ssh"))).To(Succeed())
+		})
+		It("should get an entry", func() {
+			found, err := db.Get(ctx, netip.MustParseAddr("::ffff:10.0.0.1"), 22, "SSH")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(Equal(entry("10.0.0.1", 22, "SSH", 100, "ssh")))
+			_, err = db.Get(ctx, netip.MustParseAddr("10.0.0.1"), 443, "HTTPS")
+			Expect(err).To(MatchError(dal.ErrNotFound))
+		})
+		DescribeTable("should list the entries matching the query, ordered by ip, port and service",
+			func(q dal.Query, expected ...*models.ScanEntry) {
+				found, err := db.List(ctx, q)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(found).To(Equal(expected))
+			},
+			Entry("everything", dal.Query{},
+				entry("10.0.0.1", 22, "SSH", 100, "ssh"), entry("10.0.0.1", 80, "HTTP", 200, "http"), entry("10.0.0.2", 80, "HTTP", 300, "http")),
+			Entry("by ip", dal.Query{IP: netip.MustParseAddr("10.0.0.1")},
+				entry("10.0.0.1", 22, "SSH", 100, "ssh"), entry("10.0.0.1", 80, "HTTP", 200, "http")),
+			Entry("by service changed since", dal.Query{Service: "HTTP", ChangedSince: 250},
+				entry("10.0.0.2", 80, "HTTP", 300, "http")),
+			Entry("with a limit", dal.Query{Limit: 1},
+				entry("10.0.0.1", 22, "SSH", 100, "ssh")),
+		)
+		It("should expire entries selected by the rule", func() {
+			visited := []*models.ScanEntry{}
+			count, err := db.Expire(ctx, dal.ExpiryRule{Exclude: []string{"SSH"}, Before: 250}, true, func(e *models.ScanEntry) error {
+				visited = append(visited, e)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(1)))
+			Expect(visited).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 80, "HTTP", 200, "http")}))
+			Expect(db.Entries()).To(HaveLen(3))
+
+			count, err = db.Expire(ctx, dal.ExpiryRule{Service: "SSH", Before: 250}, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(1)))
+			Expect(db.Entries()).To(HaveLen(2))
+		})
+	})
+
+	Context("faults", func() {
+		It("should fail the next operations", func() {
+			db.SetFaults(memory.Faults{FailNext: 2})
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(MatchError(memory.ErrInjected))
+			_, err := db.List(ctx, dal.Query{})
+			Expect(err).To(MatchError(memory.ErrInjected))
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(Succeed())
+		})
+		It("should fail the matching upserts with the configured error", func() {
+			unavailable := errors.New("unavailable")
+			db.SetFaults(memory.Faults{Err: unavailable, FailWhen: func(e *models.ScanEntry) bool { return e.Service == "SSH" }})
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 22, "SSH", 100, "ssh"))).To(MatchError(unavailable))
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(Succeed())
+			Expect(db.Entries()).To(HaveLen(1))
+		})
+		It("should fail every operation with a fail rate of one", func() {
+			db.SetFaults(memory.Faults{FailRate: 1})
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(MatchError(memory.ErrInjected))
+			db.SetFaults(memory.Faults{})
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(Succeed())
+		})
+		It("should delay operations and abandon them when the context is done", func() {
+			db.SetFaults(memory.Faults{Latency: 50 * time.Millisecond})
+			start := time.Now()
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			Expect(db.Upsert(timeoutCtx, entry("10.0.0.1", 80, "HTTP", 200, "http"))).To(MatchError(context.DeadlineExceeded))
+			Expect(db.Entries()[0].ScanTimestamp).To(Equal(int64(100)))
+		})
+	})
+})
@@ -0,0 +1,402 @@
+// Package migrate runs versioned SQL migrations which are embedded in the binary.
+// Migration files follow the Flyway naming convention (V<version>__<description>.sql) and the applied
+// migrations are recorded in a Flyway compatible history, so databases previously migrated by Flyway
+// can be migrated by the Runner without any changes.
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// HistoryTable is the default Flyway schema history table name.
+	HistoryTable = "flyway_schema_history"
+)
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration no longer matches the embedded migration.
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	// ErrFailedMigration is returned when the history contains a failed migration which must be repaired by hand.
+	ErrFailedMigration = errors.New("failed migration in schema history")
+
+	migrationName = regexp.MustCompile(`^V([0-9]+(?:[._][0-9]+)*)__(.+)\.sql$`)
+	utf8BOM       = []byte{0xEF, 0xBB, 0xBF}
+)
+
+// Migration is a single versioned SQL migration.
+type Migration struct {
+	Version     string
+	Description string
+	Script      string
+	SQL         string
+	Checksum    int32
+}
+
+// Applied is a migration recorded in the schema history.
+type Applied struct {
+	Version     string
+	Description string
+	Checksum    int32
+	Success     bool
+	InstalledOn time.Time
+}
+
+// Executor applies migrations to, and reads the schema history of, a single type of database.
+type Executor interface {
+	// Lock takes an exclusive migration lock for the database; the returned function releases it.
+	Lock(ctx context.Context) (func(), error)
+	// EnsureHistory creates the schema history if it does not exist.
+	EnsureHistory(ctx context.Context) error
+	// Applied returns the versioned migrations recorded in the schema history in the order they were applied.
+	// A missing schema history must be treated as empty.
+	Applied(ctx context.Context) ([]Applied, error)
+	// Apply runs the migration and records it in the schema history.
+	Apply(ctx context.Context, m Migration) error
+	// Close releases any resources held by the executor.
+	Close()
+}
+
+// Load reads every migration in dir of fsys, ordered by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations := []Migration{}
+	seen := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		sql, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		version := strings.ReplaceAll(match[1], "_", ".")
+		if other, found := seen[normalizeVersion(version)]; found {
+			return nil, fmt.Errorf("duplicate migration version %s: %s and %s", version, other, entry.Name())
+		}
+		seen[normalizeVersion(version)] = entry.Name()
+		migrations = append(migrations, Migration{
+			Version:     version,
+			Description: strings.ReplaceAll(match[2], "_", " "),
+			Script:      entry.Name(),
+			SQL:         string(sql),
+			Checksum:    Checksum(sql),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return CompareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+	return migrations, nil
+}
+
+// Checksum calculates the Flyway compatible checksum of a migration script.
+// Flyway calculates a CRC32 over every line of the script without its line terminator (and without a leading BOM).
+func Checksum(sql []byte) int32 {
+	crc := crc32.NewIEEE()
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(sql, utf8BOM)))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(sql)+1)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		crc.Write(scanner.Bytes())
+	}
+	return int32(crc.Sum32())
+}
+
+// scanLines splits on "\n", "\r" or "\r\n" to match Java's BufferedReader.readLine.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// A '\r' may be followed by a '\n' which has not been read yet
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// normalizeVersion removes leading zeros from each version part, and trailing zero parts,
+// so that "1.01.00" and "1.1" are equal.
+func normalizeVersion(v string) string {
+	parts := strings.Split(v, ".")
+	for i, p := range parts {
+		if parts[i] = strings.TrimLeft(p, "0"); parts[i] == "" {
+			parts[i] = "0"
+		}
+	}
+	for len(parts) > 1 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// CompareVersions compares two dotted numeric versions, returning -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	pa := strings.Split(normalizeVersion(a), ".")
+	pb := strings.Split(normalizeVersion(b), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		x, y := "0", "0"
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		// Parts are normalized without leading zeros, so a longer part is always the larger number
+		if c := cmp.Compare(len(x), len(y)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// State describes a migration in the output of Runner.Status.
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateApplied  State = "applied"
+	StateFailed   State = "failed"
+	StateMismatch State = "checksum mismatch"
+	// StateFuture is a migration recorded in the history which is not known to this binary.
+	StateFuture State = "future"
+)
+
+// Status is the state of a single migration.
+type Status struct {
+	Version     string
+	Description string
+	State       State
+	InstalledOn time.Time
+}
+
+// Runner applies a set of migrations using an Executor.
+type Runner struct {
+	exec       Executor
+	migrations []Migration
+}
+
+// NewRunner creates a runner for the migrations, which must be ordered by version (see Load).
+func NewRunner(exec Executor, migrations []Migration) *Runner {
+	return &Runner{exec: exec, migrations: migrations}
+}
+
+// Close closes the underlying Executor.
+func (r *Runner) Close() {
+	r.exec.Close()
+}
+
+// Status returns the state of every known and applied migration, ordered by version.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.exec.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.status(applied), nil
+}
+
+func (r *Runner) status(applied []Applied) []Status {
+	byVersion := map[string]Applied{}
+	for _, a := range applied {
+		// A later successful run of the same version replaces an earlier failed one
+		if prev, found := byVersion[normalizeVersion(a.Version)]; !found || !prev.Success {
+			byVersion[normalizeVersion(a.Version)] = a
+		}
+	}
+	statuses := []Status{}
+	for _, m := range r.migrations {
+		s := Status{Version: m.Version, Description: m.Description, State: StatePending}
+		if a, found := byVersion[normalizeVersion(m.Version)]; found {
+			delete(byVersion, normalizeVersion(m.Version))
+			s.InstalledOn = a.InstalledOn
+			switch {
+			case !a.Success:
+				s.State = StateFailed
+			case a.Checksum != m.Checksum:
+				s.State = StateMismatch
+			default:
+				s.State = StateApplied
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	for _, a := range byVersion {
+		state := StateFuture
+		if !a.Success {
+			state = StateFailed
+		}
+		statuses = append(statuses, Status{Version: a.Version, Description: a.Description, State: state, InstalledOn: a.InstalledOn})
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return CompareVersions(statuses[i].Version, statuses[j].Version) < 0
+	})
+	return statuses
+}
+
+// Pending returns the migrations which have not been applied yet.
+// An error is returned if the history contains a failed migration or an applied migration has been modified.
+func (r *Runner) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := r.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.pending(statuses)
+}
+
+func (r *Runner) pending(statuses []Status) ([]Migration, error) {
+	pendingVersions := map[string]bool{}
+	for _, s := range statuses {
+		switch s.State {
+		case StateFailed:
+			return nil, fmt.Errorf("%w: version %s", ErrFailedMigration, s.Version)
+		case StateMismatch:
+			return nil, fmt.Errorf("%w: version %s", ErrChecksumMismatch, s.Version)
+		case StatePending:
+			pendingVersions[s.Version] = true
+		}
+	}
+	pending := []Migration{}
+	for _, m := range r.migrations {
+		if pendingVersions[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in version order and returns the migrations applied.
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	unlock, err := r.exec.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err = r.exec.EnsureHistory(ctx); err != nil {
+		return nil, err
+	}
+	// The pending migrations are read under the lock so that concurrent runners do not apply a migration twice
+	pending, err := r.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := []Migration{}
+	for _, m := range pending {
+		if err = r.exec.Apply(ctx, m); err != nil {
+			return applied, fmt.Errorf("failed to apply migration %s (%s): %w", m.Version, m.Script, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Migrator applies the migrations of a database and reports their state; it is implemented by Runner, and by Group
+// for composite databases.
+type Migrator interface {
+	Status(ctx context.Context) ([]Status, error)
+	Pending(ctx context.Context) ([]Migration, error)
+	Up(ctx context.Context) ([]Migration, error)
+	Close()
+}
+
+// Group migrates several databases, each with its own migrator, e.g. the sinks of a fanout database.
+// The descriptions of the migrations and statuses it returns are prefixed with the name of their database.
+type Group struct {
+	names     []string
+	migrators []Migrator
+}
+
+// Add adds the migrator of the named database; the databases are migrated in the order they were added.
+func (g *Group) Add(name string, m Migrator) {
+	g.names = append(g.names, name)
+	g.migrators = append(g.migrators, m)
+}
+
+// Len returns the number of databases in the group.
+func (g *Group) Len() int {
+	return len(g.migrators)
+}
+
+// Close closes the migrator of every database.
+func (g *Group) Close() {
+	for _, m := range g.migrators {
+		m.Close()
+	}
+}
+
+// Status returns the state of the migrations of every database, in the order the databases were added.
+func (g *Group) Status(ctx context.Context) ([]Status, error) {
+	statuses := []Status{}
+	for i, m := range g.migrators {
+		s, err := m.Status(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", g.names[i], err)
+		}
+		for _, status := range s {
+			status.Description = g.names[i] + ": " + status.Description
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations of every database which have not been applied yet.
+func (g *Group) Pending(ctx context.Context) ([]Migration, error) {
+	return g.each(func(m Migrator) ([]Migration, error) { return m.Pending(ctx) })
+}
+
+// Up applies the pending migrations of each database in turn, stopping at the first database which fails.
+func (g *Group) Up(ctx context.Context) ([]Migration, error) {
+	return g.each(func(m Migrator) ([]Migration, error) { return m.Up(ctx) })
+}
+
+// each calls f with the migrator of every database, returning the migrations it returned for each.
+func (g *Group) each(f func(Migrator) ([]Migration, error)) ([]Migration, error) {
+	all := []Migration{}
+	for i, m := range g.migrators {
+		migrations, err := f(m)
+		for _, migration := range migrations {
+			migration.Description = g.names[i] + ": " + migration.Description
+			all = append(all, migration)
+		}
+		if err != nil {
+			return all, fmt.Errorf("%s: %w", g.names[i], err)
+		}
+	}
+	return all, nil
+}
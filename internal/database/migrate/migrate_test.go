@@ -0,0 +1,237 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing/fstest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/migrate"
+)
+
+// fakeExecutor keeps the schema history in memory.
+type fakeExecutor struct {
+	history  []migrate.Applied
+	locked   bool
+	closed   bool
+	applyErr error
+}
+
+func (f *fakeExecutor) Lock(_ context.Context) (func(), error) {
+	f.locked = true
+	return func() { f.locked = false }, nil
+}
+
+func (f *fakeExecutor) EnsureHistory(_ context.Context) error { return nil }
+
+func (f *fakeExecutor) Applied(_ context.Context) ([]migrate.Applied, error) {
+	return f.history, nil
+}
+
+func (f *fakeExecutor) Apply(_ context.Context, m migrate.Migration) error {
+	Expect(f.locked).To(BeTrue())
+	if f.applyErr != nil {
+		return f.applyErr
+	}
+	f.history = append(f.history, migrate.Applied{Version: m.Version, Description: m.Description, Checksum: m.Checksum, Success: true, InstalledOn: time.Now()})
+	return nil
+}
+
+func (f *fakeExecutor) Close() { f.closed = true }
+
+var _ = Describe("Migrate", func() {
+	fsys := fstest.MapFS{
+		"sql/V1.10.00__third.sql":     {Data: []byte("SELECT 3;")},
+		"sql/V1.00.00__first_one.sql": {Data: []byte("SELECT 1;")},
+		"sql/V1.02__second.sql":       {Data: []byte("SELECT 2;")},
+	}
+
+	Context("Load", func() {
+		It("should load migrations in version order", func() {
+			migrations, err := migrate.Load(fsys, "sql")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(migrations).To(HaveLen(3))
+			Expect(migrations[0]).To(Equal(migrate.Migration{
+				Version:     "1.00.00",
+				Description: "first one",
+				Script:      "V1.00.00__first_one.sql",
+				SQL:         "SELECT 1;",
+				Checksum:    78787420,
+			}))
+			Expect(migrations[1].Version).To(Equal("1.02"))
+			Expect(migrations[2].Version).To(Equal("1.10.00"))
+		})
+		It("should reject invalid file names", func() {
+			_, err := migrate.Load(fstest.MapFS{"sql/1__bad.sql": {Data: []byte("")}}, "sql")
+			Expect(err).To(MatchError("invalid migration file name: 1__bad.sql"))
+		})
+		It("should reject duplicate versions", func() {
+			_, err := migrate.Load(fstest.MapFS{
+				"sql/V1__a.sql":   {Data: []byte("")},
+				"sql/V1.0__b.sql": {Data: []byte("")},
+			}, "sql")
+			Expect(err).To(MatchError(MatchRegexp(`duplicate migration version`)))
+		})
+		It("should fail for a missing directory", func() {
+			_, err := migrate.Load(fsys, "missing")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	DescribeTable("Checksum",
+		func(sql string, expected int32) {
+			Expect(migrate.Checksum([]byte(sql))).To(Equal(expected))
+		},
+		Entry("single line", "SELECT 1;", int32(78787420)),
+		Entry("LF line endings", "CREATE TABLE a(\n  id int\n);\n", int32(943955536)),
+		Entry("CRLF line endings", "CREATE TABLE a(\r\n  id int\r\n);\r\n", int32(943955536)),
+		Entry("CR line endings", "CREATE TABLE a(\r  id int\r);", int32(943955536)),
+		Entry("leading BOM", "\xEF\xBB\xBFSELECT 1;", int32(78787420)),
+	)
+
+	DescribeTable("CompareVersions",
+		func(a, b string, expected int) {
+			Expect(migrate.CompareVersions(a, b)).To(Equal(expected))
+		},
+		Entry("equal", "1.00.00", "1.0", 0),
+		Entry("leading zeros", "1.01", "1.1", 0),
+		Entry("less", "1.2", "1.10", -1),
+		Entry("greater", "2", "1.99.99", 1),
+		Entry("longer", "1.0.1", "1", 1),
+	)
+
+	Context("Runner", func() {
+		var (
+			exec       *fakeExecutor
+			runner     *migrate.Runner
+			migrations []migrate.Migration
+			ctx        = context.Background()
+		)
+		BeforeEach(func() {
+			var err error
+			migrations, err = migrate.Load(fsys, "sql")
+			Expect(err).ToNot(HaveOccurred())
+			exec = &fakeExecutor{}
+			runner = migrate.NewRunner(exec, migrations)
+		})
+		It("should apply every pending migration in order", func() {
+			applied, err := runner.Up(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(Equal(migrations))
+			Expect(exec.locked).To(BeFalse())
+
+			pending, err := runner.Pending(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pending).To(BeEmpty())
+
+			applied, err = runner.Up(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeEmpty())
+		})
+		It("should only apply migrations missing from the history", func() {
+			exec.history = []migrate.Applied{{Version: "1.0", Checksum: migrations[0].Checksum, Success: true}}
+			pending, err := runner.Pending(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pending).To(Equal(migrations[1:]))
+		})
+		It("should report the state of every migration", func() {
+			installed := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+			exec.history = []migrate.Applied{
+				{Version: "1.00.00", Description: "first one", Checksum: migrations[0].Checksum, Success: true, InstalledOn: installed},
+				{Version: "2.0", Description: "from the future", Success: true, InstalledOn: installed},
+			}
+			statuses, err := runner.Status(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(Equal([]migrate.Status{
+				{Version: "1.00.00", Description: "first one", State: migrate.StateApplied, InstalledOn: installed},
+				{Version: "1.02", Description: "second", State: migrate.StatePending},
+				{Version: "1.10.00", Description: "third", State: migrate.StatePending},
+				{Version: "2.0", Description: "from the future", State: migrate.StateFuture, InstalledOn: installed},
+			}))
+		})
+		It("should refuse to migrate when an applied migration was modified", func() {
+			exec.history = []migrate.Applied{{Version: "1.00.00", Checksum: 1, Success: true}}
+			_, err := runner.Up(ctx)
+			Expect(errors.Is(err, migrate.ErrChecksumMismatch)).To(BeTrue())
+		})
+		It("should refuse to migrate after a failed migration", func() {
+			exec.history = []migrate.Applied{{Version: "1.00.00", Checksum: migrations[0].Checksum, Success: false}}
+			_, err := runner.Pending(ctx)
+			Expect(errors.Is(err, migrate.ErrFailedMigration)).To(BeTrue())
+		})
+		It("should accept a successful retry of a failed migration", func() {
+			exec.history = []migrate.Applied{
+				{Version: "1.00.00", Checksum: migrations[0].Checksum, Success: false},
+				{Version: "1.00.00", Checksum: migrations[0].Checksum, Success: true},
+			}
+			pending, err := runner.Pending(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pending).To(HaveLen(2))
+		})
+		It("should return the migrations applied before a failure", func() {
+			exec.history = []migrate.Applied{{Version: "1.00.00", Checksum: migrations[0].Checksum, Success: true}}
+			exec.applyErr = errors.New("syntax error")
+			applied, err := runner.Up(ctx)
+			Expect(err).To(MatchError("failed to apply migration 1.02 (V1.02__second.sql): syntax error"))
+			Expect(applied).To(BeEmpty())
+			Expect(exec.locked).To(BeFalse())
+		})
+		It("should close the executor", func() {
+			runner.Close()
+			Expect(exec.closed).To(BeTrue())
+		})
+	})
+
+	Context("Group", func() {
+		var (
+			primary, secondary *fakeExecutor
+			group              *migrate.Group
+			migrations         []migrate.Migration
+			ctx                = context.Background()
+		)
+		BeforeEach(func() {
+			var err error
+			migrations, err = migrate.Load(fsys, "sql")
+			Expect(err).ToNot(HaveOccurred())
+			primary, secondary = &fakeExecutor{}, &fakeExecutor{}
+			group = &migrate.Group{}
+			group.Add("postgres", migrate.NewRunner(primary, migrations))
+			group.Add("clickhouse", migrate.NewRunner(secondary, migrations[:1]))
+		})
+		It("should migrate every database, naming the database of each migration", func() {
+			Expect(group.Len()).To(Equal(2))
+			pending, err := group.Pending(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pending).To(HaveLen(4))
+			applied, err := group.Up(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(4))
+			Expect(applied[0].Description).To(Equal("postgres: first one"))
+			Expect(applied[3].Description).To(Equal("clickhouse: first one"))
+			Expect(primary.history).To(HaveLen(3))
+			Expect(secondary.history).To(HaveLen(1))
+
+			statuses, err := group.Status(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(HaveLen(4))
+			Expect(statuses).To(HaveEach(HaveField("State", migrate.StateApplied)))
+			Expect(statuses[3].Description).To(Equal("clickhouse: first one"))
+		})
+		It("should stop at the first database which fails to migrate", func() {
+			secondary.applyErr = errors.New("syntax error")
+			primary.applyErr = errors.New("syntax error")
+			applied, err := group.Up(ctx)
+			Expect(err).To(MatchError("postgres: failed to apply migration 1.00.00 (V1.00.00__first_one.sql): syntax error"))
+			Expect(applied).To(BeEmpty())
+			Expect(secondary.history).To(BeEmpty())
+		})
+		It("should close every database", func() {
+			group.Close()
+			Expect(primary.closed).To(BeTrue())
+			Expect(secondary.closed).To(BeTrue())
+		})
+	})
+})
@@ -1,12 +1,14 @@
 package noop
 
 import (
+	"context"
+
 	"go.uber.org/zap"
 
 	"github.com/censys/scan-takehome/internal/database"
 	"github.com/censys/scan-takehome/internal/database/config"
 	"github.com/censys/scan-takehome/internal/database/dal"
-	"github.com/censys/scan-takehome/internal/database/models"
+	"github.com/censys/scan-takehome/pkg/models"
 )
 
 const (
@@ -20,8 +22,8 @@ func init() {
 // DBNoop is a no-operation database implementation that satisfies the dal.Scan interface.
 type DBNoop struct{}
 
-func (db *DBNoop) Close()                           {}
-func (db *DBNoop) Upsert(_ *models.ScanEntry) error { return nil }
+func (db *DBNoop) Close()                                              {}
+func (db *DBNoop) Upsert(_ context.Context, _ *models.ScanEntry) error { return nil }
 
 // New creates a new instance of the noop database.
 func New(_ *config.Config) (dal.Scan, error) {
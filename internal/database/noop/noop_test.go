@@ -1,14 +1,18 @@
 package noop_test
 
 import (
+	"context"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	. "github.com/censys/scan-takehome/_test"
 	"github.com/censys/scan-takehome/internal/database"
-	"github.com/censys/scan-takehome/internal/database/models"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/dbtest"
 	"github.com/censys/scan-takehome/internal/database/noop"
 	_ "github.com/censys/scan-takehome/internal/database/noop"
+	"github.com/censys/scan-takehome/pkg/models"
 )
 
 var _ = Describe("Noop", func() {
@@ -41,11 +45,14 @@ var _ = Describe("Noop", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(db).ToNot(BeNil())
 		Expect(db).To(BeAssignableToTypeOf(&noop.DBNoop{}))
-		err = db.Upsert(nil)
+		err = db.Upsert(context.Background(), nil)
 		Expect(err).ToNot(HaveOccurred())
-		err = db.Upsert(&models.ScanEntry{})
+		err = db.Upsert(context.Background(), &models.ScanEntry{})
 		Expect(err).ToNot(HaveOccurred())
 		db.Close()
 
 	})
+	Describe("Conformance", func() {
+		dbtest.Conformance(func() (dal.Scan, error) { return noop.New(nil) }, dbtest.Options{Discards: true})
+	})
 })
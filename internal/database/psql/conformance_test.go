@@ -0,0 +1,51 @@
+package psql_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/dbtest"
+	"github.com/censys/scan-takehome/internal/database/psql"
+)
+
+var _ = Describe("Conformance (integration test)", func() {
+	var (
+		envMap = EnvMap{
+			"DATABASE_TYPE":     StringPointer("postgres"),
+			"DATABASE_HOST":     StringPointer("localhost"),
+			"DATABASE_USER":     StringPointer("censysTest"),
+			"DATABASE_PASSWORD": StringPointer("censysS4mpl3!"),
+			"DATABASE_PORT":     StringPointer("5432"),
+			"DATABASE_NAME":     StringPointer("censys_data"),
+		}
+		restoreMap EnvMap
+		cfg        *config.Config
+		pgxPool    *pgxpool.Pool
+		ctx        = context.Background()
+	)
+	BeforeEach(func() {
+		restoreMap = envMap.SetupEnv()
+		cfg = config.ConfigFromEnv()
+		var err error
+		pgxPool, err = pgxpool.New(ctx, cfg.ConnectionString())
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		restoreMap.SetupEnv()
+		_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_data;`)
+		pgxPool.Close()
+	})
+	dbtest.Conformance(func() (dal.Scan, error) {
+		// Every spec starts from an empty table
+		if _, err := pgxPool.Exec(ctx, `DELETE FROM scan_data;`); err != nil {
+			return nil, err
+		}
+		return psql.New(cfg)
+	}, dbtest.Options{})
+})
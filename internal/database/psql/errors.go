@@ -0,0 +1,50 @@
+package psql
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+)
+
+// transientStates are the SQLSTATE codes of errors which may succeed if the statement is retried.
+var transientStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57014": true, // query_canceled, as by the statement timeout
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"53000": true, // insufficient_resources
+	"53100": true, // disk_full
+	"53200": true, // out_of_memory
+	"53300": true, // too_many_connections
+}
+
+// Classify marks the pgx errors which may succeed if the statement is retried as transient (see dal.IsTransient):
+// serialization failures, deadlocks, lock timeouts, server shutdowns, exhausted resources, connection exceptions
+// (SQLSTATE class 08) and errors which pgx reports as safe to retry or as timeouts.
+// Other errors, such as constraint violations, are returned unchanged.
+func Classify(err error) error {
+	if err == nil || dal.IsTransient(err) {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if transientStates[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08") {
+			return dal.Transient(err)
+		}
+		return err
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return dal.Transient(err)
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return dal.Transient(err)
+	}
+	return err
+}
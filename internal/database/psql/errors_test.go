@@ -0,0 +1,39 @@
+package psql_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/psql"
+)
+
+var _ = DescribeTable("Classify errors",
+	func(err error, transient bool) {
+		classified := psql.Classify(err)
+		Expect(dal.IsTransient(classified)).To(Equal(transient))
+		if err != nil {
+			// The original error is kept
+			Expect(classified).To(MatchError(err))
+			Expect(classified.Error()).To(Equal(err.Error()))
+		}
+	},
+	Entry("no error", nil, false),
+	Entry("serialization failure", &pgconn.PgError{Code: "40001"}, true),
+	Entry("deadlock", &pgconn.PgError{Code: "40P01"}, true),
+	Entry("connection failure", &pgconn.PgError{Code: "08006"}, true),
+	Entry("too many connections", &pgconn.PgError{Code: "53300"}, true),
+	Entry("admin shutdown", fmt.Errorf("upsert: %w", &pgconn.PgError{Code: "57P01"}), true),
+	Entry("unique violation", &pgconn.PgError{Code: "23505"}, false),
+	Entry("undefined table", &pgconn.PgError{Code: "42P01"}, false),
+	Entry("connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true),
+	Entry("timeout", context.DeadlineExceeded, true),
+	Entry("cancellation", context.Canceled, false),
+	Entry("other error", errors.New("invalid input"), false),
+)
@@ -0,0 +1,90 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	SelectColumns = "ip, port, service, scan_date, scan_nanos, response, asn, as_org, country, parsed, parse_error, vendor, product, product_version, cpe"
+)
+
+// expiryQuery builds the statement and arguments selecting (or deleting) the entries matched by rule.
+func expiryQuery(rule dal.ExpiryRule, dryRun bool) (string, []any) {
+	where := "scan_date < $1"
+	args := []any{rule.Before}
+	if rule.Service != "" {
+		where += " AND service = $2"
+		args = append(args, rule.Service)
+	} else if len(rule.Exclude) > 0 {
+		where += " AND NOT (service = ANY($2))"
+		args = append(args, rule.Exclude)
+	}
+	if dryRun {
+		return fmt.Sprintf("SELECT %s FROM scan_data WHERE %s", SelectColumns, where), args
+	}
+	return fmt.Sprintf("DELETE FROM scan_data WHERE %s RETURNING %s", where, SelectColumns), args
+}
+
+func (db *psqlDB) Expire(ctx context.Context, rule dal.ExpiryRule, dryRun bool, visit func(*models.ScanEntry) error) (int64, error) {
+	stmt, args := expiryQuery(rule, dryRun)
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for rows.Next() {
+		count++
+		if visit == nil {
+			continue
+		}
+		entry, err := scanEntry(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err = visit(entry); err != nil {
+			rows.Close()
+			return 0, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit(ctx)
+}
+
+// scanEntry reads a ScanEntry from a row selected with SelectColumns.
+func scanEntry(row pgx.Row) (*models.ScanEntry, error) {
+	var (
+		entry  models.ScanEntry
+		ip     string
+		asn    int64
+		parsed []byte
+	)
+	err := row.Scan(&ip, &entry.Port, &entry.Service, &entry.ScanTimestamp, &entry.ScanNanos, &entry.Response,
+		&asn, &entry.ASOrg, &entry.Country, &parsed, &entry.ParseError,
+		&entry.Fingerprint.Vendor, &entry.Fingerprint.Product, &entry.Fingerprint.Version, &entry.Fingerprint.CPE)
+	if err != nil {
+		return nil, err
+	}
+	entry.ASN = uint32(asn)
+	if err = entry.UnmarshalParsed(parsed); err != nil {
+		return nil, err
+	}
+	if entry.IP, err = models.ParseIP(ip); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,105 @@
+package psql_test
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Expiry", func() {
+	// Use an ordered describe here for integration tests that build on each other
+	Describe("Integration Testing Expire", Ordered, func() {
+		var (
+			envMap = EnvMap{
+				"DATABASE_TYPE":     StringPointer("postgres"),
+				"DATABASE_HOST":     StringPointer("localhost"),
+				"DATABASE_USER":     StringPointer("censysTest"),
+				"DATABASE_PASSWORD": StringPointer("censysS4mpl3!"),
+				"DATABASE_PORT":     StringPointer("5432"),
+				"DATABASE_NAME":     StringPointer("censys_data"),
+			}
+			restoreMap EnvMap
+			db         dal.Scan
+			pgxPool    *pgxpool.Pool
+			// terminatingErr existing indicates that no subsequent tests can succeed
+			terminatingErr error
+			ctx            = context.Background()
+			entries        = []*models.ScanEntry{
+				{IP: netip.MustParseAddr("10.0.0.1"), Port: 22, Service: "SSH", ScanTimestamp: 100, Response: "old ssh"},
+				{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Service: "HTTP", ScanTimestamp: 100, Response: "old http"},
+				{IP: netip.MustParseAddr("10.0.0.2"), Port: 80, Service: "HTTP", ScanTimestamp: 1000, Response: "new http"},
+			}
+		)
+		count := func() int {
+			var c int
+			Expect(pgxPool.QueryRow(ctx, `SELECT count(*) FROM scan_data`).Scan(&c)).To(Succeed())
+			return c
+		}
+		BeforeAll(func() {
+			restoreMap = envMap.SetupEnv()
+			cfg := config.ConfigFromEnv()
+			pgxPool, terminatingErr = pgxpool.New(ctx, cfg.ConnectionString())
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			db, terminatingErr = database.New()
+			// Clean up any existing test data in case the database was not reset
+			_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_data;`)
+		})
+		AfterAll(func() {
+			restoreMap.SetupEnv()
+			_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_data;`)
+			if db != nil {
+				db.Close()
+			}
+			pgxPool.Close()
+		})
+		It("should insert the entries to expire", func() {
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			for _, e := range entries {
+				terminatingErr = db.Upsert(ctx, e)
+				Expect(terminatingErr).ToNot(HaveOccurred())
+			}
+			Expect(count()).To(Equal(3))
+		})
+		It("should list but not remove entries in a dry run", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			visited := []*models.ScanEntry{}
+			removed, err := db.(dal.Expirer).Expire(ctx, dal.ExpiryRule{Service: "HTTP", Before: 500}, true, func(e *models.ScanEntry) error {
+				visited = append(visited, e)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal(int64(1)))
+			Expect(visited).To(ConsistOf(entries[1]))
+			Expect(count()).To(Equal(3))
+		})
+		It("should not expire excluded services", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			removed, err := db.(dal.Expirer).Expire(ctx, dal.ExpiryRule{Exclude: []string{"HTTP"}, Before: 500}, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal(int64(1)))
+			Expect(count()).To(Equal(2))
+		})
+		It("should remove only entries older than the cutoff", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			removed, err := db.(dal.Expirer).Expire(ctx, dal.ExpiryRule{Before: 500}, false, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal(int64(1)))
+			Expect(count()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package psql
+
+import (
+	"context"
+	"embed"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/migrate"
+)
+
+const (
+	// migrationLockID serializes migrations between processes sharing a database.
+	migrationLockID = 0x5ca9_0002
+
+	CreateHistoryStmt = `CREATE TABLE IF NOT EXISTS ` + migrate.HistoryTable + ` (
+    installed_rank INT NOT NULL PRIMARY KEY,
+    version VARCHAR(50),
+    description VARCHAR(200) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    script VARCHAR(1000) NOT NULL,
+    checksum INT,
+    installed_by VARCHAR(100) NOT NULL,
+    installed_on TIMESTAMP NOT NULL DEFAULT now(),
+    execution_time INT NOT NULL,
+    success BOOLEAN NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ` + migrate.HistoryTable + `_s_idx ON ` + migrate.HistoryTable + ` (success);`
+	SelectAppliedStmt = "SELECT version, description, COALESCE(checksum, 0), success, installed_on FROM " + migrate.HistoryTable + " WHERE version IS NOT NULL ORDER BY installed_rank"
+	InsertAppliedStmt = "INSERT INTO " + migrate.HistoryTable + " (installed_rank, version, description, type, script, checksum, installed_by, execution_time, success) " +
+		"SELECT COALESCE(MAX(installed_rank), 0) + 1, $1, $2, 'SQL', $3, $4, current_user, $5, true FROM " + migrate.HistoryTable
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Migrations returns the embedded postgres migrations in version order.
+func Migrations() ([]migrate.Migration, error) {
+	return migrate.Load(migrationFS, "migrations")
+}
+
+// NewMigrator creates a migration runner for the embedded postgres migrations.
+func NewMigrator(cfg *config.Config) (migrate.Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.New(context.Background(), cfg.ConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewRunner(&migrationExecutor{pool: pool}, migrations), nil
+}
+
+// migrationExecutor applies migrations to postgres using a Flyway compatible schema history table.
+type migrationExecutor struct {
+	pool *pgxpool.Pool
+}
+
+func (e *migrationExecutor) Lock(ctx context.Context) (func(), error) {
+	// Advisory locks are held by a session, so a single connection is held until the lock is released
+	conn, err := e.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return func() {
+		conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+		conn.Release()
+	}, nil
+}
+
+func (e *migrationExecutor) EnsureHistory(ctx context.Context) error {
+	_, err := e.pool.Exec(ctx, CreateHistoryStmt)
+	return err
+}
+
+func (e *migrationExecutor) Applied(ctx context.Context) ([]migrate.Applied, error) {
+	var exists bool
+	if err := e.pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", migrate.HistoryTable).Scan(&exists); err != nil || !exists {
+		return nil, err
+	}
+	rows, err := e.pool.Query(ctx, SelectAppliedStmt)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (migrate.Applied, error) {
+		var a migrate.Applied
+		err := row.Scan(&a.Version, &a.Description, &a.Checksum, &a.Success, &a.InstalledOn)
+		return a, err
+	})
+}
+
+func (e *migrationExecutor) Apply(ctx context.Context, m migrate.Migration) error {
+	tx, err := e.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// A configured statement timeout is meant for the processor's writes, not for long running schema changes
+	if _, err = tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+		return err
+	}
+	start := time.Now()
+	// Executing without arguments uses the simple protocol, which allows multiple statements per migration
+	if _, err = tx.Exec(ctx, m.SQL); err != nil {
+		return err
+	}
+	elapsed := time.Since(start).Milliseconds()
+	if _, err = tx.Exec(ctx, InsertAppliedStmt, m.Version, m.Description, m.Script, m.Checksum, elapsed); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (e *migrationExecutor) Close() {
+	e.pool.Close()
+}
@@ -0,0 +1,15 @@
+-- Every observation is kept in scan_history, range partitioned by month on scan_date (unix seconds, a bigint so that
+-- scans taken from 2038 onwards can be kept).
+-- Monthly partitions are created ahead of time (and expired) by the database maintenance job; the default
+-- partition only catches observations which fall outside of the managed partitions.
+CREATE TABLE IF NOT EXISTS scan_history(
+    ip varchar(128) NOT NULL,
+    port int NOT NULL,
+    service varchar(256) NOT NULL,
+    scan_date bigint NOT NULL,
+    response text NOT NULL
+) PARTITION BY RANGE (scan_date);
+
+CREATE INDEX IF NOT EXISTS scan_history_service_idx ON scan_history (ip, port, service, scan_date);
+
+CREATE TABLE IF NOT EXISTS scan_history_default PARTITION OF scan_history DEFAULT;
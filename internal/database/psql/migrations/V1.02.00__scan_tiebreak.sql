@@ -0,0 +1,11 @@
+-- Scans taken within the same second are ordered by scan_nanos (the sub-second part of scan_date), and scans taken
+-- at the same instant by response_hash (the SHA-256 of the response), so that every processor converges on the same
+-- latest entry regardless of the order in which the scans are delivered.
+ALTER TABLE scan_data ALTER COLUMN scan_date TYPE bigint;
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS scan_nanos int NOT NULL DEFAULT 0;
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS response_hash bytea;
+UPDATE scan_data SET response_hash = sha256(convert_to(response, 'UTF8')) WHERE response_hash IS NULL;
+ALTER TABLE scan_data ALTER COLUMN response_hash SET NOT NULL;
+
+-- scan_history's scan_date is a bigint from V1.01.00, so it only gains the sub-second part.
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS scan_nanos int NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- The autonomous system and country of the ip, looked up by the processor from MMDB databases when it stores a scan.
+-- They are empty (0 and '') when no database is configured or the ip is not found, and for scans stored before.
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS asn bigint NOT NULL DEFAULT 0;
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS as_org text NOT NULL DEFAULT '';
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS country text NOT NULL DEFAULT '';
+
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS asn bigint NOT NULL DEFAULT 0;
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS as_org text NOT NULL DEFAULT '';
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS country text NOT NULL DEFAULT '';
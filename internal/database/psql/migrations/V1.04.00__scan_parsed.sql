@@ -0,0 +1,7 @@
+-- The fields the processor extracted from the response with the parser of the service (NULL for services without a
+-- parser), and why the response could not be parsed ('' if it was), e.g. parsed->>'server' for HTTP services.
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS parsed jsonb;
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS parse_error text NOT NULL DEFAULT '';
+
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS parsed jsonb;
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS parse_error text NOT NULL DEFAULT '';
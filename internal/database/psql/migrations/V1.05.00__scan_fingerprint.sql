@@ -0,0 +1,12 @@
+-- The software the processor identified from the response with the fingerprint rules ('' when no rule matched), and
+-- its CPE 2.3 name, e.g. cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*.
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS vendor text NOT NULL DEFAULT '';
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS product text NOT NULL DEFAULT '';
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS product_version text NOT NULL DEFAULT '';
+ALTER TABLE scan_data ADD COLUMN IF NOT EXISTS cpe text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS scan_data_cpe_idx ON scan_data (cpe) WHERE cpe <> '';
+
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS vendor text NOT NULL DEFAULT '';
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS product text NOT NULL DEFAULT '';
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS product_version text NOT NULL DEFAULT '';
+ALTER TABLE scan_history ADD COLUMN IF NOT EXISTS cpe text NOT NULL DEFAULT '';
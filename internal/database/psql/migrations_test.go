@@ -0,0 +1,56 @@
+package psql_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/migrate"
+	"github.com/censys/scan-takehome/internal/database/psql"
+)
+
+var _ = Describe("Migrations", func() {
+	It("should embed the postgres migrations in version order", func() {
+		migrations, err := psql.Migrations()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(migrations)).To(BeNumerically(">=", 6))
+		Expect(migrations[0].Script).To(Equal("V1.00.00__scan_data.sql"))
+		Expect(migrations[1].Script).To(Equal("V1.01.00__scan_history.sql"))
+		Expect(migrations[2].Script).To(Equal("V1.02.00__scan_tiebreak.sql"))
+		Expect(migrations[3].Script).To(Equal("V1.03.00__scan_enrichment.sql"))
+		Expect(migrations[4].Script).To(Equal("V1.04.00__scan_parsed.sql"))
+		Expect(migrations[5].Script).To(Equal("V1.05.00__scan_fingerprint.sql"))
+		for i := 1; i < len(migrations); i++ {
+			Expect(migrate.CompareVersions(migrations[i-1].Version, migrations[i].Version)).To(Equal(-1))
+		}
+	})
+	It("integration testing migrating the database to the latest version", func() {
+		envMap := EnvMap{
+			"DATABASE_TYPE":     StringPointer("postgres"),
+			"DATABASE_HOST":     StringPointer("localhost"),
+			"DATABASE_USER":     StringPointer("censysTest"),
+			"DATABASE_PASSWORD": StringPointer("censysS4mpl3!"),
+			"DATABASE_PORT":     StringPointer("5432"),
+			"DATABASE_NAME":     StringPointer("censys_data"),
+		}
+		restoreMap := envMap.SetupEnv()
+		defer restoreMap.SetupEnv()
+		ctx := context.Background()
+
+		runner, err := database.NewMigrator()
+		Expect(err).ToNot(HaveOccurred())
+		defer runner.Close()
+		// The test database is normally migrated before the tests run, so this is usually a no-op
+		_, err = runner.Up(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		statuses, err := runner.Status(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		for _, s := range statuses {
+			Expect(s.State).To(Equal(migrate.StateApplied), "migration %s", s.Version)
+		}
+		Expect(database.VerifySchema(ctx)).To(Succeed())
+	})
+})
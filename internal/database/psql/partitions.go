@@ -0,0 +1,154 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	HistoryTable            = "scan_history"
+	HistoryDefaultPartition = HistoryTable + "_default"
+
+	// partitionLockID serializes partition maintenance between processors sharing a database.
+	partitionLockID = 0x5ca9_0001
+
+	partitionNameLayout = HistoryTable + "_y2006m01"
+
+	ListPartitionsStmt = "SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent WHERE p.relname = $1"
+	InsertHistoryStmt  = "INSERT INTO " + HistoryTable + "(ip, port, service, scan_date, scan_nanos, response, asn, as_org, country, parsed, parse_error, vendor, product, product_version, cpe) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)"
+)
+
+// Partition is a single monthly range partition of the scan_history table.
+// The range covers scan_date values in [From, To).
+type Partition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// MonthlyPartition returns the partition which holds scans taken at t.
+func MonthlyPartition(t time.Time) Partition {
+	t = t.UTC()
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return Partition{
+		Name: from.Format(partitionNameLayout),
+		From: from,
+		To:   from.AddDate(0, 1, 0),
+	}
+}
+
+// ParsePartitionName returns the monthly partition for a partition table name.
+// The default partition (or any table not created by maintenance) returns false.
+func ParsePartitionName(name string) (Partition, bool) {
+	t, err := time.Parse(partitionNameLayout, name)
+	if err != nil {
+		return Partition{}, false
+	}
+	return MonthlyPartition(t), true
+}
+
+// PartitionsAhead returns the partition for the month containing now followed by the next `ahead` months.
+func PartitionsAhead(now time.Time, ahead int) []Partition {
+	current := MonthlyPartition(now)
+	partitions := make([]Partition, 0, ahead+1)
+	for i := 0; i <= ahead; i++ {
+		partitions = append(partitions, MonthlyPartition(current.From.AddDate(0, i, 0)))
+	}
+	return partitions
+}
+
+// RetentionCutoff returns the earliest scan time kept when retaining `months` complete months before now.
+// Partitions which end on or before the cutoff are expired. A zero or negative retention returns the zero time.
+func RetentionCutoff(now time.Time, months int) time.Time {
+	if months <= 0 {
+		return time.Time{}
+	}
+	return MonthlyPartition(now).From.AddDate(0, -months, 0)
+}
+
+// Maintain creates the upcoming history partitions and drops partitions older than the configured retention.
+func (db *psqlDB) Maintain(ctx context.Context) error {
+	return db.maintainPartitions(ctx, time.Now())
+}
+
+func (db *psqlDB) maintainPartitions(ctx context.Context, now time.Time) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", partitionLockID); err != nil {
+		return err
+	}
+	existing, err := listPartitions(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, p := range PartitionsAhead(now, db.partitionsAhead) {
+		if _, found := existing[p.Name]; found {
+			continue
+		}
+		if err = createPartition(ctx, tx, p); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", p.Name, err)
+		}
+		zap.S().Infow("created history partition", "partition", p.Name)
+	}
+	if cutoff := RetentionCutoff(now, db.retentionMonths); !cutoff.IsZero() {
+		for name, p := range existing {
+			if p.To.After(cutoff) {
+				continue
+			}
+			if _, err = tx.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{name}.Sanitize()); err != nil {
+				return fmt.Errorf("failed to drop partition %s: %w", name, err)
+			}
+			zap.S().Infow("dropped expired history partition", "partition", name)
+		}
+		if _, err = tx.Exec(ctx, "DELETE FROM "+HistoryDefaultPartition+" WHERE scan_date < $1", cutoff.Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// listPartitions returns the monthly partitions currently attached to the history table.
+func listPartitions(ctx context.Context, tx pgx.Tx) (map[string]Partition, error) {
+	rows, err := tx.Query(ctx, ListPartitionsStmt, HistoryTable)
+	if err != nil {
+		return nil, err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	partitions := map[string]Partition{}
+	for _, name := range names {
+		if p, ok := ParsePartitionName(name); ok {
+			partitions[name] = p
+		}
+	}
+	return partitions, nil
+}
+
+// createPartition creates and attaches a monthly partition.
+// Any rows for the month which previously landed in the default partition are moved into the new partition first,
+// otherwise attaching the partition would fail.
+func createPartition(ctx context.Context, tx pgx.Tx, p Partition) error {
+	name := pgx.Identifier{p.Name}.Sanitize()
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", name, HistoryTable),
+		fmt.Sprintf("WITH moved AS (DELETE FROM %s WHERE scan_date >= %d AND scan_date < %d RETURNING *) INSERT INTO %s SELECT * FROM moved",
+			HistoryDefaultPartition, p.From.Unix(), p.To.Unix(), name),
+		fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM (%d) TO (%d)", HistoryTable, name, p.From.Unix(), p.To.Unix()),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package psql_test
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/psql"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("History Partitions", func() {
+	Context("partition ranges", func() {
+		It("should return the month containing the time", func() {
+			p := psql.MonthlyPartition(time.Date(2025, time.July, 15, 12, 30, 0, 0, time.UTC))
+			Expect(p).To(Equal(psql.Partition{
+				Name: "scan_history_y2025m07",
+				From: time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2025, time.August, 1, 0, 0, 0, 0, time.UTC),
+			}))
+		})
+		It("should use UTC month boundaries", func() {
+			loc := time.FixedZone("UTC+10", 10*60*60)
+			p := psql.MonthlyPartition(time.Date(2025, time.August, 1, 5, 0, 0, 0, loc))
+			Expect(p.Name).To(Equal("scan_history_y2025m07"))
+		})
+		It("should return the current month and the months ahead, across a year boundary", func() {
+			partitions := psql.PartitionsAhead(time.Date(2025, time.November, 30, 0, 0, 0, 0, time.UTC), 2)
+			names := []string{}
+			for _, p := range partitions {
+				names = append(names, p.Name)
+			}
+			Expect(names).To(Equal([]string{"scan_history_y2025m11", "scan_history_y2025m12", "scan_history_y2026m01"}))
+		})
+		DescribeTable("ParsePartitionName",
+			func(name string, expOk bool, expFrom time.Time) {
+				p, ok := psql.ParsePartitionName(name)
+				Expect(ok).To(Equal(expOk))
+				if expOk {
+					Expect(p.Name).To(Equal(name))
+					Expect(p.From).To(Equal(expFrom))
+				}
+			},
+			Entry("monthly partition", "scan_history_y2024m02", true, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)),
+			Entry("default partition", psql.HistoryDefaultPartition, false, time.Time{}),
+			Entry("unrelated table", "scan_data", false, time.Time{}),
+		)
+		DescribeTable("RetentionCutoff",
+			func(months int, expected time.Time) {
+				now := time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)
+				Expect(psql.RetentionCutoff(now, months)).To(Equal(expected))
+			},
+			Entry("keep forever", 0, time.Time{}),
+			Entry("one month", 1, time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)),
+			Entry("across a year boundary", 6, time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)),
+		)
+	})
+
+	// Use an ordered describe here for integration tests that build on each other
+	Describe("Integration Testing Partition Maintenance", Ordered, func() {
+		var (
+			envMap = EnvMap{
+				"DATABASE_TYPE":                     StringPointer("postgres"),
+				"DATABASE_HOST":                     StringPointer("localhost"),
+				"DATABASE_USER":                     StringPointer("censysTest"),
+				"DATABASE_PASSWORD":                 StringPointer("censysS4mpl3!"),
+				"DATABASE_PORT":                     StringPointer("5432"),
+				"DATABASE_NAME":                     StringPointer("censys_data"),
+				"DATABASE_HISTORY_PARTITIONS_AHEAD": StringPointer("2"),
+				"DATABASE_HISTORY_RETENTION_MONTHS": StringPointer("12"),
+			}
+			restoreMap EnvMap
+			db         dal.Scan
+			pgxPool    *pgxpool.Pool
+			// terminatingErr existing indicates that no subsequent tests can succeed
+			terminatingErr error
+			ctx            = context.Background()
+			expiredName    = psql.MonthlyPartition(time.Now().AddDate(-2, 0, 0)).Name
+		)
+		partitionNames := func() []string {
+			rows, err := pgxPool.Query(ctx, psql.ListPartitionsStmt, psql.HistoryTable)
+			Expect(err).ToNot(HaveOccurred())
+			names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			Expect(err).ToNot(HaveOccurred())
+			return names
+		}
+		BeforeAll(func() {
+			restoreMap = envMap.SetupEnv()
+			cfg := config.ConfigFromEnv()
+			pgxPool, terminatingErr = pgxpool.New(ctx, cfg.ConnectionString())
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			db, terminatingErr = database.New()
+			// Clean up any existing test data in case the database was not reset
+			_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_data;`)
+			_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_history;`)
+		})
+		AfterAll(func() {
+			restoreMap.SetupEnv()
+			if db != nil {
+				db.Close()
+			}
+			pgxPool.Close()
+		})
+		It("should create the current and upcoming partitions", func() {
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			// An expired partition is created by hand to validate retention in the next test
+			p, _ := psql.ParsePartitionName(expiredName)
+			_, terminatingErr = pgxPool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+expiredName+` PARTITION OF scan_history FOR VALUES FROM ($1) TO ($2)`, p.From.Unix(), p.To.Unix())
+			Expect(terminatingErr).ToNot(HaveOccurred())
+
+			terminatingErr = db.(dal.Maintainer).Maintain(ctx)
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			names := partitionNames()
+			for _, p := range psql.PartitionsAhead(time.Now(), 2) {
+				Expect(names).To(ContainElement(p.Name))
+			}
+		})
+		It("should drop partitions older than the retention", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			Expect(partitionNames()).ToNot(ContainElement(expiredName))
+		})
+		It("should record every observation in the history", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			now := time.Now().Unix()
+			for _, ts := range []int64{now, now - 10, now + 10} {
+				terminatingErr = db.Upsert(ctx, &models.ScanEntry{
+					IP:            netip.MustParseAddr("10.0.0.1"),
+					Port:          22,
+					Service:       "ssh",
+					ScanTimestamp: ts,
+					Response:      "SSH-2.0-OpenSSH_9.6",
+				})
+				Expect(terminatingErr).ToNot(HaveOccurred())
+			}
+			var count, defaultCount int
+			Expect(pgxPool.QueryRow(ctx, `SELECT count(*) FROM scan_history WHERE ip = '10.0.0.1'`).Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(3))
+			Expect(pgxPool.QueryRow(ctx, `SELECT count(*) FROM `+psql.HistoryDefaultPartition).Scan(&defaultCount)).To(Succeed())
+			Expect(defaultCount).To(Equal(0))
+		})
+		It("should record observations taken after 2038 in the default partition", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			entry := &models.ScanEntry{IP: netip.MustParseAddr("10.0.0.2"), Port: 22, Service: "ssh", ScanTimestamp: 1 << 32, Response: "SSH-2.0-OpenSSH_9.6"}
+			Expect(db.Upsert(ctx, entry)).To(Succeed())
+			var scanDate int64
+			Expect(pgxPool.QueryRow(ctx, `SELECT scan_date FROM `+psql.HistoryDefaultPartition+` WHERE ip = '10.0.0.2'`).Scan(&scanDate)).To(Succeed())
+			Expect(scanDate).To(Equal(entry.ScanTimestamp))
+		})
+		It("should record the superseded observations of a coalesced write", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			now := time.Now().Unix()
+			observation := func(ts int64) *models.ScanEntry {
+				return &models.ScanEntry{IP: netip.MustParseAddr("10.0.0.3"), Port: 22, Service: "ssh", ScanTimestamp: ts, Response: "SSH-2.0-OpenSSH_9.6"}
+			}
+			Expect(db.(dal.HistoryUpserter).UpsertWithHistory(ctx, observation(now), []*models.ScanEntry{observation(now - 20), observation(now - 10)})).To(Succeed())
+			var count int
+			Expect(pgxPool.QueryRow(ctx, `SELECT count(*) FROM scan_history WHERE ip = '10.0.0.3'`).Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(3))
+			latest, err := db.(dal.Reader).Get(ctx, netip.MustParseAddr("10.0.0.3"), 22, "ssh")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(latest.ScanTimestamp).To(Equal(now))
+		})
+	})
+})
@@ -2,57 +2,164 @@ package psql
 
 import (
 	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 
 	"github.com/censys/scan-takehome/internal/database"
 	"github.com/censys/scan-takehome/internal/database/config"
 	"github.com/censys/scan-takehome/internal/database/dal"
-	"github.com/censys/scan-takehome/internal/database/models"
+	"github.com/censys/scan-takehome/internal/database/routing"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/pkg/models"
 )
 
 const (
-	InsertStmt     = "INSERT INTO scan_data(ip, port, service, scan_date, response) VALUES ($1, $2, $3, $4, $5)"
-	OnConflictStmt = "ON CONFLICT (ip, port, service) DO UPDATE SET scan_date = EXCLUDED.scan_date, response = EXCLUDED.response WHERE scan_data.ip = EXCLUDED.ip AND scan_data.port = EXCLUDED.port AND scan_data.service = EXCLUDED.service AND scan_data.scan_date < EXCLUDED.scan_date"
+	InsertStmt     = "INSERT INTO scan_data(ip, port, service, scan_date, scan_nanos, response_hash, response, asn, as_org, country, parsed, parse_error, vendor, product, product_version, cpe) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)"
+	OnConflictStmt = "ON CONFLICT (ip, port, service) DO UPDATE SET scan_date = EXCLUDED.scan_date, scan_nanos = EXCLUDED.scan_nanos, response_hash = EXCLUDED.response_hash, response = EXCLUDED.response, asn = EXCLUDED.asn, as_org = EXCLUDED.as_org, country = EXCLUDED.country, parsed = EXCLUDED.parsed, parse_error = EXCLUDED.parse_error, vendor = EXCLUDED.vendor, product = EXCLUDED.product, product_version = EXCLUDED.product_version, cpe = EXCLUDED.cpe WHERE scan_data.ip = EXCLUDED.ip AND scan_data.port = EXCLUDED.port AND scan_data.service = EXCLUDED.service AND (scan_data.scan_date, scan_data.scan_nanos, scan_data.response_hash) < (EXCLUDED.scan_date, EXCLUDED.scan_nanos, EXCLUDED.response_hash)"
 	UpsertStmt     = InsertStmt + " " + OnConflictStmt
 )
 
 func init() {
 	database.RegisterDB("postgres", New)
+	database.RegisterMigrator("postgres", NewMigrator)
 }
 
 type psqlDB struct {
-	pool *pgxpool.Pool
+	// pool is the primary, which serves every write
+	pool            *pgxpool.Pool
+	router          *routing.Router[*pgxpool.Pool]
+	stopChecks      context.CancelFunc
+	checks          sync.WaitGroup
+	partitionsAhead int
+	retentionMonths int
+	writeTimeout    time.Duration
 }
 
 func New(cfg *config.Config) (dal.Scan, error) {
-	db := &psqlDB{}
+	db := &psqlDB{
+		partitionsAhead: cfg.PartitionsAhead(),
+		retentionMonths: cfg.HistoryRetentionMonths,
+		writeTimeout:    cfg.WriteTimeLimit(),
+		stopChecks:      func() {},
+	}
 	var err error
 	if db.pool, err = pgxpool.New(context.Background(), cfg.ConnectionString()); err != nil {
 		return nil, err
 	}
+	replicas := []*routing.Endpoint[*pgxpool.Pool]{}
+	for _, connStr := range cfg.ReplicaConnectionStrings() {
+		pool, err := pgxpool.New(context.Background(), connStr)
+		if err != nil {
+			for _, replica := range replicas {
+				replica.Conn.Close()
+			}
+			db.pool.Close()
+			return nil, err
+		}
+		replicas = append(replicas, routing.NewEndpoint(routing.RoleReplica, endpointName(pool), pool))
+	}
+	db.router = routing.NewRouter(routing.NewEndpoint(routing.RolePrimary, endpointName(db.pool), db.pool), replicas...)
+	if len(replicas) > 0 {
+		var ctx context.Context
+		ctx, db.stopChecks = context.WithCancel(context.Background())
+		db.checks.Add(1)
+		go func() {
+			defer db.checks.Done()
+			db.router.Run(ctx, cfg.ReplicaCheckEvery(), func(ctx context.Context, pool *pgxpool.Pool) error {
+				return pool.Ping(ctx)
+			})
+		}()
+	}
 	return db, nil
 }
 
+// endpointName identifies the endpoint of a pool in logs and metrics.
+func endpointName(pool *pgxpool.Pool) string {
+	connCfg := pool.Config().ConnConfig
+	return net.JoinHostPort(connCfg.Host, strconv.Itoa(int(connCfg.Port)))
+}
+
 func (db *psqlDB) Close() {
+	db.stopChecks()
+	db.checks.Wait()
+	for _, replica := range db.router.Replicas() {
+		replica.Conn.Close()
+	}
 	db.pool.Close()
 }
 
-func (db *psqlDB) Upsert(entry *models.ScanEntry) error {
+func (db *psqlDB) Upsert(ctx context.Context, entry *models.ScanEntry) error {
+	return db.UpsertWithHistory(ctx, entry, nil)
+}
+
+func (db *psqlDB) UpsertWithHistory(ctx context.Context, entry *models.ScanEntry, superseded []*models.ScanEntry) error {
+	start := time.Now()
+	err := Classify(db.upsert(ctx, entry, superseded))
+	primary := db.router.Primary()
+	metrics.ObserveDatabase("upsert", primary.Role, primary.Name, start, err)
+	return err
+}
+
+func (db *psqlDB) upsert(ctx context.Context, entry *models.ScanEntry, superseded []*models.ScanEntry) error {
+	if err := models.ValidateStorable(entry); err != nil {
+		return err
+	}
+	for _, observation := range superseded {
+		if err := models.ValidateStorable(observation); err != nil {
+			return err
+		}
+	}
+	if db.writeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.writeTimeout)
+		defer cancel()
+	}
 	// We really don't need a transaction here, but using one to keep the code extensible for future changes
-	tx, err := db.pool.Begin(context.Background())
+	tx, err := db.pool.Begin(ctx)
 	if err != nil {
 		zap.S().Errorw("failed to begin transaction", "error", err, "entry", entry)
 		return err
 	}
-	// The UpsertStmt uses an ON CONFLICT setup to overwrite existing entries only if the new scan_date is more recent
-	_, err = tx.Exec(context.Background(), UpsertStmt, entry.IP, entry.Port, entry.Service, entry.ScanTimestamp, entry.Response)
+	// Rolling back after a successful commit is a no-op
+	defer tx.Rollback(context.Background())
+
+	// The UpsertStmt uses an ON CONFLICT setup to overwrite existing entries only if the new scan is more recent,
+	// ordering scans in the same way as models.ScanEntry.NewerThan
+	_, err = tx.Exec(ctx, UpsertStmt, entry.IP.String(), entry.Port, entry.Service, entry.ScanTimestamp, entry.ScanNanos, entry.ResponseHash(), entry.Response,
+		int64(entry.ASN), entry.ASOrg, entry.Country, parsedArg(entry), entry.ParseError,
+		entry.Fingerprint.Vendor, entry.Fingerprint.Product, entry.Fingerprint.Version, entry.Fingerprint.CPE)
 	if err != nil {
 		zap.S().Errorw("failed to upsert scan entry", "error", err, "entry", entry)
-		tx.Rollback(context.Background())
 		return err
 	}
-	err = tx.Commit(context.Background())
+	// Every observation is kept in the history, regardless of whether it replaced the latest entry
+	for _, observation := range append([]*models.ScanEntry{entry}, superseded...) {
+		if err = insertHistory(ctx, tx, observation); err != nil {
+			zap.S().Errorw("failed to insert scan history", "error", err, "entry", observation)
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// insertHistory records the observation in the history.
+func insertHistory(ctx context.Context, tx pgx.Tx, entry *models.ScanEntry) error {
+	_, err := tx.Exec(ctx, InsertHistoryStmt, entry.IP.String(), entry.Port, entry.Service, entry.ScanTimestamp, entry.ScanNanos, entry.Response,
+		int64(entry.ASN), entry.ASOrg, entry.Country, parsedArg(entry), entry.ParseError,
+		entry.Fingerprint.Vendor, entry.Fingerprint.Product, entry.Fingerprint.Version, entry.Fingerprint.CPE)
 	return err
 }
+
+// parsedArg returns the argument of the parsed column of the entry, which is NULL when there are no parsed fields.
+func parsedArg(entry *models.ScanEntry) any {
+	if parsed := entry.MarshalParsed(); parsed != nil {
+		return string(parsed)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package psql_test
 
 import (
 	"context"
+	"net/netip"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	. "github.com/onsi/ginkgo/v2"
@@ -11,8 +13,8 @@ import (
 	"github.com/censys/scan-takehome/internal/database"
 	"github.com/censys/scan-takehome/internal/database/config"
 	"github.com/censys/scan-takehome/internal/database/dal"
-	"github.com/censys/scan-takehome/internal/database/models"
 	"github.com/censys/scan-takehome/internal/database/psql"
+	"github.com/censys/scan-takehome/pkg/models"
 )
 
 var _ = Describe("PSQL Database", func() {
@@ -33,6 +35,30 @@ var _ = Describe("PSQL Database", func() {
 		Expect(err).To(HaveOccurred())
 		Expect(db).To(BeNil())
 	})
+	It("should configure the pool from the connection settings", func() {
+		cfg := &config.Config{
+			DBType:           "postgres",
+			Host:             "db.example.com",
+			User:             "scan@ops",
+			Pass:             "p@ss:/w?rd#",
+			Port:             5432,
+			DBName:           "censys_data",
+			SSLMode:          "require",
+			PoolMinConns:     2,
+			PoolMaxConns:     8,
+			ConnMaxLifetime:  time.Hour,
+			StatementTimeout: 1500 * time.Millisecond,
+		}
+		poolCfg, err := pgxpool.ParseConfig(cfg.ConnectionString())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(poolCfg.ConnConfig.User).To(Equal("scan@ops"))
+		Expect(poolCfg.ConnConfig.Password).To(Equal("p@ss:/w?rd#"))
+		Expect(poolCfg.ConnConfig.TLSConfig).ToNot(BeNil())
+		Expect(poolCfg.ConnConfig.RuntimeParams).To(HaveKeyWithValue("statement_timeout", "1500"))
+		Expect(poolCfg.MinConns).To(Equal(int32(2)))
+		Expect(poolCfg.MaxConns).To(Equal(int32(8)))
+		Expect(poolCfg.MaxConnLifetime).To(Equal(time.Hour))
+	})
 	It("integration testing postgres registration and initialization", func() {
 		envMap := EnvMap{
 			"DATABASE_TYPE":     StringPointer("postgres"),
@@ -95,21 +121,23 @@ var _ = Describe("PSQL Database", func() {
 			// The databse connection should succeed.
 			Expect(terminatingErr).ToNot(HaveOccurred())
 			entry := &models.ScanEntry{
-				IP:            "192.168.0.1",
+				IP:            netip.MustParseAddr("192.168.0.1"),
 				Port:          80,
 				Service:       "http",
 				ScanTimestamp: 5,
 				Response:      "HTTP/1.1 200 OK",
 			}
-			terminatingErr = db.Upsert(entry)
+			terminatingErr = db.Upsert(ctx, entry)
 			Expect(terminatingErr).ToNot(HaveOccurred())
-			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP, entry.Port, entry.Service)
+			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service)
 			Expect(checkErr).ToNot(HaveOccurred())
 			defer rows.Close()
 			Expect(rows.Next()).To(BeTrue())
 			var fetchedEntry models.ScanEntry
-			checkErr = rows.Scan(&fetchedEntry.IP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
+			var fetchedIP string
+			checkErr = rows.Scan(&fetchedIP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
 			Expect(checkErr).ToNot(HaveOccurred())
+			fetchedEntry.IP = netip.MustParseAddr(fetchedIP)
 			Expect(fetchedEntry).To(Equal(*entry))
 		})
 		It("should not overwrite an existing entry with an older timestamp", func() {
@@ -117,47 +145,51 @@ var _ = Describe("PSQL Database", func() {
 				Skip("previous test(s) failed or were skipped due to an early error")
 			}
 			entry := &models.ScanEntry{
-				IP:            "192.168.0.1",
+				IP:            netip.MustParseAddr("192.168.0.1"),
 				Port:          80,
 				Service:       "http",
 				ScanTimestamp: 4,
 				Response:      "HTTP/1.1 418 I'm a teapot",
 			}
-			terminatingErr = db.Upsert(entry)
+			terminatingErr = db.Upsert(ctx, entry)
 			Expect(terminatingErr).ToNot(HaveOccurred())
-			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP, entry.Port, entry.Service)
+			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service)
 			Expect(checkErr).ToNot(HaveOccurred())
 			defer rows.Close()
 			Expect(rows.Next()).To(BeTrue())
 			var fetchedEntry models.ScanEntry
-			checkErr = rows.Scan(&fetchedEntry.IP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
+			var fetchedIP string
+			checkErr = rows.Scan(&fetchedIP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
 			Expect(checkErr).ToNot(HaveOccurred())
+			fetchedEntry.IP = netip.MustParseAddr(fetchedIP)
 			Expect(fetchedEntry).ToNot(Equal(*entry))
 			// Validate the original entry was returned
 			entry.Response = persistedResponse1
 			entry.ScanTimestamp = 5
 			Expect(fetchedEntry).To(Equal(*entry))
 		})
-		It("should not overwrite an existing entry with an equal timestamp", func() {
+		It("should not overwrite an existing entry with an equal timestamp and a smaller response hash", func() {
 			if terminatingErr != nil {
 				Skip("previous test(s) failed or were skipped due to an early error")
 			}
 			entry := &models.ScanEntry{
-				IP:            "192.168.0.1",
+				IP:            netip.MustParseAddr("192.168.0.1"),
 				Port:          80,
 				Service:       "http",
 				ScanTimestamp: 5,
 				Response:      "HTTP/1.1 418 I'm a teapot",
 			}
-			terminatingErr = db.Upsert(entry)
+			terminatingErr = db.Upsert(ctx, entry)
 			Expect(terminatingErr).ToNot(HaveOccurred())
-			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP, entry.Port, entry.Service)
+			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service)
 			Expect(checkErr).ToNot(HaveOccurred())
 			defer rows.Close()
 			Expect(rows.Next()).To(BeTrue())
 			var fetchedEntry models.ScanEntry
-			checkErr = rows.Scan(&fetchedEntry.IP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
+			var fetchedIP string
+			checkErr = rows.Scan(&fetchedIP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
 			Expect(checkErr).ToNot(HaveOccurred())
+			fetchedEntry.IP = netip.MustParseAddr(fetchedIP)
 			Expect(fetchedEntry).ToNot(Equal(*entry))
 			// Validate the original entry was returned
 			entry.Response = persistedResponse1
@@ -169,22 +201,42 @@ var _ = Describe("PSQL Database", func() {
 				Skip("previous test(s) failed or were skipped due to an early error")
 			}
 			entry := &models.ScanEntry{
-				IP:            "192.168.0.1",
+				IP:            netip.MustParseAddr("192.168.0.1"),
 				Port:          80,
 				Service:       "http",
 				ScanTimestamp: 6,
 				Response:      persistedResponse2,
 			}
-			terminatingErr = db.Upsert(entry)
+			terminatingErr = db.Upsert(ctx, entry)
 			Expect(terminatingErr).ToNot(HaveOccurred())
-			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP, entry.Port, entry.Service)
+			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service)
 			Expect(checkErr).ToNot(HaveOccurred())
 			defer rows.Close()
 			Expect(rows.Next()).To(BeTrue())
 			var fetchedEntry models.ScanEntry
-			checkErr = rows.Scan(&fetchedEntry.IP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
+			var fetchedIP string
+			checkErr = rows.Scan(&fetchedIP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
 			Expect(checkErr).ToNot(HaveOccurred())
+			fetchedEntry.IP = netip.MustParseAddr(fetchedIP)
 			Expect(fetchedEntry).To(Equal(*entry))
 		})
+		It("should not write an entry once the context is cancelled", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			entry := &models.ScanEntry{
+				IP:            netip.MustParseAddr("192.168.0.1"),
+				Port:          80,
+				Service:       "http",
+				ScanTimestamp: 7,
+				Response:      "HTTP/1.1 500 Internal Server Error",
+			}
+			cancelledCtx, cancel := context.WithCancel(ctx)
+			cancel()
+			Expect(db.Upsert(cancelledCtx, entry)).To(MatchError(context.Canceled))
+			var scanDate int64
+			Expect(pgxPool.QueryRow(ctx, `SELECT scan_date FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service).Scan(&scanDate)).To(Succeed())
+			Expect(scanDate).To(Equal(int64(6)))
+		})
 	})
 })
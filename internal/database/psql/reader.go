@@ -0,0 +1,159 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/routing"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	GetStmt = "SELECT " + SelectColumns + " FROM scan_data WHERE ip = $1 AND port = $2 AND service = $3"
+)
+
+// listQuery builds the statement and arguments selecting the entries matched by q.
+func listQuery(q dal.Query) (string, []any) {
+	stmt, args := selectQuery("scan_data", q)
+	stmt += " ORDER BY ip, port, service"
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		stmt += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return stmt, args
+}
+
+// historyQuery builds the statement and arguments selecting the observations matched by q.
+func historyQuery(q dal.Query) (string, []any) {
+	stmt, args := selectQuery(HistoryTable, q)
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		stmt += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return stmt, args
+}
+
+// selectQuery builds the unordered statement and arguments selecting the rows of table matched by q, ignoring its limit.
+func selectQuery(table string, q dal.Query) (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if q.IP.IsValid() {
+		add("ip = $%d", models.CanonicalAddr(q.IP).String())
+	}
+	if q.Service != "" {
+		add("service = $%d", q.Service)
+	}
+	if q.ChangedSince != 0 {
+		add("scan_date >= $%d", q.ChangedSince)
+	}
+	stmt := "SELECT " + SelectColumns + " FROM " + table
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return stmt, args
+}
+
+// unreachable reports whether err shows the endpoint could not be reached, in which case another endpoint may serve the read.
+func unreachable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.SafeToRetry(err)
+}
+
+// read runs query against the readers chosen by the router, failing over to the next reader while an endpoint is unreachable.
+func (db *psqlDB) read(ctx context.Context, operation string, query func(pool *pgxpool.Pool) error) error {
+	var err error
+	for _, endpoint := range db.router.Readers() {
+		start := time.Now()
+		err = query(endpoint.Conn)
+		metrics.ObserveDatabase(operation, endpoint.Role, endpoint.Name, start, err)
+		if err == nil || ctx.Err() != nil || !unreachable(err) {
+			return err
+		}
+		zap.S().Warnw("database endpoint unreachable, failing over", "operation", operation, "role", endpoint.Role, "endpoint", endpoint.Name, "error", err)
+		if endpoint.Role == routing.RoleReplica {
+			// The health check marks the replica healthy again once it can be reached
+			endpoint.SetHealthy(false)
+		}
+	}
+	return err
+}
+
+func (db *psqlDB) Get(ctx context.Context, ip netip.Addr, port uint32, service string) (*models.ScanEntry, error) {
+	var entry *models.ScanEntry
+	err := db.read(ctx, "get", func(pool *pgxpool.Pool) error {
+		var err error
+		entry, err = scanEntry(pool.QueryRow(ctx, GetStmt, models.CanonicalAddr(ip).String(), port, service))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	})
+	if err == nil && entry == nil {
+		err = dal.ErrNotFound
+	}
+	return entry, err
+}
+
+func (db *psqlDB) List(ctx context.Context, q dal.Query) ([]*models.ScanEntry, error) {
+	stmt, args := listQuery(q)
+	var entries []*models.ScanEntry
+	err := db.read(ctx, "list", func(pool *pgxpool.Pool) error {
+		rows, err := pool.Query(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		entries, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.ScanEntry, error) {
+			return scanEntry(row)
+		})
+		return err
+	})
+	return entries, err
+}
+
+func (db *psqlDB) Stream(ctx context.Context, q dal.Query, visit func(*models.ScanEntry) error) error {
+	stmt, args := listQuery(q)
+	return db.read(ctx, "stream", func(pool *pgxpool.Pool) error {
+		return streamRows(ctx, pool, stmt, args, visit)
+	})
+}
+
+func (db *psqlDB) StreamHistory(ctx context.Context, q dal.Query, visit func(*models.ScanEntry) error) error {
+	stmt, args := historyQuery(q)
+	return db.read(ctx, "stream_history", func(pool *pgxpool.Pool) error {
+		return streamRows(ctx, pool, stmt, args, visit)
+	})
+}
+
+// streamRows calls visit for every row selected with SelectColumns by stmt.
+func streamRows(ctx context.Context, pool *pgxpool.Pool, stmt string, args []any, visit func(*models.ScanEntry) error) error {
+	rows, err := pool.Query(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err = visit(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
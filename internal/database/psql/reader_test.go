@@ -0,0 +1,137 @@
+package psql_test
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/routing"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Reader", func() {
+	// Use an ordered describe here for integration tests that build on each other
+	Describe("Integration Testing Replica Reads", Ordered, func() {
+		const (
+			// unreachableReplica has nothing listening, so reads must fail over to the other replica or the primary
+			unreachableReplica = "127.0.0.1:1"
+			healthyReplica     = "localhost:5432"
+		)
+		var (
+			envMap = EnvMap{
+				"DATABASE_TYPE":          StringPointer("postgres"),
+				"DATABASE_HOST":          StringPointer("localhost"),
+				"DATABASE_USER":          StringPointer("censysTest"),
+				"DATABASE_PASSWORD":      StringPointer("censysS4mpl3!"),
+				"DATABASE_PORT":          StringPointer("5432"),
+				"DATABASE_NAME":          StringPointer("censys_data"),
+				"DATABASE_REPLICA_HOSTS": StringPointer(unreachableReplica + "," + healthyReplica),
+			}
+			restoreMap EnvMap
+			db         dal.Scan
+			pgxPool    *pgxpool.Pool
+			// terminatingErr existing indicates that no subsequent tests can succeed
+			terminatingErr error
+			ctx            = context.Background()
+			entries        = []*models.ScanEntry{
+				{IP: netip.MustParseAddr("10.0.0.1"), Port: 22, Service: "SSH", ScanTimestamp: 100, Response: "ssh"},
+				{IP: netip.MustParseAddr("10.0.0.1"), Port: 80, Service: "HTTP", ScanTimestamp: 200, Response: "http"},
+				{IP: netip.MustParseAddr("10.0.0.2"), Port: 80, Service: "HTTP", ScanTimestamp: 300, Response: "http"},
+			}
+		)
+		BeforeAll(func() {
+			restoreMap = envMap.SetupEnv()
+			cfg := config.ConfigFromEnv()
+			pgxPool, terminatingErr = pgxpool.New(ctx, cfg.ConnectionString())
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			db, terminatingErr = database.New()
+			// Clean up any existing test data in case the database was not reset
+			_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_data;`)
+		})
+		AfterAll(func() {
+			restoreMap.SetupEnv()
+			_, _ = pgxPool.Exec(ctx, `DELETE FROM scan_data;`)
+			if db != nil {
+				db.Close()
+			}
+			pgxPool.Close()
+		})
+		It("should insert the entries to read", func() {
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			for _, e := range entries {
+				terminatingErr = db.Upsert(ctx, e)
+				Expect(terminatingErr).ToNot(HaveOccurred())
+			}
+		})
+		It("should get an entry, failing over from the unreachable replica", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			// Every replica is tried first at least once over two reads
+			for range 2 {
+				entry, err := db.(dal.Reader).Get(ctx, entries[1].IP, entries[1].Port, entries[1].Service)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entry).To(Equal(entries[1]))
+			}
+			Expect(testutil.ToFloat64(metrics.DatabaseEndpointUp.WithLabelValues(routing.RoleReplica, unreachableReplica))).To(Equal(0.0))
+			Expect(testutil.ToFloat64(metrics.DatabaseRequests.WithLabelValues("get", routing.RoleReplica, healthyReplica, metrics.ResultOK))).To(BeNumerically(">=", 1))
+		})
+		It("should return not found for a missing entry", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			_, err := db.(dal.Reader).Get(ctx, entries[0].IP, 443, "HTTPS")
+			Expect(err).To(MatchError(dal.ErrNotFound))
+		})
+		It("should list the entries matching the query", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			found, err := db.(dal.Reader).List(ctx, dal.Query{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(Equal(entries))
+
+			found, err = db.(dal.Reader).List(ctx, dal.Query{IP: netip.MustParseAddr("10.0.0.1")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(Equal(entries[:2]))
+
+			found, err = db.(dal.Reader).List(ctx, dal.Query{Service: "HTTP", ChangedSince: 250})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(Equal(entries[2:]))
+
+			found, err = db.(dal.Reader).List(ctx, dal.Query{Limit: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(Equal(entries[:1]))
+		})
+		It("should stream the entries and history matching the query", func() {
+			if terminatingErr != nil {
+				Skip("previous test(s) failed or were skipped due to an early error")
+			}
+			streamed := []*models.ScanEntry{}
+			err := db.(dal.Streamer).Stream(ctx, dal.Query{}, func(e *models.ScanEntry) error {
+				streamed = append(streamed, e)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(streamed).To(Equal(entries))
+
+			// Other tests also write to the history, so only the observations of this test are checked
+			history := []*models.ScanEntry{}
+			err = db.(dal.HistoryStreamer).StreamHistory(ctx, dal.Query{IP: entries[2].IP, ChangedSince: 300}, func(e *models.ScanEntry) error {
+				history = append(history, e)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(history).To(ContainElement(entries[2]))
+		})
+	})
+})
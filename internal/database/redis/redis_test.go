@@ -0,0 +1,144 @@
+package redis_test
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/database/config"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/dbtest"
+	"github.com/censys/scan-takehome/internal/database/redis"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Redis", func() {
+	var (
+		server *miniredis.Miniredis
+		ctx    = context.Background()
+	)
+	// newDB connects to the server with cfg, which is given the address of the server
+	newDB := func(cfg *config.Config) (dal.Scan, error) {
+		cfg.URL = "redis://" + server.Addr() + "/0"
+		return redis.New(cfg)
+	}
+	entry := func(ip string, port uint32, service string, timestamp int64, response string) *models.ScanEntry {
+		return &models.ScanEntry{IP: netip.MustParseAddr(ip), Port: port, Service: service, ScanTimestamp: timestamp, Response: response}
+	}
+	BeforeEach(func() {
+		server = miniredis.NewMiniRedis()
+		Expect(server.Start()).To(Succeed())
+		DeferCleanup(server.Close)
+	})
+
+	Describe("Conformance", func() {
+		dbtest.Conformance(func() (dal.Scan, error) {
+			// Every spec starts from an empty server
+			server.FlushAll()
+			return newDB(&config.Config{})
+		}, dbtest.Options{})
+	})
+
+	It("should be registered as a database type", func() {
+		restoreMap := EnvMap{
+			"DATABASE_TYPE": StringPointer(redis.DB_REDIS),
+			"DATABASE_URL":  StringPointer("redis://" + server.Addr() + "/0"),
+		}.SetupEnv()
+		defer restoreMap.SetupEnv()
+		db, err := database.New()
+		Expect(err).ToNot(HaveOccurred())
+		defer db.Close()
+		Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "ok"))).To(Succeed())
+		Expect(server.Exists(redis.EntryKey(netip.MustParseAddr("10.0.0.1"), 80, "HTTP"))).To(BeTrue())
+	})
+
+	Describe("Options", func() {
+		It("should connect with the connection parts, using the database name as the database number", func() {
+			opts, err := redis.Options(&config.Config{Host: "cache", Port: 6379, User: "user", Pass: "pass", DBName: "2"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts.Addr).To(Equal("cache:6379"))
+			Expect(opts.Username).To(Equal("user"))
+			Expect(opts.Password).To(Equal("pass"))
+			Expect(opts.DB).To(Equal(2))
+			Expect(opts.TLSConfig).To(BeNil())
+		})
+		It("should reject a database name which is not a database number", func() {
+			_, err := redis.Options(&config.Config{Host: "cache", Port: 6379, DBName: "censys_data"})
+			Expect(err).To(MatchError(`invalid redis database number: "censys_data"`))
+		})
+		It("should apply the pool, timeout and TLS settings to the URL", func() {
+			opts, err := redis.Options(&config.Config{
+				URL: "redis://cache:6380/1", PoolMaxConns: 8, PoolMinConns: 2, ConnMaxLifetime: time.Hour,
+				StatementTimeout: 50 * time.Millisecond, SSLMode: "require",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts.Addr).To(Equal("cache:6380"))
+			Expect(opts.DB).To(Equal(1))
+			Expect(opts.PoolSize).To(Equal(8))
+			Expect(opts.MinIdleConns).To(Equal(2))
+			Expect(opts.ConnMaxLifetime).To(Equal(time.Hour))
+			Expect(opts.ReadTimeout).To(Equal(50 * time.Millisecond))
+			Expect(opts.TLSConfig.InsecureSkipVerify).To(BeTrue())
+		})
+	})
+
+	Context("with a TTL", func() {
+		var db dal.Scan
+		BeforeEach(func() {
+			var err error
+			db, err = newDB(&config.Config{TTL: time.Minute})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(db.Close)
+		})
+		It("should expire entries which are not replaced within the TTL", func() {
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "first"))).To(Succeed())
+			server.FastForward(30 * time.Second)
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 22, "SSH", 100, "ssh"))).To(Succeed())
+			// A newer scan restarts the TTL of its entry
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 200, "second"))).To(Succeed())
+			server.FastForward(45 * time.Second)
+			Expect(db.(dal.Reader).List(ctx, dal.Query{})).To(HaveLen(2))
+			server.FastForward(30 * time.Second)
+			_, err := db.(dal.Reader).Get(ctx, netip.MustParseAddr("10.0.0.1"), 80, "HTTP")
+			Expect(err).To(MatchError(dal.ErrNotFound))
+			Expect(server.Exists(redis.HostKey(netip.MustParseAddr("10.0.0.1")))).To(BeFalse())
+		})
+		It("should remove expired entries from the host index when the host is listed", func() {
+			host := netip.MustParseAddr("10.0.0.1")
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 80, "HTTP", 100, "http"))).To(Succeed())
+			Expect(db.Upsert(ctx, entry("10.0.0.1", 22, "SSH", 100, "ssh"))).To(Succeed())
+			// Expire a single entry, as if it had been written before the other
+			server.Del(redis.EntryKey(host, 22, "SSH"))
+			Expect(db.(dal.Reader).List(ctx, dal.Query{IP: host})).To(Equal([]*models.ScanEntry{entry("10.0.0.1", 80, "HTTP", 100, "http")}))
+			Expect(server.Members(redis.HostKey(host))).To(Equal([]string{redis.EntryKey(host, 80, "HTTP")}))
+		})
+	})
+
+	It("should list every port of a host through its index", func() {
+		db, err := newDB(&config.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		defer db.Close()
+		Expect(db.Upsert(ctx, entry("10.0.0.1", 443, "HTTPS", 100, "https"))).To(Succeed())
+		Expect(db.Upsert(ctx, entry("10.0.0.1", 22, "SSH", 100, "ssh"))).To(Succeed())
+		Expect(db.Upsert(ctx, entry("10.0.0.10", 22, "SSH", 100, "ssh"))).To(Succeed())
+		Expect(server.Members(redis.HostKey(netip.MustParseAddr("10.0.0.1")))).To(HaveLen(2))
+		Expect(db.(dal.Reader).List(ctx, dal.Query{IP: netip.MustParseAddr("::ffff:10.0.0.1")})).To(Equal([]*models.ScanEntry{
+			entry("10.0.0.1", 22, "SSH", 100, "ssh"), entry("10.0.0.1", 443, "HTTPS", 100, "https"),
+		}))
+	})
+
+	It("should fail to read an entry which cannot be decoded", func() {
+		db, err := newDB(&config.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		defer db.Close()
+		server.HSet(redis.EntryKey(netip.MustParseAddr("10.0.0.1"), 80, "HTTP"), "ip", "10.0.0.1", "port", "eighty")
+		_, err = db.(dal.Reader).Get(ctx, netip.MustParseAddr("10.0.0.1"), 80, "HTTP")
+		Expect(err).To(MatchError(redis.ErrCorruptEntry))
+	})
+})
@@ -0,0 +1,30 @@
+-- Replaces the stored entry only if the new entry is newer, ordering entries as models.ScanEntry.NewerThan:
+-- by scan_date, then scan_nanos, then the hex encoded response_hash (whose text order is the order of the bytes).
+--
+-- KEYS[1] is the entry hash and KEYS[2] the host index set of its ip.
+-- ARGV holds scan_date, scan_nanos, response_hash, response, ip, port, service, the ttl in milliseconds (0 for none),
+-- asn, as_org, country, parsed (JSON, '' for none), parse_error, vendor, product, product_version and cpe.
+-- Returns 1 if the entry was written and 0 if the stored entry is as new or newer.
+local stored = redis.call('HMGET', KEYS[1], 'scan_date', 'scan_nanos', 'response_hash')
+if stored[1] then
+  local date, nanos = tonumber(stored[1]), tonumber(stored[2])
+  local newDate, newNanos = tonumber(ARGV[1]), tonumber(ARGV[2])
+  if date > newDate or (date == newDate and (nanos > newNanos or (nanos == newNanos and stored[3] >= ARGV[3]))) then
+    return 0
+  end
+end
+redis.call('HSET', KEYS[1], 'scan_date', ARGV[1], 'scan_nanos', ARGV[2], 'response_hash', ARGV[3], 'response', ARGV[4],
+  'ip', ARGV[5], 'port', ARGV[6], 'service', ARGV[7], 'asn', ARGV[9], 'as_org', ARGV[10], 'country', ARGV[11],
+  'parsed', ARGV[12], 'parse_error', ARGV[13], 'vendor', ARGV[14], 'product', ARGV[15], 'product_version', ARGV[16],
+  'cpe', ARGV[17])
+redis.call('SADD', KEYS[2], KEYS[1])
+local ttl = tonumber(ARGV[8])
+if ttl > 0 then
+  -- The index expires with the last entry written to it, which is the last of its entries to expire
+  redis.call('PEXPIRE', KEYS[1], ttl)
+  redis.call('PEXPIRE', KEYS[2], ttl)
+else
+  redis.call('PERSIST', KEYS[1])
+  redis.call('PERSIST', KEYS[2])
+end
+return 1
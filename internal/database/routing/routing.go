@@ -0,0 +1,123 @@
+// Package routing selects the database endpoint which serves a request.
+// Writes are served by the primary, while reads are spread over the healthy replicas and fall back to the primary
+// when no replica is available.
+package routing
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/metrics"
+)
+
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
+// Endpoint is a single database endpoint and its connection.
+type Endpoint[T any] struct {
+	Role string
+	Name string
+	Conn T
+
+	healthy atomic.Bool
+}
+
+// NewEndpoint creates an endpoint which is initially healthy.
+func NewEndpoint[T any](role, name string, conn T) *Endpoint[T] {
+	e := &Endpoint[T]{Role: role, Name: name, Conn: conn}
+	e.healthy.Store(true)
+	metrics.DatabaseEndpointUp.WithLabelValues(role, name).Set(1)
+	return e
+}
+
+// Healthy reports whether the endpoint is currently considered healthy.
+func (e *Endpoint[T]) Healthy() bool {
+	return e.healthy.Load()
+}
+
+// SetHealthy updates the health of the endpoint.
+func (e *Endpoint[T]) SetHealthy(healthy bool) {
+	if e.healthy.Swap(healthy) == healthy {
+		return
+	}
+	up := 0.0
+	if healthy {
+		up = 1
+		zap.S().Infow("database endpoint is healthy", "role", e.Role, "endpoint", e.Name)
+	} else {
+		zap.S().Warnw("database endpoint is unhealthy", "role", e.Role, "endpoint", e.Name)
+	}
+	metrics.DatabaseEndpointUp.WithLabelValues(e.Role, e.Name).Set(up)
+}
+
+// Router routes requests between a primary and its replicas.
+type Router[T any] struct {
+	primary  *Endpoint[T]
+	replicas []*Endpoint[T]
+	next     atomic.Uint64
+}
+
+// NewRouter creates a router for the primary and replica endpoints.
+func NewRouter[T any](primary *Endpoint[T], replicas ...*Endpoint[T]) *Router[T] {
+	return &Router[T]{primary: primary, replicas: replicas}
+}
+
+// Primary returns the endpoint which serves writes.
+func (r *Router[T]) Primary() *Endpoint[T] {
+	return r.primary
+}
+
+// Replicas returns every replica endpoint, healthy or not.
+func (r *Router[T]) Replicas() []*Endpoint[T] {
+	return r.replicas
+}
+
+// Readers returns the endpoints which may serve a read in the order they should be tried.
+// The healthy replicas are returned round-robin, followed by the primary.
+func (r *Router[T]) Readers() []*Endpoint[T] {
+	readers := make([]*Endpoint[T], 0, len(r.replicas)+1)
+	if n := len(r.replicas); n > 0 {
+		start := int(r.next.Add(1) % uint64(n))
+		for i := range n {
+			if replica := r.replicas[(start+i)%n]; replica.Healthy() {
+				readers = append(readers, replica)
+			}
+		}
+	}
+	return append(readers, r.primary)
+}
+
+// Check pings every replica, waiting up to timeout for each, and updates its health.
+func (r *Router[T]) Check(ctx context.Context, timeout time.Duration, ping func(ctx context.Context, conn T) error) {
+	for _, replica := range r.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := ping(pingCtx, replica.Conn)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			zap.S().Debugw("database endpoint health check failed", "role", replica.Role, "endpoint", replica.Name, "error", err)
+		}
+		replica.SetHealthy(err == nil)
+	}
+}
+
+// Run checks the replicas every interval until ctx is done.
+func (r *Router[T]) Run(ctx context.Context, interval time.Duration, ping func(ctx context.Context, conn T) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx, interval, ping)
+		}
+	}
+}
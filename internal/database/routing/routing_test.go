@@ -0,0 +1,83 @@
+package routing_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/censys/scan-takehome/internal/database/routing"
+	"github.com/censys/scan-takehome/internal/metrics"
+)
+
+var _ = Describe("Router", func() {
+	var (
+		primary  *routing.Endpoint[string]
+		replica1 *routing.Endpoint[string]
+		replica2 *routing.Endpoint[string]
+		router   *routing.Router[string]
+	)
+	names := func(endpoints []*routing.Endpoint[string]) []string {
+		result := []string{}
+		for _, e := range endpoints {
+			result = append(result, e.Name)
+		}
+		return result
+	}
+	BeforeEach(func() {
+		primary = routing.NewEndpoint(routing.RolePrimary, "primary:5432", "primary")
+		replica1 = routing.NewEndpoint(routing.RoleReplica, "replica1:5432", "replica1")
+		replica2 = routing.NewEndpoint(routing.RoleReplica, "replica2:5432", "replica2")
+		router = routing.NewRouter(primary, replica1, replica2)
+	})
+	It("should only read from the primary without replicas", func() {
+		router = routing.NewRouter(primary)
+		Expect(router.Readers()).To(Equal([]*routing.Endpoint[string]{primary}))
+		Expect(router.Primary()).To(Equal(primary))
+	})
+	It("should spread reads over the replicas, falling back to the primary", func() {
+		first := names(router.Readers())
+		second := names(router.Readers())
+		Expect(first).To(HaveLen(3))
+		Expect(first[2]).To(Equal("primary:5432"))
+		Expect(first[:2]).To(ConsistOf("replica1:5432", "replica2:5432"))
+		Expect(second[0]).ToNot(Equal(first[0]))
+	})
+	It("should skip unhealthy replicas", func() {
+		replica1.SetHealthy(false)
+		Expect(names(router.Readers())).To(Equal([]string{"replica2:5432", "primary:5432"}))
+		replica2.SetHealthy(false)
+		Expect(names(router.Readers())).To(Equal([]string{"primary:5432"}))
+		replica1.SetHealthy(true)
+		Expect(names(router.Readers())).To(Equal([]string{"replica1:5432", "primary:5432"}))
+	})
+	It("should update the replica health and metrics when checked", func() {
+		router.Check(context.Background(), time.Second, func(_ context.Context, conn string) error {
+			if conn == "replica1" {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		Expect(replica1.Healthy()).To(BeFalse())
+		Expect(replica2.Healthy()).To(BeTrue())
+		Expect(testutil.ToFloat64(metrics.DatabaseEndpointUp.WithLabelValues(routing.RoleReplica, "replica1:5432"))).To(Equal(0.0))
+		Expect(testutil.ToFloat64(metrics.DatabaseEndpointUp.WithLabelValues(routing.RoleReplica, "replica2:5432"))).To(Equal(1.0))
+	})
+	It("should check the replicas until the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			router.Run(ctx, 10*time.Millisecond, func(_ context.Context, _ string) error {
+				return errors.New("connection refused")
+			})
+		}()
+		Eventually(replica1.Healthy).Should(BeFalse())
+		Eventually(replica2.Healthy).Should(BeFalse())
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+})
@@ -0,0 +1,192 @@
+package dedup_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/dedup"
+)
+
+var _ = Describe("Config", func() {
+	DescribeTable("Configuration from environment validation",
+		func(vars EnvMap, expConfig *dedup.Config, expErrRegex ...string) {
+			restoreMap := vars.SetupEnv()
+			defer restoreMap.SetupEnv()
+
+			cfg := dedup.ConfigFromEnv()
+			err := cfg.Validate()
+
+			Expect(cfg).To(Equal(expConfig))
+			if len(expErrRegex) == 0 {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(HaveOccurred())
+			for _, v := range expErrRegex {
+				Expect(err.Error()).To(MatchRegexp(v))
+			}
+		},
+		Entry("Disabled", EnvMap{"DEDUP_BACKEND": nil, "DEDUP_KEY": nil, "DEDUP_REDIS_URL": nil}, &dedup.Config{}),
+		Entry(
+			"Memory keyed by content",
+			EnvMap{"DEDUP_BACKEND": StringPointer("memory"), "DEDUP_KEY": StringPointer("content"), "DEDUP_WINDOW": StringPointer("1m"), "DEDUP_REDIS_URL": nil},
+			&dedup.Config{Backend: dedup.BackendMemory, Key: dedup.KeyContent, Window: time.Minute},
+		),
+		Entry(
+			"Unknown backend",
+			EnvMap{"DEDUP_BACKEND": StringPointer("disk"), "DEDUP_KEY": nil, "DEDUP_REDIS_URL": nil},
+			&dedup.Config{Backend: "disk"},
+			`.*Config\.Backend.* for 'Backend' failed on the 'oneof' tag`,
+		),
+		Entry(
+			"Redis without a URL",
+			EnvMap{"DEDUP_BACKEND": StringPointer("redis"), "DEDUP_KEY": StringPointer("hash"), "DEDUP_REDIS_URL": nil},
+			&dedup.Config{Backend: dedup.BackendRedis, Key: "hash"},
+			`.*Config\.Key.* for 'Key' failed on the 'oneof' tag`,
+			`.*Config\.RedisURL.* for 'RedisURL' failed on the 'required_if' tag`,
+		),
+	)
+	It("should use the defaults when unset", func() {
+		cfg := &dedup.Config{}
+		Expect(cfg.WindowPeriod()).To(Equal(dedup.DefaultWindow))
+		Expect(cfg.ProcessingPeriod()).To(Equal(dedup.DefaultProcessingTTL))
+		Expect(cfg.EntryLimit()).To(Equal(dedup.DefaultMaxEntries))
+	})
+	It("should not create a deduplicator when disabled", func() {
+		d, err := dedup.New(&dedup.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d).To(BeNil())
+	})
+})
+
+var _ = Describe("Deduplicator", func() {
+	ctx := context.Background()
+
+	It("should key messages by message ID", func() {
+		d := dedup.NewDeduplicator(dedup.NewMemoryStore(10), false, time.Minute, time.Second)
+		Expect(d.Key("1", []byte("a"))).To(Equal(d.Key("1", []byte("b"))))
+		Expect(d.Key("1", []byte("a"))).ToNot(Equal(d.Key("2", []byte("a"))))
+	})
+	It("should key messages by content", func() {
+		d := dedup.NewDeduplicator(dedup.NewMemoryStore(10), true, time.Minute, time.Second)
+		Expect(d.Key("1", []byte("a"))).To(Equal(d.Key("2", []byte("a"))))
+		Expect(d.Key("1", []byte("a"))).ToNot(Equal(d.Key("1", []byte("b"))))
+	})
+	It("should claim a message once, telling a message being handled from one which was processed", func() {
+		d := dedup.NewDeduplicator(dedup.NewMemoryStore(10), false, time.Minute, time.Second)
+		key := d.Key("1", nil)
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Claimed))
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Processing))
+		Expect(d.Mark(ctx, key)).To(Succeed())
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Processed))
+	})
+	It("should claim a message again once its claim is released", func() {
+		d := dedup.NewDeduplicator(dedup.NewMemoryStore(10), false, time.Minute, time.Second)
+		key := d.Key("1", nil)
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Claimed))
+		Expect(d.Release(ctx, key)).To(Succeed())
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Claimed))
+	})
+	It("should always claim a message without a message ID", func() {
+		d := dedup.NewDeduplicator(dedup.NewMemoryStore(10), false, time.Minute, time.Second)
+		key := d.Key("", nil)
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Claimed))
+		Expect(d.Mark(ctx, key)).To(Succeed())
+		Expect(d.Claim(ctx, key)).To(Equal(dedup.Claimed))
+	})
+})
+
+var _ = Describe("MemoryStore", func() {
+	ctx := context.Background()
+
+	It("should let keys be claimed again once their claim or window has passed", func() {
+		store := dedup.NewMemoryStore(10)
+		Expect(store.Claim(ctx, "a", 20*time.Millisecond)).To(Equal(dedup.Claimed))
+		Expect(store.Claim(ctx, "a", time.Minute)).To(Equal(dedup.Processing))
+		Eventually(func() (dedup.Claim, error) { return store.Claim(ctx, "a", time.Minute) }).Should(Equal(dedup.Claimed))
+		Expect(store.Mark(ctx, "a", 20*time.Millisecond)).To(Succeed())
+		Expect(store.Claim(ctx, "a", time.Minute)).To(Equal(dedup.Processed))
+		Eventually(func() (dedup.Claim, error) { return store.Claim(ctx, "a", time.Minute) }).Should(Equal(dedup.Claimed))
+	})
+	It("should forget released keys", func() {
+		store := dedup.NewMemoryStore(10)
+		Expect(store.Claim(ctx, "a", time.Minute)).To(Equal(dedup.Claimed))
+		Expect(store.Release(ctx, "a")).To(Succeed())
+		Expect(store.Len()).To(BeZero())
+		Expect(store.Release(ctx, "a")).To(Succeed())
+	})
+	It("should evict the least recently used key once full", func() {
+		store := dedup.NewMemoryStore(2)
+		Expect(store.Mark(ctx, "a", time.Minute)).To(Succeed())
+		Expect(store.Mark(ctx, "b", time.Minute)).To(Succeed())
+		Expect(store.Claim(ctx, "a", time.Minute)).To(Equal(dedup.Processed))
+		Expect(store.Mark(ctx, "c", time.Minute)).To(Succeed())
+		Expect(store.Len()).To(Equal(2))
+		Expect(store.Claim(ctx, "a", time.Minute)).To(Equal(dedup.Processed))
+		Expect(store.Claim(ctx, "c", time.Minute)).To(Equal(dedup.Processed))
+		Expect(store.Claim(ctx, "b", time.Minute)).To(Equal(dedup.Claimed))
+	})
+})
+
+var _ = Describe("RedisStore", func() {
+	var (
+		server *miniredis.Miniredis
+		ctx    = context.Background()
+	)
+	BeforeEach(func() {
+		server = miniredis.NewMiniRedis()
+		Expect(server.Start()).To(Succeed())
+		DeferCleanup(server.Close)
+	})
+
+	It("should share marked keys between processors until their window has passed", func() {
+		cfg := &dedup.Config{Backend: dedup.BackendRedis, RedisURL: "redis://" + server.Addr() + "/0", Window: time.Minute}
+		first, err := dedup.New(cfg)
+		Expect(err).ToNot(HaveOccurred())
+		defer first.Close()
+		second, err := dedup.New(cfg)
+		Expect(err).ToNot(HaveOccurred())
+		defer second.Close()
+
+		key := first.Key("1", nil)
+		Expect(first.Claim(ctx, key)).To(Equal(dedup.Claimed))
+		Expect(second.Claim(ctx, key)).To(Equal(dedup.Processing))
+		Expect(server.TTL(dedup.RedisKeyPrefix + key)).To(Equal(dedup.DefaultProcessingTTL))
+		Expect(first.Mark(ctx, key)).To(Succeed())
+		Expect(server.TTL(dedup.RedisKeyPrefix + key)).To(Equal(time.Minute))
+		Expect(second.Claim(ctx, key)).To(Equal(dedup.Processed))
+		server.FastForward(time.Minute)
+		Expect(second.Claim(ctx, key)).To(Equal(dedup.Claimed))
+	})
+	It("should let another processor claim a message once its claim is released or expires", func() {
+		cfg := &dedup.Config{Backend: dedup.BackendRedis, RedisURL: "redis://" + server.Addr() + "/0", ProcessingTTL: time.Second}
+		first, err := dedup.New(cfg)
+		Expect(err).ToNot(HaveOccurred())
+		defer first.Close()
+		second, err := dedup.New(cfg)
+		Expect(err).ToNot(HaveOccurred())
+		defer second.Close()
+
+		key := first.Key("1", nil)
+		Expect(first.Claim(ctx, key)).To(Equal(dedup.Claimed))
+		Expect(first.Release(ctx, key)).To(Succeed())
+		Expect(server.Exists(dedup.RedisKeyPrefix + key)).To(BeFalse())
+		Expect(second.Claim(ctx, key)).To(Equal(dedup.Claimed))
+		// The processor handling the message died without acking or nacking it
+		server.FastForward(time.Second)
+		Expect(first.Claim(ctx, key)).To(Equal(dedup.Claimed))
+	})
+	It("should report errors of the server", func() {
+		store, err := dedup.NewRedisStore("redis://" + server.Addr() + "/0")
+		Expect(err).ToNot(HaveOccurred())
+		defer store.Close()
+		server.Close()
+		_, err = store.Claim(ctx, "a", time.Second)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,89 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	key       string
+	expires   time.Time
+	processed bool
+}
+
+// MemoryStore is an LRU of keys held by a single processor, which evicts the least recently claimed or marked key
+// once it holds maxEntries keys.
+type MemoryStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// NewMemoryStore creates a MemoryStore which holds up to maxEntries keys.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{maxEntries: maxEntries, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string, ttl time.Duration) (Claim, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, found := s.entries[key]; found {
+		if entry := element.Value.(*memoryEntry); !time.Now().After(entry.expires) {
+			s.lru.MoveToFront(element)
+			if entry.processed {
+				return Processed, nil
+			}
+			return Processing, nil
+		}
+	}
+	s.set(key, ttl, false)
+	return Claimed, nil
+}
+
+func (s *MemoryStore) Mark(ctx context.Context, key string, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, window, true)
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, found := s.entries[key]; found {
+		s.lru.Remove(element)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// set keeps the key, claimed or processed, for the period as the most recently used, evicting the least recently used
+// keys past the limit; s.mu must be held.
+func (s *MemoryStore) set(key string, period time.Duration, processed bool) {
+	expires := time.Now().Add(period)
+	if element, found := s.entries[key]; found {
+		entry := element.Value.(*memoryEntry)
+		entry.expires, entry.processed = expires, processed
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, expires: expires, processed: processed})
+	for s.lru.Len() > s.maxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// Len returns the number of keys held, including those which expired but have not been evicted yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryStore) Close() {}
@@ -0,0 +1,60 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// RedisKeyPrefix prefixes the keys of processed messages, which may share a server with the redis database.
+	RedisKeyPrefix = "scan:dedup:"
+)
+
+// claimScript sets a key to 0 (claimed) for the ttl in milliseconds unless it exists, returning the Claim: Claimed if
+// it was set, and otherwise Processing if the key is claimed or Processed if it is marked (1).
+var claimScript = goredis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value == "1" then
+	return 2
+elseif value then
+	return 1
+end
+redis.call("SET", KEYS[1], "0", "PX", ARGV[1])
+return 0
+`)
+
+// RedisStore keeps keys in redis (or a compatible server such as Valkey) so that every processor replica dedupes
+// together; a key is claimed atomically by a script, so only one replica handles a message, and expires with its ttl
+// or window.
+type RedisStore struct {
+	client *goredis.Client
+}
+
+// NewRedisStore connects to the redis server at the URL.
+func NewRedisStore(url string) (*RedisStore, error) {
+	opts, err := goredis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	opts.ContextTimeoutEnabled = true
+	return &RedisStore{client: goredis.NewClient(opts)}, nil
+}
+
+func (s *RedisStore) Claim(ctx context.Context, key string, ttl time.Duration) (Claim, error) {
+	claim, err := claimScript.Run(ctx, s.client, []string{RedisKeyPrefix + key}, max(ttl.Milliseconds(), 1)).Int()
+	return Claim(claim), err
+}
+
+func (s *RedisStore) Mark(ctx context.Context, key string, window time.Duration) error {
+	return s.client.Set(ctx, RedisKeyPrefix+key, 1, window).Err()
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, RedisKeyPrefix+key).Err()
+}
+
+func (s *RedisStore) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,93 @@
+// Package filter decides which scans are stored, from a file of allow and deny rules for CIDRs, port ranges and
+// services (see Parse), so that customer-excluded ranges and unsupported services are never written.
+// The file is reloaded when it changes or on SIGHUP, without restarting the processor.
+package filter
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/censys/scan-takehome/internal/reload"
+)
+
+const (
+	DefaultReloadInterval = 30 * time.Second
+)
+
+// Config holds the filter configuration.
+type Config struct {
+	// File is the path of the rules file; scans are not filtered when it is empty.
+	File string `env:"FILTER_FILE"`
+	// ReloadInterval is how often the file is checked for changes; zero uses DefaultReloadInterval and a negative
+	// interval only reloads the file on SIGHUP.
+	ReloadInterval time.Duration `env:"FILTER_RELOAD_INTERVAL"`
+}
+
+// ConfigFromEnv returns a filter configuration which has been pre-loaded from the environment.
+func ConfigFromEnv() *Config {
+	cfg := &Config{}
+	env.Parse(cfg)
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	return validate.Struct(c)
+}
+
+// ReloadEvery returns the configured ReloadInterval, the default if it is not set, or zero if polling is disabled.
+func (c *Config) ReloadEvery() time.Duration {
+	switch {
+	case c.ReloadInterval == 0:
+		return DefaultReloadInterval
+	case c.ReloadInterval < 0:
+		return 0
+	}
+	return c.ReloadInterval
+}
+
+// Filter holds the rules loaded from a file.
+type Filter struct {
+	file *reload.File[*Rules]
+}
+
+// New loads the rules configured in cfg, or returns nil if no file is configured.
+func New(cfg *Config) (*Filter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.File == "" {
+		return nil, nil
+	}
+	return Load(cfg.File, cfg.ReloadEvery())
+}
+
+// Load loads the rules of the file at path, which Run reloads every interval once it changes.
+func Load(path string, interval time.Duration) (*Filter, error) {
+	file, err := reload.Load("filter rules", path, interval, Parse)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{file: file}, nil
+}
+
+// Allow reports whether a scan of the service at the ip and port is stored and, if it is not, which kind of rule
+// filtered it out.
+func (f *Filter) Allow(ip netip.Addr, port uint32, service string) (bool, string) {
+	return f.file.Rules().Allow(ip, port, service)
+}
+
+// Reload reads the file again and replaces the rules if it changed, reporting whether it did.
+// The rules are kept if the file cannot be read or parsed.
+func (f *Filter) Reload() (bool, error) {
+	return f.file.Reload()
+}
+
+// Run reloads the rules every interval, when the file changed, and on SIGHUP until the context is cancelled.
+func (f *Filter) Run(ctx context.Context) {
+	f.file.Run(ctx)
+}
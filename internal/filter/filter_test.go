@@ -0,0 +1,186 @@
+package filter_test
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/filter"
+)
+
+var _ = Describe("Rules", func() {
+	parse := func(lines ...string) *filter.Rules {
+		rules, err := filter.Parse(strings.NewReader(strings.Join(lines, "\n")))
+		Expect(err).ToNot(HaveOccurred())
+		return rules
+	}
+	allowed := func(rules *filter.Rules, ip string, port uint32, service string) bool {
+		allow, _ := rules.Allow(netip.MustParseAddr(ip), port, service)
+		return allow
+	}
+
+	It("should allow everything without rules", func() {
+		rules := parse("# nothing is filtered", "")
+		Expect(rules.Len()).To(BeZero())
+		Expect(allowed(rules, "10.0.0.1", 80, "HTTP")).To(BeTrue())
+	})
+	It("should let the longest matching CIDR decide", func() {
+		rules := parse("deny cidr 10.0.0.0/8", "allow cidr 10.1.0.0/16", "deny cidr 10.1.2.3")
+		Expect(allowed(rules, "10.0.0.1", 80, "HTTP")).To(BeFalse())
+		Expect(allowed(rules, "10.1.0.1", 80, "HTTP")).To(BeTrue())
+		Expect(allowed(rules, "10.1.2.3", 80, "HTTP")).To(BeFalse())
+		// Allow rules do not change the default, so what no rule matches is still allowed
+		Expect(allowed(rules, "192.168.0.1", 80, "HTTP")).To(BeTrue())
+	})
+	It("should match IPv4-mapped addresses and prefixes as IPv4", func() {
+		rules := parse("deny cidr ::ffff:10.0.0.0/104", "deny cidr 2001:db8::/32")
+		Expect(allowed(rules, "10.0.0.1", 80, "HTTP")).To(BeFalse())
+		Expect(allowed(rules, "::ffff:10.0.0.2", 80, "HTTP")).To(BeFalse())
+		Expect(allowed(rules, "2001:db8::1", 80, "HTTP")).To(BeFalse())
+		Expect(allowed(rules, "2001:db9::1", 80, "HTTP")).To(BeTrue())
+	})
+	It("should let a deny rule win over an allow rule which is as specific", func() {
+		rules := parse("allow cidr 10.0.0.0/8", "deny cidr 10.0.0.0/8", "allow port 80", "deny port 80")
+		Expect(allowed(rules, "10.0.0.1", 443, "HTTP")).To(BeFalse())
+		Expect(allowed(rules, "192.168.0.1", 80, "HTTP")).To(BeFalse())
+	})
+	It("should let the narrowest matching port range decide", func() {
+		rules := parse("deny port 0-1023", "allow port 80", "allow port 443")
+		Expect(allowed(rules, "10.0.0.1", 22, "SSH")).To(BeFalse())
+		Expect(allowed(rules, "10.0.0.1", 80, "HTTP")).To(BeTrue())
+		Expect(allowed(rules, "10.0.0.1", 8080, "HTTP")).To(BeTrue())
+		rules = parse("default deny port", "allow port 80")
+		Expect(allowed(rules, "10.0.0.1", 8080, "HTTP")).To(BeFalse())
+	})
+	It("should match services regardless of case and report the kind of rule which filtered a scan", func() {
+		rules := parse("DENY service telnet", "deny cidr 192.168.0.0/16")
+		Expect(allowed(rules, "10.0.0.1", 23, "TELNET")).To(BeFalse())
+		Expect(allowed(rules, "10.0.0.1", 80, "http")).To(BeTrue())
+		_, kind := rules.Allow(netip.MustParseAddr("10.0.0.1"), 23, "Telnet")
+		Expect(kind).To(Equal(filter.KindService))
+		_, kind = rules.Allow(netip.MustParseAddr("192.168.0.1"), 23, "TELNET")
+		Expect(kind).To(Equal(filter.KindCIDR))
+	})
+	It("should only allow the listed services when services are denied by default", func() {
+		rules := parse("allow service HTTP")
+		Expect(allowed(rules, "10.0.0.1", 22, "SSH")).To(BeTrue())
+		rules = parse("default deny service", "allow service HTTP")
+		Expect(allowed(rules, "10.0.0.1", 80, "HTTP")).To(BeTrue())
+		Expect(allowed(rules, "10.0.0.1", 22, "SSH")).To(BeFalse())
+		Expect(allowed(rules, "192.168.0.1", 22, "HTTP")).To(BeTrue())
+	})
+	It("should let the default of a kind win over the default of every kind", func() {
+		rules := parse("default allow port", "DEFAULT DENY", "allow cidr 10.0.0.0/8", "allow service HTTP")
+		Expect(allowed(rules, "10.0.0.1", 8080, "HTTP")).To(BeTrue())
+		Expect(allowed(rules, "192.168.0.1", 8080, "HTTP")).To(BeFalse())
+		_, kind := rules.Allow(netip.MustParseAddr("10.0.0.1"), 8080, "SSH")
+		Expect(kind).To(Equal(filter.KindService))
+	})
+	DescribeTable("should reject invalid rules",
+		func(line, expected string) {
+			_, err := filter.Parse(strings.NewReader("deny port 22\n" + line))
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+			Expect(err).To(MatchError(ContainSubstring("line 2")))
+		},
+		Entry("missing value", "deny cidr", "expected <allow|deny> <cidr|port|service> <value>"),
+		Entry("unknown action", "block cidr 10.0.0.0/8", `unknown action "block"`),
+		Entry("unknown kind", "deny host example.com", `unknown kind "host"`),
+		Entry("invalid CIDR", "deny cidr 10.0.0.0/33", "10.0.0.0/33"),
+		Entry("invalid port", "deny port 70000", `invalid port "70000"`),
+		Entry("inverted port range", "deny port 100-10", "minimum port is greater than maximum"),
+		Entry("conflicting service", "allow service SSH\ndeny service ssh", "conflicts with the rule on line 2"),
+		Entry("missing default", "default", "expected default <allow|deny> [cidr|port|service]"),
+		Entry("unknown default action", "default block", `unknown action "block"`),
+		Entry("unknown default kind", "default deny host", `unknown kind "host"`),
+		Entry("repeated default", "default deny port\ndefault allow port", "conflicts with the default on line 2"),
+	)
+})
+
+var _ = Describe("Filter", func() {
+	var path string
+	write := func(lines ...string) {
+		Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)).To(Succeed())
+	}
+	allowed := func(f *filter.Filter, ip string) func() bool {
+		return func() bool {
+			allow, _ := f.Allow(netip.MustParseAddr(ip), 80, "HTTP")
+			return allow
+		}
+	}
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "filter.rules")
+	})
+
+	It("should not filter without a file", func() {
+		f, err := filter.New(&filter.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(BeNil())
+	})
+	It("should fail to load a missing or invalid file", func() {
+		_, err := filter.New(&filter.Config{File: path})
+		Expect(err).To(HaveOccurred())
+		write("deny everything")
+		_, err = filter.New(&filter.Config{File: path})
+		Expect(err).To(HaveOccurred())
+	})
+	It("should reload the rules once the file changes and keep them if it becomes invalid", func() {
+		write("deny cidr 10.0.0.0/8")
+		f, err := filter.New(&filter.Config{File: path})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(allowed(f, "10.0.0.1")()).To(BeFalse())
+		Expect(f.Reload()).To(BeFalse())
+
+		write("deny cidr 192.168.0.0/16")
+		Expect(f.Reload()).To(BeTrue())
+		Expect(allowed(f, "10.0.0.1")()).To(BeTrue())
+
+		write("deny everything")
+		_, err = f.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(allowed(f, "192.168.0.1")()).To(BeFalse())
+	})
+	It("should reload the rules every interval while running", func() {
+		write("deny cidr 10.0.0.0/8")
+		f, err := filter.Load(path, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f.Run(ctx)
+		}()
+		write("allow cidr 10.0.0.0/8")
+		Eventually(allowed(f, "10.0.0.1")).Should(BeTrue())
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+	It("should reload the rules on SIGHUP", func() {
+		write("deny cidr 10.0.0.0/8")
+		f, err := filter.Load(path, 0)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// The test catches SIGHUP as well, so that a signal sent before Run starts handling it does not kill the process
+		caught := make(chan os.Signal, 1)
+		signal.Notify(caught, syscall.SIGHUP)
+		defer signal.Stop(caught)
+		go f.Run(ctx)
+		write("allow cidr 10.0.0.0/8")
+		Eventually(func() bool {
+			Expect(syscall.Kill(syscall.Getpid(), syscall.SIGHUP)).To(Succeed())
+			return allowed(f, "10.0.0.1")()
+		}).Should(BeTrue())
+	})
+	It("should use the default reload interval and disable polling with a negative interval", func() {
+		Expect((&filter.Config{}).ReloadEvery()).To(Equal(filter.DefaultReloadInterval))
+		Expect((&filter.Config{ReloadInterval: -1}).ReloadEvery()).To(BeZero())
+	})
+})
@@ -0,0 +1,256 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+
+	// DirectiveDefault sets what is decided when no rule matches.
+	DirectiveDefault = "default"
+
+	KindCIDR    = "cidr"
+	KindPort    = "port"
+	KindService = "service"
+)
+
+type cidrRule struct {
+	prefix netip.Prefix
+	allow  bool
+}
+
+type portRule struct {
+	ports models.PortRange
+	allow bool
+}
+
+type serviceRule struct {
+	allow bool
+	line  int
+}
+
+type defaultRule struct {
+	allow bool
+	line  int
+}
+
+// Rules decide which scans are stored by their ip, port and service.
+//
+// Each of the three is decided separately and a scan is only stored if all three are allowed. Of the rules which match,
+// the most specific decides: the longest CIDR prefix and the narrowest port range (a service only has one rule), and a
+// deny rule wins over an allow rule which is as specific. When no rule matches, the default of that kind decides, which
+// is to allow unless set otherwise.
+type Rules struct {
+	cidrs    []cidrRule
+	ports    []portRule
+	services map[string]serviceRule
+
+	// defaults holds the default of each kind which is set, keyed by the kind or by "" for the default of every kind.
+	defaults map[string]defaultRule
+}
+
+// Parse reads rules with one rule per line of the form "<allow|deny> <cidr|port|service> <value>", e.g.
+//
+//	deny cidr 10.0.0.0/8
+//	allow cidr 10.1.0.0/16
+//	deny port 0-1023
+//	deny service TELNET
+//
+// A line of the form "default <allow|deny> [cidr|port|service]" sets what is decided when no rule matches, for one kind
+// or for every kind, and the default of one kind wins over the default of every kind. Without a default, what no rule
+// matches is allowed, so a list of the only services which are allowed is written as
+//
+//	default deny service
+//	allow service HTTP
+//	allow service SSH
+//
+// A CIDR may be a single address and a port range may be a single port. Services are matched regardless of case.
+// Blank lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Rules, error) {
+	rules := &Rules{services: map[string]serviceRule{}, defaults: map[string]defaultRule{}}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := rules.add(n, strings.Fields(line)); err != nil {
+			return nil, fmt.Errorf("invalid filter rule on line %d %q: %w", n, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *Rules) add(line int, fields []string) error {
+	if strings.ToLower(fields[0]) == DirectiveDefault {
+		return r.addDefault(line, fields)
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("expected <allow|deny> <cidr|port|service> <value>")
+	}
+	action, kind, value := strings.ToLower(fields[0]), strings.ToLower(fields[1]), fields[2]
+	if action != ActionAllow && action != ActionDeny {
+		return fmt.Errorf("unknown action %q", fields[0])
+	}
+	allow := action == ActionAllow
+	switch kind {
+	case KindCIDR:
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return err
+		}
+		r.cidrs = append(r.cidrs, cidrRule{prefix: prefix, allow: allow})
+	case KindPort:
+		ports, err := parsePorts(value)
+		if err != nil {
+			return err
+		}
+		r.ports = append(r.ports, portRule{ports: ports, allow: allow})
+	case KindService:
+		service := strings.ToUpper(value)
+		if existing, found := r.services[service]; found && existing.allow != allow {
+			return fmt.Errorf("conflicts with the rule on line %d", existing.line)
+		}
+		r.services[service] = serviceRule{allow: allow, line: line}
+	default:
+		return fmt.Errorf("unknown kind %q", fields[1])
+	}
+	return nil
+}
+
+func (r *Rules) addDefault(line int, fields []string) error {
+	if len(fields) != 2 && len(fields) != 3 {
+		return fmt.Errorf("expected default <allow|deny> [cidr|port|service]")
+	}
+	action, kind := strings.ToLower(fields[1]), ""
+	if action != ActionAllow && action != ActionDeny {
+		return fmt.Errorf("unknown action %q", fields[1])
+	}
+	if len(fields) == 3 {
+		kind = strings.ToLower(fields[2])
+		if kind != KindCIDR && kind != KindPort && kind != KindService {
+			return fmt.Errorf("unknown kind %q", fields[2])
+		}
+	}
+	if existing, found := r.defaults[kind]; found {
+		return fmt.Errorf("conflicts with the default on line %d", existing.line)
+	}
+	r.defaults[kind] = defaultRule{allow: action == ActionAllow, line: line}
+	return nil
+}
+
+// parsePrefix parses a CIDR or a single address in the canonical form of the stored addresses.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		ip = models.CanonicalAddr(ip)
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if ip := prefix.Addr(); ip.Is4In6() && prefix.Bits() >= 96 {
+		// An IPv4-mapped prefix matches the IPv4 addresses it maps, which are stored unmapped
+		prefix = netip.PrefixFrom(ip.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+func parsePorts(s string) (models.PortRange, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	minPort, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return models.PortRange{}, fmt.Errorf("invalid port %q", lo)
+	}
+	maxPort, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil {
+		return models.PortRange{}, fmt.Errorf("invalid port %q", hi)
+	}
+	if minPort > maxPort {
+		return models.PortRange{}, fmt.Errorf("minimum port is greater than maximum")
+	}
+	return models.PortRange{Min: uint32(minPort), Max: uint32(maxPort)}, nil
+}
+
+// Len returns the number of rules.
+func (r *Rules) Len() int {
+	return len(r.cidrs) + len(r.ports) + len(r.services)
+}
+
+// defaultFor returns what is decided for the kind when no rule matches.
+func (r *Rules) defaultFor(kind string) bool {
+	if rule, found := r.defaults[kind]; found {
+		return rule.allow
+	}
+	if rule, found := r.defaults[""]; found {
+		return rule.allow
+	}
+	return true
+}
+
+// Allow reports whether a scan of the service at the ip and port is stored and, if it is not, which kind of rule
+// filtered it out.
+func (r *Rules) Allow(ip netip.Addr, port uint32, service string) (bool, string) {
+	if !r.allowIP(models.CanonicalAddr(ip)) {
+		return false, KindCIDR
+	}
+	if !r.allowPort(port) {
+		return false, KindPort
+	}
+	if !r.allowService(strings.ToUpper(service)) {
+		return false, KindService
+	}
+	return true, ""
+}
+
+func (r *Rules) allowIP(ip netip.Addr) bool {
+	bits, allow := -1, r.defaultFor(KindCIDR)
+	for _, rule := range r.cidrs {
+		if !rule.prefix.Contains(ip) {
+			continue
+		}
+		if b := rule.prefix.Bits(); b > bits || (b == bits && !rule.allow) {
+			bits, allow = b, rule.allow
+		}
+	}
+	return allow
+}
+
+func (r *Rules) allowPort(port uint32) bool {
+	width, allow := uint32(1<<16), r.defaultFor(KindPort)
+	for _, rule := range r.ports {
+		if !rule.ports.Contains(port) {
+			continue
+		}
+		if w := rule.ports.Max - rule.ports.Min; w < width || (w == width && !rule.allow) {
+			width, allow = w, rule.allow
+		}
+	}
+	return allow
+}
+
+func (r *Rules) allowService(service string) bool {
+	if rule, found := r.services[service]; found {
+		return rule.allow
+	}
+	return r.defaultFor(KindService)
+}
@@ -0,0 +1,93 @@
+// Package fingerprint identifies the software of scanned services from their banners, with a file of regex signatures
+// mapping banners to a vendor, product, version and CPE (see Parse). The file is reloaded when it changes or on SIGHUP,
+// without restarting the processor.
+package fingerprint
+
+import (
+	"context"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/censys/scan-takehome/internal/reload"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	DefaultReloadInterval = 30 * time.Second
+)
+
+// Config holds the fingerprint configuration.
+type Config struct {
+	// File is the path of the rules file; scans are not fingerprinted when it is empty.
+	File string `env:"FINGERPRINT_RULES"`
+	// ReloadInterval is how often the file is checked for changes; zero uses DefaultReloadInterval and a negative
+	// interval only reloads the file on SIGHUP.
+	ReloadInterval time.Duration `env:"FINGERPRINT_RELOAD_INTERVAL"`
+}
+
+// ConfigFromEnv returns a fingerprint configuration which has been pre-loaded from the environment.
+func ConfigFromEnv() *Config {
+	cfg := &Config{}
+	env.Parse(cfg)
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	return validate.Struct(c)
+}
+
+// ReloadEvery returns the configured ReloadInterval, the default if it is not set, or zero if polling is disabled.
+func (c *Config) ReloadEvery() time.Duration {
+	switch {
+	case c.ReloadInterval == 0:
+		return DefaultReloadInterval
+	case c.ReloadInterval < 0:
+		return 0
+	}
+	return c.ReloadInterval
+}
+
+// Matcher holds the rules loaded from a file.
+type Matcher struct {
+	file *reload.File[*Rules]
+}
+
+// New loads the rules configured in cfg, or returns nil if no file is configured.
+func New(cfg *Config) (*Matcher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.File == "" {
+		return nil, nil
+	}
+	return Load(cfg.File, cfg.ReloadEvery())
+}
+
+// Load loads the rules of the file at path, which Run reloads every interval once it changes.
+func Load(path string, interval time.Duration) (*Matcher, error) {
+	file, err := reload.Load("fingerprint rules", path, interval, Parse)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{file: file}, nil
+}
+
+// Match returns the first rule which matches the banner of a service and the fingerprint of the banner, or a nil rule
+// if none does.
+func (m *Matcher) Match(service, banner string) (*Rule, models.Fingerprint) {
+	return m.file.Rules().Match(service, banner)
+}
+
+// Reload reads the file again and replaces the rules if it changed, reporting whether it did.
+// The rules are kept if the file cannot be read or parsed.
+func (m *Matcher) Reload() (bool, error) {
+	return m.file.Reload()
+}
+
+// Run reloads the rules every interval, when the file changed, and on SIGHUP until the context is cancelled.
+func (m *Matcher) Run(ctx context.Context) {
+	m.file.Run(ctx)
+}
@@ -0,0 +1,177 @@
+package fingerprint_test
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/fingerprint"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+var _ = Describe("Rules", func() {
+	parse := func(lines ...string) *fingerprint.Rules {
+		rules, err := fingerprint.Parse(strings.NewReader(strings.Join(lines, "\n")))
+		Expect(err).ToNot(HaveOccurred())
+		return rules
+	}
+	match := func(rules *fingerprint.Rules, service, banner string) models.Fingerprint {
+		_, fp := rules.Match(service, banner)
+		return fp
+	}
+
+	It("should fingerprint a banner with the groups of the pattern", func() {
+		rules := parse("# OpenSSH", `SSH m|^SSH-[\d.]+-OpenSSH_([\w.]+)| vendor=openbsd product=openssh version=$1`)
+		Expect(rules.Len()).To(Equal(1))
+		Expect(match(rules, "ssh", "SSH-2.0-OpenSSH_9.6 Ubuntu\r\n")).To(Equal(models.Fingerprint{
+			Vendor: "openbsd", Product: "openssh", Version: "9.6", CPE: "cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*",
+		}))
+		Expect(match(rules, "HTTP", "SSH-2.0-OpenSSH_9.6")).To(BeZero())
+		Expect(match(rules, "SSH", "SSH-2.0-dropbear_2022.83")).To(BeZero())
+	})
+	It("should apply the flags of the pattern and leave a version which was not captured empty", func() {
+		rules := parse(`HTTP m#^server: nginx(?:/([\d.]+))?#im vendor=f5 product=nginx version=$1`)
+		Expect(match(rules, "HTTP", "HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\n")).To(Equal(models.Fingerprint{
+			Vendor: "f5", Product: "nginx", Version: "1.18.0", CPE: "cpe:2.3:a:f5:nginx:1.18.0:*:*:*:*:*:*:*",
+		}))
+		Expect(match(rules, "HTTP", "HTTP/1.1 200 OK\r\nServer: nginx\r\n")).To(Equal(models.Fingerprint{
+			Vendor: "f5", Product: "nginx", CPE: "cpe:2.3:a:f5:nginx:*:*:*:*:*:*:*:*",
+		}))
+	})
+	It("should use the first matching rule of the service or of any service", func() {
+		rules := parse(
+			`DNS m|^(\d+\.\d+\.\d+)| vendor=isc product=bind version=$1`,
+			`* m|dnsmasq-(?P<version>[\d.]+)|i vendor=thekelleys product=dnsmasq version=${version} cpe=cpe:/a:thekelleys:dnsmasq:${version}`,
+			`* m|dnsmasq| product=never`,
+		)
+		Expect(match(rules, "DNS", "9.18.1-Ubuntu").Product).To(Equal("bind"))
+		rule, fp := rules.Match("DNS", "DNSMASQ-2.80")
+		Expect(rule.Line).To(Equal(2))
+		Expect(fp).To(Equal(models.Fingerprint{
+			Vendor: "thekelleys", Product: "dnsmasq", Version: "2.80", CPE: "cpe:/a:thekelleys:dnsmasq:2.80",
+		}))
+	})
+	It("should read quoted values", func() {
+		rules := parse(`HTTP m|Microsoft-IIS/([\d.]+)| vendor=microsoft product="internet information services" version=$1`)
+		Expect(match(rules, "HTTP", "Server: Microsoft-IIS/10.0")).To(Equal(models.Fingerprint{
+			Vendor: "microsoft", Product: "internet information services", Version: "10.0",
+			CPE: "cpe:2.3:a:microsoft:internet_information_services:10.0:*:*:*:*:*:*:*",
+		}))
+	})
+	DescribeTable("should derive the CPE of a fingerprint",
+		func(vendor, product, version, expected string) {
+			Expect(fingerprint.CPE(vendor, product, version)).To(Equal(expected))
+		},
+		Entry("complete", "OpenBSD", "OpenSSH", "9.6p1", "cpe:2.3:a:openbsd:openssh:9.6p1:*:*:*:*:*:*:*"),
+		Entry("without vendor and version", "", "nginx", "", "cpe:2.3:a:*:nginx:*:*:*:*:*:*:*:*"),
+		Entry("special characters", "acme", "web:server", "1.0+build", `cpe:2.3:a:acme:web\:server:1.0\+build:*:*:*:*:*:*:*`),
+	)
+	DescribeTable("should reject invalid rules",
+		func(line, expected string) {
+			_, err := fingerprint.Parse(strings.NewReader("SSH m|^SSH-| product=ssh\n" + line))
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+			Expect(err).To(MatchError(ContainSubstring("line 2")))
+		},
+		Entry("missing pattern", "SSH product=ssh", "expected <service|*> m<d><regex><d>[flags] <key>=<value>..."),
+		Entry("unterminated pattern", "SSH m|^SSH- product=ssh", "unterminated regex, expected a closing |"),
+		Entry("unknown flag", "SSH m|^SSH-|x product=ssh", `unknown regex flags "x"`),
+		Entry("invalid pattern", "SSH m|^SSH-(| product=ssh", "missing closing )"),
+		Entry("missing product", "SSH m|^SSH-| vendor=openbsd", "missing product"),
+		Entry("unknown key", "SSH m|^SSH-| product=ssh os=linux", `unknown key "os"`),
+		Entry("duplicate key", "SSH m|^SSH-| product=ssh product=openssh", `duplicate key "product"`),
+		Entry("invalid pair", "SSH m|^SSH-| product", `expected <key>=<value> at "product"`),
+		Entry("unterminated quote", `SSH m|^SSH-| product="open ssh`, "invalid quoted value of product"),
+	)
+})
+
+var _ = Describe("Matcher", func() {
+	var path string
+	write := func(lines ...string) {
+		Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)).To(Succeed())
+	}
+	product := func(m *fingerprint.Matcher) func() string {
+		return func() string {
+			_, fp := m.Match("SSH", "SSH-2.0-OpenSSH_9.6")
+			return fp.Product
+		}
+	}
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "fingerprint.rules")
+	})
+
+	It("should not fingerprint without a file", func() {
+		m, err := fingerprint.New(&fingerprint.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m).To(BeNil())
+	})
+	It("should fail to load a missing or invalid file", func() {
+		_, err := fingerprint.New(&fingerprint.Config{File: path})
+		Expect(err).To(HaveOccurred())
+		write("SSH m|^SSH-|")
+		_, err = fingerprint.New(&fingerprint.Config{File: path})
+		Expect(err).To(HaveOccurred())
+	})
+	It("should reload the rules once the file changes and keep them if it becomes invalid", func() {
+		write("SSH m|OpenSSH| product=openssh")
+		m, err := fingerprint.New(&fingerprint.Config{File: path})
+		Expect(err).ToNot(HaveOccurred())
+		rule, fp := m.Match("SSH", "SSH-2.0-OpenSSH_9.6")
+		Expect(rule.Line).To(Equal(1))
+		Expect(fp.Product).To(Equal("openssh"))
+		Expect(m.Reload()).To(BeFalse())
+
+		write("SSH m|dropbear| product=dropbear")
+		Expect(m.Reload()).To(BeTrue())
+		rule, _ = m.Match("SSH", "SSH-2.0-OpenSSH_9.6")
+		Expect(rule).To(BeNil())
+
+		write("SSH m|OpenSSH|")
+		_, err = m.Reload()
+		Expect(err).To(HaveOccurred())
+		_, fp = m.Match("SSH", "SSH-2.0-dropbear_2022.83")
+		Expect(fp.Product).To(Equal("dropbear"))
+	})
+	It("should reload the rules every interval while running", func() {
+		write("SSH m|OpenSSH| product=openssh")
+		m, err := fingerprint.Load(path, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.Run(ctx)
+		}()
+		write("SSH m|OpenSSH| product=ssh")
+		Eventually(product(m)).Should(Equal("ssh"))
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+	It("should reload the rules on SIGHUP", func() {
+		write("SSH m|OpenSSH| product=openssh")
+		m, err := fingerprint.Load(path, 0)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// The test catches SIGHUP as well, so that a signal sent before Run starts handling it does not kill the process
+		caught := make(chan os.Signal, 1)
+		signal.Notify(caught, syscall.SIGHUP)
+		defer signal.Stop(caught)
+		go m.Run(ctx)
+		write("SSH m|OpenSSH| product=ssh")
+		Eventually(func() string {
+			Expect(syscall.Kill(syscall.Getpid(), syscall.SIGHUP)).To(Succeed())
+			return product(m)()
+		}).Should(Equal("ssh"))
+	})
+	It("should use the default reload interval and disable polling with a negative interval", func() {
+		Expect((&fingerprint.Config{}).ReloadEvery()).To(Equal(fingerprint.DefaultReloadInterval))
+		Expect((&fingerprint.Config{ReloadInterval: -1}).ReloadEvery()).To(BeZero())
+	})
+})
@@ -0,0 +1,207 @@
+package fingerprint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	// AnyService is the service of rules which match the banners of every service.
+	AnyService = "*"
+
+	KeyVendor  = "vendor"
+	KeyProduct = "product"
+	KeyVersion = "version"
+	KeyCPE     = "cpe"
+)
+
+// Rule is a signature mapping the banners it matches to a fingerprint. The templates may refer to the groups of the
+// pattern as $1 or ${name}, as in regexp.Regexp.Expand.
+type Rule struct {
+	// Line is the line of the rule in its file.
+	Line    int
+	Service string
+	Pattern *regexp.Regexp
+
+	Vendor, Product, Version, CPE string
+}
+
+// Fingerprint returns the fingerprint of the banner matched by the submatch indices of the pattern.
+// Without a CPE template, the CPE is derived from the vendor, product and version (see CPE).
+func (r *Rule) Fingerprint(banner string, match []int) models.Fingerprint {
+	expand := func(template string) string {
+		return strings.TrimSpace(string(r.Pattern.ExpandString(nil, template, banner, match)))
+	}
+	fp := models.Fingerprint{Vendor: expand(r.Vendor), Product: expand(r.Product), Version: expand(r.Version)}
+	if r.CPE != "" {
+		fp.CPE = expand(r.CPE)
+	} else {
+		fp.CPE = CPE(fp.Vendor, fp.Product, fp.Version)
+	}
+	return fp
+}
+
+// Rules are signatures which are tried against a banner in the order of their file.
+type Rules struct {
+	rules []*Rule
+}
+
+// Parse reads rules with one rule per line of the form "<service|*> m<d><regex><d>[flags] <key>=<value>...", e.g.
+//
+//	SSH m|^SSH-[\d.]+-OpenSSH_([\w.]+)| vendor=openbsd product=openssh version=$1
+//	HTTP m|^server: nginx(?:/([\d.]+))?|im vendor=f5 product=nginx version=$1
+//	* m|dnsmasq-([\d.]+)| vendor=thekelleys product=dnsmasq version=$1 cpe=cpe:2.3:a:thekelleys:dnsmasq:$1:*:*:*:*:*:*:*
+//
+// The regex is delimited by any character which it does not contain, and its flags are i (case-insensitive), m
+// (multi-line, ^ and $ match at line boundaries) and s (. matches \n), as in regexp/syntax. The keys are vendor,
+// product, version and cpe, of which product is required; a value is quoted as a Go string if it contains spaces.
+// Services are matched regardless of case. Blank lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fingerprint rule on line %d: %w", n, err)
+		}
+		rule.Line = n
+		rules.rules = append(rules.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseRule(line string) (*Rule, error) {
+	service, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		service, rest = line[:i], strings.TrimSpace(line[i:])
+	}
+	if !strings.HasPrefix(rest, "m") || len(rest) < 3 {
+		return nil, fmt.Errorf("expected <service|*> m<d><regex><d>[flags] <key>=<value>...")
+	}
+	delim := rest[1:2]
+	expr, rest, found := strings.Cut(rest[2:], delim)
+	if !found {
+		return nil, fmt.Errorf("unterminated regex, expected a closing %s", delim)
+	}
+	flags, rest, _ := strings.Cut(rest, " ")
+	if strings.Trim(flags, "ims") != "" {
+		return nil, fmt.Errorf("unknown regex flags %q", flags)
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{Service: strings.ToUpper(service), Pattern: pattern}
+	values, err := parseValues(rest)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		switch key {
+		case KeyVendor:
+			rule.Vendor = value
+		case KeyProduct:
+			rule.Product = value
+		case KeyVersion:
+			rule.Version = value
+		case KeyCPE:
+			rule.CPE = value
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	if rule.Product == "" {
+		return nil, fmt.Errorf("missing product")
+	}
+	return rule, nil
+}
+
+// parseValues parses the <key>=<value> pairs of a rule, whose values may be quoted.
+func parseValues(s string) (map[string]string, error) {
+	values := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		key, rest, found := strings.Cut(s, "=")
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("expected <key>=<value> at %q", s)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value of %s: %w", key, err)
+			}
+			// QuotedPrefix has already validated the string
+			value, _ = strconv.Unquote(quoted)
+			s = rest[len(quoted):]
+		} else {
+			value, s, _ = strings.Cut(rest, " ")
+		}
+		if _, duplicate := values[key]; duplicate {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Len returns the number of rules.
+func (r *Rules) Len() int {
+	return len(r.rules)
+}
+
+// Match returns the first rule which matches the banner of a service and the fingerprint of the banner, or a nil rule
+// if none does.
+func (r *Rules) Match(service, banner string) (*Rule, models.Fingerprint) {
+	service = strings.ToUpper(service)
+	for _, rule := range r.rules {
+		if rule.Service != AnyService && rule.Service != service {
+			continue
+		}
+		if match := rule.Pattern.FindStringSubmatchIndex(banner); match != nil {
+			return rule, rule.Fingerprint(banner, match)
+		}
+	}
+	return nil, models.Fingerprint{}
+}
+
+// CPE returns the CPE 2.3 formatted string of an application by the vendor, product and version; empty components
+// are "*" (any). Components are lower cased, with spaces replaced by underscores and other special characters quoted.
+func CPE(vendor, product, version string) string {
+	return "cpe:2.3:a:" + cpeComponent(vendor) + ":" + cpeComponent(product) + ":" + cpeComponent(version) + ":*:*:*:*:*:*:*"
+}
+
+func cpeComponent(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "*"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == ' ':
+			b.WriteByte('_')
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
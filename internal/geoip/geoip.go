@@ -0,0 +1,264 @@
+// Package geoip looks up the autonomous system and country of scanned addresses in local MaxMind-format (MMDB)
+// databases, such as GeoLite2-ASN and GeoLite2-Country, so that scans are stored with the ASN, organization and
+// country analysts join them with. The databases are reloaded when they change on disk, without restarting the
+// processor.
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/go-playground/validator/v10"
+	"github.com/oschwald/maxminddb-golang/v2"
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	DefaultReloadInterval = time.Minute
+)
+
+// Config holds the geoip configuration.
+type Config struct {
+	// ASNDatabase is the path of an MMDB database of autonomous systems (e.g. GeoLite2-ASN.mmdb).
+	ASNDatabase string `env:"GEOIP_ASN_DB"`
+	// CountryDatabase is the path of an MMDB database of countries (e.g. GeoLite2-Country.mmdb or GeoLite2-City.mmdb).
+	CountryDatabase string `env:"GEOIP_COUNTRY_DB"`
+	// ReloadInterval is how often the databases are checked for changes; zero uses DefaultReloadInterval and a
+	// negative interval never reloads them.
+	ReloadInterval time.Duration `env:"GEOIP_RELOAD_INTERVAL"`
+}
+
+// ConfigFromEnv returns a geoip configuration which has been pre-loaded from the environment.
+func ConfigFromEnv() *Config {
+	cfg := &Config{}
+	env.Parse(cfg)
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	return validate.Struct(c)
+}
+
+// ReloadEvery returns the configured ReloadInterval, the default if it is not set, or zero if reloading is disabled.
+func (c *Config) ReloadEvery() time.Duration {
+	switch {
+	case c.ReloadInterval == 0:
+		return DefaultReloadInterval
+	case c.ReloadInterval < 0:
+		return 0
+	}
+	return c.ReloadInterval
+}
+
+// Result is what is known of an address; fields which are not known are empty.
+type Result struct {
+	ASN     uint32
+	ASOrg   string
+	Country string
+}
+
+// asnRecord is the record of a GeoLite2-ASN database.
+type asnRecord struct {
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// countryRecord is the part of a GeoLite2-Country or GeoLite2-City record which is looked up.
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is used for addresses without a country, such as those of anycast networks.
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// database is an MMDB file read into memory and the state of the file it was read from. The file is read rather than
+// memory mapped, so that overwriting it in place cannot fault the lookups of the database which is open.
+type database struct {
+	path    string
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// open opens the file at path if it differs from the file db was opened from, returning nil if it does not.
+func (db *database) open(path string) (*database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if db != nil && info.ModTime().Equal(db.modTime) && info.Size() == db.size {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.OpenBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &database{path: path, reader: reader, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// lookup decodes the record of ip into record, reporting whether it was found.
+func (db *database) lookup(ip netip.Addr, record any) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	result := db.reader.Lookup(ip)
+	if !result.Found() {
+		return false, result.Err()
+	}
+	return true, result.Decode(record)
+}
+
+func (db *database) close() {
+	if db != nil {
+		db.reader.Close()
+	}
+}
+
+// Enricher looks up addresses in the ASN and country databases, either of which may be omitted.
+type Enricher struct {
+	asnPath, countryPath string
+	interval             time.Duration
+
+	mu      sync.RWMutex
+	asn     *database
+	country *database
+}
+
+// New opens the databases configured in cfg, or returns nil if none is configured.
+func New(cfg *Config) (*Enricher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.ASNDatabase == "" && cfg.CountryDatabase == "" {
+		return nil, nil
+	}
+	return Open(cfg.ASNDatabase, cfg.CountryDatabase, cfg.ReloadEvery())
+}
+
+// Open opens the databases at asnPath and countryPath, either of which may be empty, which Run reloads every interval
+// once they change.
+func Open(asnPath, countryPath string, interval time.Duration) (*Enricher, error) {
+	e := &Enricher{asnPath: asnPath, countryPath: countryPath, interval: interval}
+	if _, err := e.Reload(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Lookup returns what is known of ip. A result is returned even if one of the databases fails to decode its record.
+func (e *Enricher) Lookup(ip netip.Addr) (Result, error) {
+	ip = models.CanonicalAddr(ip)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var (
+		result  Result
+		asn     asnRecord
+		country countryRecord
+	)
+	found, asnErr := e.asn.lookup(ip, &asn)
+	if found && asnErr == nil {
+		result.ASN, result.ASOrg = asn.Number, asn.Organization
+	}
+	found, countryErr := e.country.lookup(ip, &country)
+	if found && countryErr == nil {
+		result.Country = country.Country.ISOCode
+		if result.Country == "" {
+			result.Country = country.RegisteredCountry.ISOCode
+		}
+	}
+	return result, errors.Join(asnErr, countryErr)
+}
+
+// Enrich sets the ASN, organization and country of the entry from its ip.
+func (e *Enricher) Enrich(entry *models.ScanEntry) error {
+	result, err := e.Lookup(entry.IP)
+	entry.ASN, entry.ASOrg, entry.Country = result.ASN, result.ASOrg, result.Country
+	return err
+}
+
+// Reload reopens the databases whose file changed, reporting whether any did.
+// A database is kept if its file cannot be opened.
+func (e *Enricher) Reload() (bool, error) {
+	e.mu.RLock()
+	asn, country := e.asn, e.country
+	e.mu.RUnlock()
+	var newASN, newCountry *database
+	var asnErr, countryErr error
+	if e.asnPath != "" {
+		newASN, asnErr = asn.open(e.asnPath)
+	}
+	if e.countryPath != "" {
+		newCountry, countryErr = country.open(e.countryPath)
+	}
+	if newASN == nil && newCountry == nil {
+		return false, errors.Join(asnErr, countryErr)
+	}
+	e.mu.Lock()
+	if newASN != nil {
+		e.asn = newASN
+	}
+	if newCountry != nil {
+		e.country = newCountry
+	}
+	e.mu.Unlock()
+	// Lookups hold the read lock, so no lookup uses the replaced databases once the lock was taken
+	for _, db := range []*database{newASN, newCountry} {
+		if db == nil {
+			continue
+		}
+		zap.S().Infow("loaded geoip database", "file", db.path, "type", db.reader.Metadata.DatabaseType,
+			"build_time", db.reader.Metadata.BuildTime())
+	}
+	if newASN != nil {
+		asn.close()
+	}
+	if newCountry != nil {
+		country.close()
+	}
+	return true, errors.Join(asnErr, countryErr)
+}
+
+// Run reloads the databases every interval, when their file changed, until the context is cancelled.
+func (e *Enricher) Run(ctx context.Context) {
+	if e.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := e.Reload(); err != nil {
+			zap.S().Errorw("failed to reload geoip database, keeping the current database", "error", err)
+		}
+	}
+}
+
+// Close closes the databases; the enricher must not be used afterwards.
+func (e *Enricher) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.asn.close()
+	e.country.close()
+	e.asn, e.country = nil, nil
+}
@@ -0,0 +1,154 @@
+package geoip_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/geoip"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// writeDB writes an MMDB database of the records by network to path, replacing the file as the MaxMind updater does.
+func writeDB(path, databaseType string, records map[string]mmdbtype.Map) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: databaseType, RecordSize: 24, IncludeReservedNetworks: true})
+	Expect(err).ToNot(HaveOccurred())
+	for network, record := range records {
+		_, ipNet, err := net.ParseCIDR(network)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tree.Insert(ipNet, record)).To(Succeed())
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".mmdb-*")
+	Expect(err).ToNot(HaveOccurred())
+	_, err = tree.WriteTo(tmp)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(tmp.Close()).To(Succeed())
+	Expect(os.Rename(tmp.Name(), path)).To(Succeed())
+}
+
+// writeInvalid overwrites the file at path in place with one which is not a database.
+func writeInvalid(path string) {
+	Expect(os.WriteFile(path, []byte("not a database"), 0o644)).To(Succeed())
+}
+
+func asn(number uint32, org string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(number),
+		"autonomous_system_organization": mmdbtype.String(org),
+	}
+}
+
+func country(iso, registered string) mmdbtype.Map {
+	record := mmdbtype.Map{"registered_country": mmdbtype.Map{"iso_code": mmdbtype.String(registered)}}
+	if iso != "" {
+		record["country"] = mmdbtype.Map{"iso_code": mmdbtype.String(iso)}
+	}
+	return record
+}
+
+var _ = Describe("Enricher", func() {
+	var asnPath, countryPath string
+	lookup := func(e *geoip.Enricher, ip string) geoip.Result {
+		result, err := e.Lookup(netip.MustParseAddr(ip))
+		Expect(err).ToNot(HaveOccurred())
+		return result
+	}
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		asnPath, countryPath = filepath.Join(dir, "asn.mmdb"), filepath.Join(dir, "country.mmdb")
+		writeDB(asnPath, "GeoLite2-ASN", map[string]mmdbtype.Map{
+			"10.0.0.0/8":    asn(64496, "Example Networks"),
+			"2001:db8::/32": asn(64497, "Example IPv6"),
+		})
+		writeDB(countryPath, "GeoLite2-Country", map[string]mmdbtype.Map{
+			"10.0.0.0/8":     country("NL", "NL"),
+			"192.168.0.0/16": country("", "US"),
+		})
+	})
+
+	It("should not enrich without databases", func() {
+		e, err := geoip.New(&geoip.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e).To(BeNil())
+	})
+	It("should fail to open a missing or invalid database", func() {
+		_, err := geoip.New(&geoip.Config{ASNDatabase: asnPath, CountryDatabase: countryPath + ".missing"})
+		Expect(err).To(HaveOccurred())
+		writeInvalid(countryPath)
+		_, err = geoip.New(&geoip.Config{CountryDatabase: countryPath})
+		Expect(err).To(HaveOccurred())
+	})
+	It("should look up the ASN, organization and country of an address", func() {
+		e, err := geoip.New(&geoip.Config{ASNDatabase: asnPath, CountryDatabase: countryPath})
+		Expect(err).ToNot(HaveOccurred())
+		defer e.Close()
+		Expect(lookup(e, "10.1.2.3")).To(Equal(geoip.Result{ASN: 64496, ASOrg: "Example Networks", Country: "NL"}))
+		Expect(lookup(e, "::ffff:10.1.2.3")).To(Equal(geoip.Result{ASN: 64496, ASOrg: "Example Networks", Country: "NL"}))
+		Expect(lookup(e, "2001:db8::1")).To(Equal(geoip.Result{ASN: 64497, ASOrg: "Example IPv6"}))
+		// Addresses without a country use their registered country
+		Expect(lookup(e, "192.168.0.1")).To(Equal(geoip.Result{Country: "US"}))
+		Expect(lookup(e, "172.16.0.1")).To(BeZero())
+	})
+	It("should enrich an entry from a single database", func() {
+		e, err := geoip.Open("", countryPath, 0)
+		Expect(err).ToNot(HaveOccurred())
+		defer e.Close()
+		entry := &models.ScanEntry{IP: netip.MustParseAddr("10.0.0.1"), ASN: 1, ASOrg: "stale"}
+		Expect(e.Enrich(entry)).To(Succeed())
+		Expect(entry.ASN).To(BeZero())
+		Expect(entry.ASOrg).To(BeEmpty())
+		Expect(entry.Country).To(Equal("NL"))
+	})
+	It("should reload a database once its file changes and keep it if the file becomes invalid", func() {
+		e, err := geoip.Open(asnPath, countryPath, 0)
+		Expect(err).ToNot(HaveOccurred())
+		defer e.Close()
+		Expect(e.Reload()).To(BeFalse())
+
+		writeDB(asnPath, "GeoLite2-ASN", map[string]mmdbtype.Map{"10.0.0.0/8": asn(64511, "Renumbered")})
+		// The file must look changed even if it was rewritten within the resolution of the modification time
+		Expect(os.Chtimes(asnPath, time.Time{}, time.Now().Add(time.Minute))).To(Succeed())
+		Expect(e.Reload()).To(BeTrue())
+		Expect(lookup(e, "10.0.0.1")).To(Equal(geoip.Result{ASN: 64511, ASOrg: "Renumbered", Country: "NL"}))
+
+		writeInvalid(asnPath)
+		_, err = e.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(lookup(e, "10.0.0.1").ASN).To(BeEquivalentTo(64511))
+	})
+	It("should keep looking up a database after its file is overwritten in place", func() {
+		e, err := geoip.Open(asnPath, "", 0)
+		Expect(err).ToNot(HaveOccurred())
+		defer e.Close()
+		Expect(os.Truncate(asnPath, 0)).To(Succeed())
+		Expect(lookup(e, "10.0.0.1")).To(Equal(geoip.Result{ASN: 64496, ASOrg: "Example Networks"}))
+	})
+	It("should reload the databases every interval while running", func() {
+		e, err := geoip.Open(asnPath, "", 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		defer e.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			e.Run(ctx)
+		}()
+		writeDB(asnPath, "GeoLite2-ASN", map[string]mmdbtype.Map{"10.0.0.0/8": asn(64511, "Renumbered")})
+		Expect(os.Chtimes(asnPath, time.Time{}, time.Now().Add(time.Minute))).To(Succeed())
+		Eventually(func() uint32 { return lookup(e, "10.0.0.1").ASN }).Should(BeEquivalentTo(64511))
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+	It("should use the default reload interval and disable reloading with a negative interval", func() {
+		Expect((&geoip.Config{}).ReloadEvery()).To(Equal(geoip.DefaultReloadInterval))
+		Expect((&geoip.Config{ReloadInterval: -1}).ReloadEvery()).To(BeZero())
+	})
+})
@@ -0,0 +1,131 @@
+// Package metrics holds the prometheus metrics shared by the processor and the database implementations,
+// and serves them over HTTP.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	Namespace = "scan"
+
+	ResultOK       = "ok"
+	ResultError    = "error"
+	ResultNotFound = "not_found"
+
+	// ServiceOther is the service label of the values which are not of a known service.
+	ServiceOther = "other"
+)
+
+var (
+	// DatabaseRequests counts database requests by operation, the backend which served them and the result.
+	DatabaseRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "requests_total",
+		Help:      "Database requests by operation, serving backend (role and endpoint) and result.",
+	}, []string{"operation", "role", "endpoint", "result"})
+	// DatabaseRequestDuration observes the latency of database requests by operation and the backend which served them.
+	DatabaseRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of database requests by operation and serving backend (role and endpoint).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "role", "endpoint"})
+	// DatabaseSinkFailures counts the writes which failed for a sink of a fanout database, by sink and policy.
+	DatabaseSinkFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "sink_failures_total",
+		Help:      "Writes which failed for a sink of a fanout database, by sink and policy.",
+	}, []string{"sink", "policy"})
+	// DatabaseEndpointUp is 1 while a database endpoint is considered healthy and 0 otherwise.
+	DatabaseEndpointUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "endpoint_up",
+		Help:      "Whether a database endpoint is considered healthy (1) or not (0).",
+	}, []string{"role", "endpoint"})
+	// MessagesFiltered counts the messages acked without being stored as a filter rule excluded their scan, by the kind
+	// of rule (cidr, port or service).
+	MessagesFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "processor",
+		Name:      "messages_filtered_total",
+		Help:      "Messages acked without being stored as a filter rule excluded their scan, by kind of rule.",
+	}, []string{"kind"})
+	// GeoIPLookups counts the geoip lookups of the processor by result (ok, not_found when the ip is in neither
+	// database, or error).
+	GeoIPLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "processor",
+		Name:      "geoip_lookups_total",
+		Help:      "GeoIP lookups of scanned ips by result.",
+	}, []string{"result"})
+	// BannerParses counts the responses the processor parsed by service (in upper case) and result (ok or error).
+	BannerParses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "processor",
+		Name:      "banner_parses_total",
+		Help:      "Responses of scans parsed into structured fields by service and result.",
+	}, []string{"service", "result"})
+	// Fingerprints counts the responses the processor fingerprinted by the service of the rule which matched (other when
+	// no rule or a rule of every service matched) and result (ok, or not_found when no rule matched).
+	Fingerprints = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "processor",
+		Name:      "fingerprints_total",
+		Help:      "Responses of scans fingerprinted by service and result.",
+	}, []string{"service", "result"})
+)
+
+// Config holds the metrics configuration.
+type Config struct {
+	// Addr is the address the metrics are served on (e.g. ":9090"); metrics are not served when it is empty.
+	Addr string `env:"METRICS_ADDR"`
+}
+
+// ConfigFromEnv returns a metrics configuration which has been pre-loaded from the environment.
+func ConfigFromEnv() *Config {
+	cfg := &Config{}
+	env.Parse(cfg)
+	return cfg
+}
+
+// ObserveDatabase records a database request which started at start and was served by the endpoint.
+func ObserveDatabase(operation, role, endpoint string, start time.Time, err error) {
+	result := ResultOK
+	if err != nil {
+		result = ResultError
+	}
+	DatabaseRequests.WithLabelValues(operation, role, endpoint, result).Inc()
+	DatabaseRequestDuration.WithLabelValues(operation, role, endpoint).Observe(time.Since(start).Seconds())
+}
+
+// Serve serves the registered metrics on /metrics at addr until ctx is done.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	zap.S().Infow("serving metrics", "addr", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
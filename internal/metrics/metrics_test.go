@@ -0,0 +1,61 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/metrics"
+)
+
+var _ = Describe("Metrics", func() {
+	It("should load the configuration from the environment", func() {
+		restoreMap := EnvMap{"METRICS_ADDR": StringPointer(":9090")}.SetupEnv()
+		defer restoreMap.SetupEnv()
+		Expect(metrics.ConfigFromEnv().Addr).To(Equal(":9090"))
+	})
+	It("should record database requests by serving backend and result", func() {
+		ok := metrics.DatabaseRequests.WithLabelValues("get", "replica", "replica1:5432", metrics.ResultOK)
+		failed := metrics.DatabaseRequests.WithLabelValues("get", "replica", "replica1:5432", metrics.ResultError)
+		okBefore, failedBefore := testutil.ToFloat64(ok), testutil.ToFloat64(failed)
+
+		metrics.ObserveDatabase("get", "replica", "replica1:5432", time.Now(), nil)
+		metrics.ObserveDatabase("get", "replica", "replica1:5432", time.Now(), errors.New("connection refused"))
+		Expect(testutil.ToFloat64(ok)).To(Equal(okBefore + 1))
+		Expect(testutil.ToFloat64(failed)).To(Equal(failedBefore + 1))
+	})
+	It("should serve the metrics until the context is done", func() {
+		// Reserve a free port for the server
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		metrics.ObserveDatabase("upsert", "primary", "primary:5432", time.Now(), nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- metrics.Serve(ctx, addr) }()
+
+		var body []byte
+		Eventually(func() error {
+			resp, err := http.Get("http://" + addr + "/metrics")
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, err = io.ReadAll(resp.Body)
+			return err
+		}).Should(Succeed())
+		Expect(string(body)).To(ContainSubstring(`scan_database_requests_total{endpoint="primary:5432",operation="upsert",result="ok",role="primary"}`))
+		cancel()
+		Eventually(served).Should(Receive(BeNil()))
+	})
+})
@@ -1,19 +1,229 @@
 package processor
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
 	"github.com/caarlos0/env"
 	"github.com/go-playground/validator/v10"
+
+	"github.com/censys/scan-takehome/internal/retry"
+	"github.com/censys/scan-takehome/pkg/models"
 )
 
 type Config struct {
 	ProjectID      string `env:"PUBSUB_PROJECT_ID" validate:"required"`
 	TopicID        string `env:"PUBSUB_TOPIC_ID" validate:"required"`
 	SubscriptionID string `env:"PUBSUB_SUBSCRIPTION_ID" validate:"required"`
+	// PortRules overrides the accepted port range per transport (e.g. "tcp=1-65535,udp=0-65535").
+	// When empty, models.DefaultPortRules is used.
+	PortRules string `env:"SCAN_PORT_RULES"`
+	// MaintenanceInterval is how often database maintenance (e.g. history partition management) is run.
+	// Zero uses DefaultMaintenanceInterval and a negative interval disables maintenance in the processor.
+	MaintenanceInterval time.Duration `env:"MAINTENANCE_INTERVAL"`
+	// CoalesceWindow enables the write-behind buffer, which keeps only the newest scan of each service received within
+	// the window and acks the messages of every scan it replaced once it is written; zero writes every scan as received.
+	CoalesceWindow time.Duration `env:"COALESCE_WINDOW" validate:"min=0"`
+	// CoalesceMaxPending is the most services buffered before the buffer is written early; zero uses
+	// DefaultCoalesceMaxPending. It also sizes the batches written to databases which support them.
+	CoalesceMaxPending int `env:"COALESCE_MAX_PENDING" validate:"min=0"`
+
+	// The flow control and concurrency of the Pub/Sub receiver; zero uses pubsub.DefaultReceiveSettings.
+	ReceiveMaxOutstandingMessages int           `env:"RECEIVE_MAX_OUTSTANDING_MESSAGES" validate:"min=0"`
+	ReceiveMaxOutstandingBytes    int           `env:"RECEIVE_MAX_OUTSTANDING_BYTES" validate:"min=0"`
+	ReceiveNumGoroutines          int           `env:"RECEIVE_NUM_GOROUTINES" validate:"min=0"`
+	ReceiveMaxExtension           time.Duration `env:"RECEIVE_MAX_EXTENSION" validate:"min=0"`
+
+	// ReceiveAdaptive reduces the messages handled at once while upserts are slower than ReceiveLatencyTarget or fail
+	// more often than ReceiveErrorRateTarget, down to ReceiveMinOutstandingMessages, and restores them once the
+	// database recovers. The database is assessed every ReceiveAdaptiveInterval; zero values use the defaults below.
+	ReceiveAdaptive               bool          `env:"RECEIVE_ADAPTIVE"`
+	ReceiveAdaptiveInterval       time.Duration `env:"RECEIVE_ADAPTIVE_INTERVAL" validate:"min=0"`
+	ReceiveLatencyTarget          time.Duration `env:"RECEIVE_LATENCY_TARGET" validate:"min=0"`
+	ReceiveErrorRateTarget        float64       `env:"RECEIVE_ERROR_RATE_TARGET" validate:"min=0,max=1"`
+	ReceiveMinOutstandingMessages int           `env:"RECEIVE_MIN_OUTSTANDING_MESSAGES" validate:"min=0"`
+
+	// RetryMaxAttempts is the most attempts of an upsert which fails with a transient error, including the first;
+	// one disables retries. The wait before each retry is jittered and doubles from RetryInitialBackoff up to
+	// RetryMaxBackoff. Zero values use the defaults below.
+	RetryMaxAttempts    int           `env:"RETRY_MAX_ATTEMPTS" validate:"min=0"`
+	RetryInitialBackoff time.Duration `env:"RETRY_INITIAL_BACKOFF" validate:"min=0"`
+	RetryMaxBackoff     time.Duration `env:"RETRY_MAX_BACKOFF" validate:"min=0"`
+
+	// BreakerFailureThreshold is the number of consecutive transient upsert failures which open the circuit breaker,
+	// pausing message intake for BreakerCooldown before a single probe is let through. Zero values use the defaults
+	// below and a negative threshold disables the breaker.
+	BreakerFailureThreshold int           `env:"BREAKER_FAILURE_THRESHOLD"`
+	BreakerCooldown         time.Duration `env:"BREAKER_COOLDOWN" validate:"min=0"`
+
+	// NackDelays is the comma separated schedule of delays (e.g. "1s,5s,30s") before a message whose upsert failed with
+	// a transient error is redelivered, by failed delivery; deliveries past the end of the schedule use its last delay
+	// and a zero delay nacks immediately. It is ignored when the subscription has a retry policy, which delays the
+	// redelivery of nacked messages itself. When empty, DefaultNackDelays is used.
+	NackDelays string `env:"NACK_DELAYS"`
 }
 
+const (
+	DefaultMaintenanceInterval = time.Hour
+	DefaultCoalesceMaxPending  = 10000
+
+	DefaultReceiveAdaptiveInterval       = 30 * time.Second
+	DefaultReceiveLatencyTarget          = 500 * time.Millisecond
+	DefaultReceiveErrorRateTarget        = 0.05
+	DefaultReceiveMinOutstandingMessages = 10
+
+	DefaultRetryMaxAttempts        = 3
+	DefaultRetryInitialBackoff     = 100 * time.Millisecond
+	DefaultRetryMaxBackoff         = 5 * time.Second
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerCooldown         = 10 * time.Second
+	DefaultNackDelays              = "1s,5s,15s,30s,1m"
+)
+
 func (c *Config) Validate() error {
 	validate := validator.New(validator.WithRequiredStructEnabled())
-	return validate.Struct(c)
+	err := validate.Struct(c)
+	if _, rulesErr := c.ScanPortRules(); rulesErr != nil {
+		err = errors.Join(err, rulesErr)
+	}
+	if c.ReceiveAdaptive && c.MinOutstanding() > c.ReceiveSettings().MaxOutstandingMessages {
+		err = errors.Join(err, fmt.Errorf("receive min outstanding messages (%d) must not exceed receive max outstanding messages (%d)",
+			c.MinOutstanding(), c.ReceiveSettings().MaxOutstandingMessages))
+	}
+	if policy := c.RetryPolicy(); policy.InitialBackoff > policy.MaxBackoff {
+		err = errors.Join(err, fmt.Errorf("retry initial backoff (%s) must not exceed retry max backoff (%s)", policy.InitialBackoff, policy.MaxBackoff))
+	}
+	delays, delaysErr := c.NackSchedule()
+	if delaysErr != nil {
+		err = errors.Join(err, delaysErr)
+	}
+	// A message held for longer than the max extension is redelivered before it is nacked
+	if maxExtension := c.ReceiveSettings().MaxExtension; maxExtension > 0 {
+		for _, delay := range delays {
+			if delay > maxExtension {
+				err = errors.Join(err, fmt.Errorf("nack delay (%s) must not exceed receive max extension (%s)", delay, maxExtension))
+				break
+			}
+		}
+	}
+	return err
+}
+
+// ScanPortRules parses the configured PortRules.
+func (c *Config) ScanPortRules() (models.PortRules, error) {
+	return models.ParsePortRules(c.PortRules)
+}
+
+// MaintenanceEvery returns the configured MaintenanceInterval or the default if it is not set.
+func (c *Config) MaintenanceEvery() time.Duration {
+	if c.MaintenanceInterval == 0 {
+		return DefaultMaintenanceInterval
+	}
+	return c.MaintenanceInterval
+}
+
+// CoalesceLimit returns the configured CoalesceMaxPending or the default if it is not set.
+func (c *Config) CoalesceLimit() int {
+	if c.CoalesceMaxPending == 0 {
+		return DefaultCoalesceMaxPending
+	}
+	return c.CoalesceMaxPending
+}
+
+// ReceiveSettings returns the pubsub.DefaultReceiveSettings with the configured settings applied.
+func (c *Config) ReceiveSettings() pubsub.ReceiveSettings {
+	settings := pubsub.DefaultReceiveSettings
+	if c.ReceiveMaxOutstandingMessages > 0 {
+		settings.MaxOutstandingMessages = c.ReceiveMaxOutstandingMessages
+	}
+	if c.ReceiveMaxOutstandingBytes > 0 {
+		settings.MaxOutstandingBytes = c.ReceiveMaxOutstandingBytes
+	}
+	if c.ReceiveNumGoroutines > 0 {
+		settings.NumGoroutines = c.ReceiveNumGoroutines
+	}
+	if c.ReceiveMaxExtension > 0 {
+		settings.MaxExtension = c.ReceiveMaxExtension
+	}
+	return settings
+}
+
+// AdaptiveEvery returns the configured ReceiveAdaptiveInterval or the default if it is not set.
+func (c *Config) AdaptiveEvery() time.Duration {
+	if c.ReceiveAdaptiveInterval == 0 {
+		return DefaultReceiveAdaptiveInterval
+	}
+	return c.ReceiveAdaptiveInterval
+}
+
+// LatencyTarget returns the configured ReceiveLatencyTarget or the default if it is not set.
+func (c *Config) LatencyTarget() time.Duration {
+	if c.ReceiveLatencyTarget == 0 {
+		return DefaultReceiveLatencyTarget
+	}
+	return c.ReceiveLatencyTarget
+}
+
+// ErrorRateTarget returns the configured ReceiveErrorRateTarget or the default if it is not set.
+func (c *Config) ErrorRateTarget() float64 {
+	if c.ReceiveErrorRateTarget == 0 {
+		return DefaultReceiveErrorRateTarget
+	}
+	return c.ReceiveErrorRateTarget
+}
+
+// MinOutstanding returns the configured ReceiveMinOutstandingMessages or the default if it is not set.
+func (c *Config) MinOutstanding() int {
+	if c.ReceiveMinOutstandingMessages == 0 {
+		return DefaultReceiveMinOutstandingMessages
+	}
+	return c.ReceiveMinOutstandingMessages
+}
+
+// RetryPolicy returns the configured retry policy, using the defaults for the settings which are not set.
+func (c *Config) RetryPolicy() retry.Policy {
+	policy := retry.Policy{MaxAttempts: c.RetryMaxAttempts, InitialBackoff: c.RetryInitialBackoff, MaxBackoff: c.RetryMaxBackoff}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if policy.InitialBackoff == 0 {
+		policy.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	return policy
+}
+
+// BreakerThreshold returns the configured BreakerFailureThreshold, the default if it is not set, or zero if the
+// breaker is disabled.
+func (c *Config) BreakerThreshold() int {
+	switch {
+	case c.BreakerFailureThreshold == 0:
+		return DefaultBreakerFailureThreshold
+	case c.BreakerFailureThreshold < 0:
+		return 0
+	}
+	return c.BreakerFailureThreshold
+}
+
+// BreakerCooldownPeriod returns the configured BreakerCooldown or the default if it is not set.
+func (c *Config) BreakerCooldownPeriod() time.Duration {
+	if c.BreakerCooldown == 0 {
+		return DefaultBreakerCooldown
+	}
+	return c.BreakerCooldown
+}
+
+// NackSchedule parses the configured NackDelays, or the default if it is not set.
+func (c *Config) NackSchedule() ([]time.Duration, error) {
+	if strings.TrimSpace(c.NackDelays) == "" {
+		return ParseDelays(DefaultNackDelays)
+	}
+	return ParseDelays(c.NackDelays)
 }
 
 // ConfigFromEnv returns a configuration object which has been pre-loaded from the environment.
@@ -1,17 +1,39 @@
 package processor_test
 
 import (
+	"time"
+
+	"cloud.google.com/go/pubsub"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	. "github.com/censys/scan-takehome/_test"
 	"github.com/censys/scan-takehome/internal/processor"
+	"github.com/censys/scan-takehome/internal/retry"
 )
 
 const (
 	VAR_PROJECT_ID      = "PUBSUB_PROJECT_ID"
 	VAR_SUBSCRIPTION_ID = "PUBSUB_SUBSCRIPTION_ID"
 	VAR_TOPIC_ID        = "PUBSUB_TOPIC_ID"
+	VAR_PORT_RULES      = "SCAN_PORT_RULES"
+
+	VAR_COALESCE_WINDOW      = "COALESCE_WINDOW"
+	VAR_COALESCE_MAX_PENDING = "COALESCE_MAX_PENDING"
+
+	VAR_RECEIVE_MAX_OUTSTANDING_MESSAGES = "RECEIVE_MAX_OUTSTANDING_MESSAGES"
+	VAR_RECEIVE_ADAPTIVE                 = "RECEIVE_ADAPTIVE"
+	VAR_RECEIVE_ADAPTIVE_INTERVAL        = "RECEIVE_ADAPTIVE_INTERVAL"
+	VAR_RECEIVE_ERROR_RATE_TARGET        = "RECEIVE_ERROR_RATE_TARGET"
+	VAR_RECEIVE_MIN_OUTSTANDING_MESSAGES = "RECEIVE_MIN_OUTSTANDING_MESSAGES"
+
+	VAR_RETRY_MAX_ATTEMPTS    = "RETRY_MAX_ATTEMPTS"
+	VAR_RETRY_INITIAL_BACKOFF = "RETRY_INITIAL_BACKOFF"
+	VAR_RETRY_MAX_BACKOFF     = "RETRY_MAX_BACKOFF"
+
+	VAR_NACK_DELAYS           = "NACK_DELAYS"
+	VAR_RECEIVE_MAX_EXTENSION = "RECEIVE_MAX_EXTENSION"
 )
 
 var _ = Describe("Config", func() {
@@ -56,6 +78,68 @@ var _ = Describe("Config", func() {
 			&processor.Config{ProjectID: "foo", SubscriptionID: "bar"},
 			`.*Config\.TopicID.* for 'TopicID' failed on the 'required' tag`,
 		),
+		Entry(
+			"Custom port rules",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_PORT_RULES: StringPointer("tcp=0-65535")},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", PortRules: "tcp=0-65535"},
+		),
+		Entry(
+			"Invalid port rules",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_PORT_RULES: StringPointer("tcp=65535-1")},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", PortRules: "tcp=65535-1"},
+			`invalid port rule "tcp=65535-1": minimum is greater than maximum`,
+		),
+		Entry(
+			"Coalescing",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_COALESCE_WINDOW: StringPointer("2s"), VAR_COALESCE_MAX_PENDING: StringPointer("500")},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", CoalesceWindow: 2 * time.Second, CoalesceMaxPending: 500},
+		),
+		Entry(
+			"Negative coalescing window",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_COALESCE_WINDOW: StringPointer("-1s"), VAR_COALESCE_MAX_PENDING: nil},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", CoalesceWindow: -time.Second},
+			`.*Config\.CoalesceWindow.* for 'CoalesceWindow' failed on the 'min' tag`,
+		),
+		Entry(
+			"Adaptive receive settings",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_RECEIVE_ADAPTIVE: StringPointer("true"), VAR_RECEIVE_MAX_OUTSTANDING_MESSAGES: StringPointer("200"), VAR_RECEIVE_ERROR_RATE_TARGET: StringPointer("0.25"), VAR_RECEIVE_MIN_OUTSTANDING_MESSAGES: nil},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", ReceiveAdaptive: true, ReceiveMaxOutstandingMessages: 200, ReceiveErrorRateTarget: 0.25},
+		),
+		Entry(
+			"Error rate target above one",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_RECEIVE_ADAPTIVE: nil, VAR_RECEIVE_MAX_OUTSTANDING_MESSAGES: nil, VAR_RECEIVE_ERROR_RATE_TARGET: StringPointer("1.5"), VAR_RECEIVE_MIN_OUTSTANDING_MESSAGES: nil},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", ReceiveErrorRateTarget: 1.5},
+			`.*Config\.ReceiveErrorRateTarget.* for 'ReceiveErrorRateTarget' failed on the 'max' tag`,
+		),
+		Entry(
+			"Adaptive minimum above the maximum outstanding messages",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_RECEIVE_ADAPTIVE: StringPointer("true"), VAR_RECEIVE_MAX_OUTSTANDING_MESSAGES: StringPointer("50"), VAR_RECEIVE_ERROR_RATE_TARGET: nil, VAR_RECEIVE_MIN_OUTSTANDING_MESSAGES: StringPointer("100")},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", ReceiveAdaptive: true, ReceiveMaxOutstandingMessages: 50, ReceiveMinOutstandingMessages: 100},
+			`receive min outstanding messages \(100\) must not exceed receive max outstanding messages \(50\)`,
+		),
+		Entry(
+			"Retry initial backoff above the max backoff",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_RETRY_INITIAL_BACKOFF: StringPointer("10s"), VAR_RETRY_MAX_BACKOFF: nil},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", RetryInitialBackoff: 10 * time.Second},
+			`retry initial backoff \(10s\) must not exceed retry max backoff \(5s\)`,
+		),
+		Entry(
+			"Nack delays",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_NACK_DELAYS: StringPointer("0s, 2s,10s"), VAR_RECEIVE_MAX_EXTENSION: nil},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", NackDelays: "0s, 2s,10s"},
+		),
+		Entry(
+			"Invalid nack delay",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_NACK_DELAYS: StringPointer("1s,soon"), VAR_RECEIVE_MAX_EXTENSION: nil},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", NackDelays: "1s,soon"},
+			`invalid redelivery delay "soon"`,
+		),
+		Entry(
+			"Nack delay above the max extension",
+			EnvMap{VAR_PROJECT_ID: StringPointer("foo"), VAR_SUBSCRIPTION_ID: StringPointer("bar"), VAR_TOPIC_ID: StringPointer("baz"), VAR_NACK_DELAYS: StringPointer("1s,5m"), VAR_RECEIVE_MAX_EXTENSION: StringPointer("1m")},
+			&processor.Config{ProjectID: "foo", SubscriptionID: "bar", TopicID: "baz", NackDelays: "1s,5m", ReceiveMaxExtension: time.Minute},
+			`nack delay \(5m0s\) must not exceed receive max extension \(1m0s\)`,
+		),
 		Entry(
 			"Missing all required fields",
 			EnvMap{VAR_PROJECT_ID: nil, VAR_SUBSCRIPTION_ID: nil, VAR_TOPIC_ID: nil},
@@ -66,3 +150,59 @@ var _ = Describe("Config", func() {
 		),
 	)
 })
+
+var _ = DescribeTable("CoalesceLimit",
+	func(maxPending, expected int) {
+		cfg := &processor.Config{CoalesceMaxPending: maxPending}
+		Expect(cfg.CoalesceLimit()).To(Equal(expected))
+	},
+	Entry("should use the default when unset", 0, processor.DefaultCoalesceMaxPending),
+	Entry("should use the configured limit", 500, 500),
+)
+
+var _ = Describe("ReceiveSettings", func() {
+	It("should use the client defaults when unset", func() {
+		Expect((&processor.Config{}).ReceiveSettings()).To(Equal(pubsub.DefaultReceiveSettings))
+	})
+	It("should apply the configured settings", func() {
+		settings := (&processor.Config{
+			ReceiveMaxOutstandingMessages: 50, ReceiveMaxOutstandingBytes: 1 << 20, ReceiveNumGoroutines: 2, ReceiveMaxExtension: time.Minute,
+		}).ReceiveSettings()
+		Expect(settings.MaxOutstandingMessages).To(Equal(50))
+		Expect(settings.MaxOutstandingBytes).To(Equal(1 << 20))
+		Expect(settings.NumGoroutines).To(Equal(2))
+		Expect(settings.MaxExtension).To(Equal(time.Minute))
+	})
+	It("should use the adaptive defaults when unset", func() {
+		cfg := &processor.Config{}
+		Expect(cfg.AdaptiveEvery()).To(Equal(processor.DefaultReceiveAdaptiveInterval))
+		Expect(cfg.LatencyTarget()).To(Equal(processor.DefaultReceiveLatencyTarget))
+		Expect(cfg.ErrorRateTarget()).To(Equal(processor.DefaultReceiveErrorRateTarget))
+		Expect(cfg.MinOutstanding()).To(Equal(processor.DefaultReceiveMinOutstandingMessages))
+	})
+})
+
+var _ = Describe("Retry and breaker settings", func() {
+	It("should use the defaults when unset", func() {
+		cfg := &processor.Config{}
+		Expect(cfg.RetryPolicy()).To(Equal(retry.Policy{
+			MaxAttempts: processor.DefaultRetryMaxAttempts, InitialBackoff: processor.DefaultRetryInitialBackoff, MaxBackoff: processor.DefaultRetryMaxBackoff,
+		}))
+		Expect(cfg.BreakerThreshold()).To(Equal(processor.DefaultBreakerFailureThreshold))
+		Expect(cfg.BreakerCooldownPeriod()).To(Equal(processor.DefaultBreakerCooldown))
+	})
+	It("should use the configured settings", func() {
+		cfg := &processor.Config{RetryMaxAttempts: 1, RetryInitialBackoff: time.Millisecond, RetryMaxBackoff: time.Second, BreakerFailureThreshold: 3, BreakerCooldown: time.Minute}
+		Expect(cfg.RetryPolicy()).To(Equal(retry.Policy{MaxAttempts: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Second}))
+		Expect(cfg.BreakerThreshold()).To(Equal(3))
+		Expect(cfg.BreakerCooldownPeriod()).To(Equal(time.Minute))
+	})
+	It("should disable the breaker with a negative threshold", func() {
+		Expect((&processor.Config{BreakerFailureThreshold: -1}).BreakerThreshold()).To(BeZero())
+	})
+	It("should use the default nack delays when unset", func() {
+		delays, err := (&processor.Config{}).NackSchedule()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(delays).To(Equal([]time.Duration{time.Second, 5 * time.Second, 15 * time.Second, 30 * time.Second, time.Minute}))
+	})
+})
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FlowControl adapts the messages handled at once to the health of the database.
+// Each interval the upserts of the interval are assessed: the limit is halved if their average latency or error rate
+// is above target, and otherwise raised by a tenth of the maximum until it is restored (additive increase,
+// multiplicative decrease), so that a struggling database is given room quickly and load returns gradually.
+// The receiver keeps up to the maximum outstanding, and the limit is enforced as messages are handled (Acquire), so
+// that changing it never interrupts the messages already being handled.
+type FlowControl struct {
+	max, min        int
+	latencyTarget   time.Duration
+	errorRateTarget float64
+
+	mu        sync.Mutex
+	limit     int
+	handling  int
+	wake      chan struct{}
+	upserts   int
+	failures  int
+	latencies int
+	latency   time.Duration
+}
+
+// NewFlowControl starts with the limit at max, which is never exceeded, and never lowers it below min.
+func NewFlowControl(max, min int, latencyTarget time.Duration, errorRateTarget float64) *FlowControl {
+	return &FlowControl{max: max, min: min, latencyTarget: latencyTarget, errorRateTarget: errorRateTarget, limit: max,
+		wake: make(chan struct{})}
+}
+
+// Acquire waits until fewer messages than the limit are being handled and counts the caller's message, which must be
+// released once it has been handled. It returns the error of ctx if it is done first.
+func (f *FlowControl) Acquire(ctx context.Context) error {
+	for {
+		f.mu.Lock()
+		if f.handling < f.limit {
+			f.handling++
+			f.mu.Unlock()
+			return nil
+		}
+		wake := f.wake
+		f.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release stops counting a message acquired once it has been handled.
+func (f *FlowControl) Release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handling--
+	f.broadcast()
+}
+
+// Handling returns the number of messages being handled.
+func (f *FlowControl) Handling() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.handling
+}
+
+// broadcast wakes the messages waiting to be handled so that they check the limit again; f.mu must be held.
+func (f *FlowControl) broadcast() {
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+// Observe records the result of an upsert and, if it was made directly rather than buffered, its latency.
+func (f *FlowControl) Observe(latency time.Duration, measured bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.upserts++
+	if err != nil {
+		f.failures++
+	}
+	if measured {
+		f.latencies++
+		f.latency += latency
+	}
+}
+
+// Limit returns the current limit of messages handled at once.
+func (f *FlowControl) Limit() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.limit
+}
+
+// Assess adjusts the limit to the upserts observed since the last assessment and reports whether it changed.
+// The limit is kept when nothing was observed.
+func (f *FlowControl) Assess() (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := f.limit
+	if f.upserts > 0 {
+		slow := f.latencies > 0 && f.latency/time.Duration(f.latencies) > f.latencyTarget
+		failing := float64(f.failures)/float64(f.upserts) > f.errorRateTarget
+		if slow || failing {
+			f.limit = max(f.limit/2, f.min)
+		} else {
+			f.limit = min(f.limit+max(f.max/10, 1), f.max)
+		}
+	}
+	f.upserts, f.failures, f.latencies, f.latency = 0, 0, 0, 0
+	if f.limit > previous {
+		f.broadcast()
+	}
+	return f.limit, f.limit != previous
+}
@@ -0,0 +1,121 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/processor"
+)
+
+var _ = Describe("FlowControl", func() {
+	var flow *processor.FlowControl
+	failure := errors.New("upsert failed")
+	BeforeEach(func() {
+		flow = processor.NewFlowControl(1000, 100, 100*time.Millisecond, 0.1)
+	})
+	assess := func() int {
+		limit, _ := flow.Assess()
+		return limit
+	}
+
+	It("should start at the most outstanding messages and keep them while the database is healthy", func() {
+		flow.Observe(10*time.Millisecond, true, nil)
+		Expect(flow.Assess()).To(Equal(1000))
+		_, changed := flow.Assess()
+		Expect(changed).To(BeFalse())
+	})
+	It("should halve the limit while upserts are slow, down to the least outstanding messages", func() {
+		for _, expected := range []int{500, 250, 125, 100, 100} {
+			flow.Observe(time.Second, true, nil)
+			Expect(assess()).To(Equal(expected))
+		}
+	})
+	It("should halve the limit while upserts fail too often", func() {
+		for range 8 {
+			flow.Observe(0, false, nil)
+		}
+		flow.Observe(0, false, failure)
+		flow.Observe(0, false, failure)
+		Expect(assess()).To(Equal(500))
+	})
+	It("should ignore the latency of buffered upserts", func() {
+		flow.Observe(0, false, nil)
+		Expect(assess()).To(Equal(1000))
+	})
+	It("should restore the limit gradually once the database recovers", func() {
+		flow.Observe(time.Second, true, failure)
+		Expect(assess()).To(Equal(500))
+		// Nothing observed leaves the limit as it is
+		Expect(flow.Assess()).To(Equal(500))
+		for _, expected := range []int{600, 700, 800, 900, 1000, 1000} {
+			flow.Observe(time.Millisecond, true, nil)
+			Expect(assess()).To(Equal(expected))
+		}
+		Expect(flow.Limit()).To(Equal(1000))
+	})
+	Context("handling messages", func() {
+		ctx := context.Background()
+		BeforeEach(func() {
+			flow = processor.NewFlowControl(4, 2, 100*time.Millisecond, 0.1)
+		})
+		// acquired acquires in the background, returning a channel closed once it succeeds
+		acquired := func() chan struct{} {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				Expect(flow.Acquire(ctx)).To(Succeed())
+			}()
+			return done
+		}
+
+		It("should hold messages past the limit until others are released", func() {
+			for range 4 {
+				Expect(flow.Acquire(ctx)).To(Succeed())
+			}
+			waiting := acquired()
+			Consistently(waiting, 20*time.Millisecond).ShouldNot(BeClosed())
+			flow.Release()
+			Eventually(waiting).Should(BeClosed())
+			Expect(flow.Handling()).To(Equal(4))
+		})
+		It("should keep handling the messages acquired when the limit is lowered", func() {
+			for range 4 {
+				Expect(flow.Acquire(ctx)).To(Succeed())
+			}
+			flow.Observe(time.Second, true, nil)
+			Expect(assess()).To(Equal(2))
+			Expect(flow.Handling()).To(Equal(4))
+			waiting := acquired()
+			// Releasing down to the new limit is not enough for another message to be handled
+			flow.Release()
+			flow.Release()
+			Consistently(waiting, 20*time.Millisecond).ShouldNot(BeClosed())
+			flow.Release()
+			Eventually(waiting).Should(BeClosed())
+		})
+		It("should handle waiting messages once the limit is raised", func() {
+			flow.Observe(time.Second, true, nil)
+			Expect(assess()).To(Equal(2))
+			Expect(flow.Acquire(ctx)).To(Succeed())
+			Expect(flow.Acquire(ctx)).To(Succeed())
+			waiting := acquired()
+			Consistently(waiting, 20*time.Millisecond).ShouldNot(BeClosed())
+			flow.Observe(time.Millisecond, true, nil)
+			Expect(assess()).To(Equal(3))
+			Eventually(waiting).Should(BeClosed())
+		})
+		It("should stop waiting once the context is done", func() {
+			for range 4 {
+				Expect(flow.Acquire(ctx)).To(Succeed())
+			}
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			Expect(flow.Acquire(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(flow.Handling()).To(Equal(4))
+		})
+	})
+})
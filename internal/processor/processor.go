@@ -2,19 +2,26 @@ package processor
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"go.uber.org/zap"
 
+	"github.com/censys/scan-takehome/internal/coalesce"
 	"github.com/censys/scan-takehome/internal/database/dal"
-	"github.com/censys/scan-takehome/internal/database/models"
-	"github.com/censys/scan-takehome/pkg/scanning"
+	"github.com/censys/scan-takehome/internal/dedup"
+	"github.com/censys/scan-takehome/internal/filter"
+	"github.com/censys/scan-takehome/internal/fingerprint"
+	"github.com/censys/scan-takehome/internal/geoip"
+	"github.com/censys/scan-takehome/internal/retention"
+	"github.com/censys/scan-takehome/internal/retry"
+	"github.com/censys/scan-takehome/pkg/models"
+	"github.com/censys/scan-takehome/pkg/pipeline"
 )
 
 type processor struct {
@@ -26,17 +33,128 @@ type processor struct {
 	wg           sync.WaitGroup
 	sigChannel   chan os.Signal
 	scanEntryDB  dal.Scan
+	validator    *models.Validator
+	maintenance  time.Duration
+	retention    *retention.Job
+	coalescer    *coalesce.Buffer
+	settings     pubsub.ReceiveSettings
+	flow         *FlowControl
+	flowInterval time.Duration
+	retryPolicy  retry.Policy
+	breaker      *retry.Breaker
+	redelivery   *Redelivery
+	dedup        *dedup.Deduplicator
+	filter       *filter.Filter
+	geoip        *geoip.Enricher
+	fingerprints *fingerprint.Matcher
+	pipeline     *pipeline.Pipeline
+	handler      pipeline.Handler
+}
+
+// Option configures optional processor behaviour.
+type Option func(p *processor) error
+
+// WithRetention runs a retention job for the policy every interval while the processor is running.
+// The database must implement dal.Expirer; a policy which never expires anything is ignored.
+func WithRetention(policy retention.Policy, interval time.Duration) Option {
+	return func(p *processor) error {
+		if !policy.Enabled() {
+			return nil
+		}
+		expirer, ok := p.scanEntryDB.(dal.Expirer)
+		if !ok {
+			return errors.New("retention is configured but the database does not support expiry")
+		}
+		p.retention = retention.NewJob(expirer, policy, interval)
+		return nil
+	}
+}
+
+// WithDedup acks messages which were already processed within the window of the deduplicator without handling them
+// again; a nil deduplicator is ignored.
+func WithDedup(d *dedup.Deduplicator) Option {
+	return func(p *processor) error {
+		p.dedup = d
+		return nil
+	}
+}
+
+// WithFilter drops the scans excluded by the rules of the filter, which are reloaded while the processor is running;
+// a nil filter is ignored.
+func WithFilter(f *filter.Filter) Option {
+	return func(p *processor) error {
+		p.filter = f
+		return nil
+	}
+}
+
+// WithGeoIP stores scans with the ASN, organization and country of their ip, looked up by the enricher whose
+// databases are reloaded while the processor is running; a nil enricher is ignored.
+func WithGeoIP(e *geoip.Enricher) Option {
+	return func(p *processor) error {
+		p.geoip = e
+		return nil
+	}
+}
+
+// WithFingerprints stores scans with the vendor, product, version and CPE of the software identified from their
+// response by the rules of the matcher, which are reloaded while the processor is running; a nil matcher is ignored.
+func WithFingerprints(m *fingerprint.Matcher) Option {
+	return func(p *processor) error {
+		p.fingerprints = m
+		return nil
+	}
 }
 
 func (p *processor) receiveLoop() {
 	defer p.wg.Done()
+	// The receiver keeps the most outstanding messages throughout; adaptive flow control limits the messages handled
+	p.subscription.ReceiveSettings = p.settings
+	if p.redelivery != nil {
+		// Receive only returns once every message it delivered is acked or nacked, so held messages are released
+		context.AfterFunc(p.ctx, p.redelivery.Release)
+	}
 	err := p.subscription.Receive(p.ctx, p.HandleMessage)
-
 	if err != nil && err != context.Canceled {
 		zap.S().Errorw("receive message error", "error", err)
 	}
 }
 
+// adaptLoop assesses the database every flow interval, adjusting the limit of messages handled at once, until the
+// processor stops. The messages already being handled are not affected by a change.
+func (p *processor) adaptLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.flowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			if limit, changed := p.flow.Assess(); changed {
+				zap.S().Infow("adjusted the messages handled at once", "limit", limit)
+			}
+		}
+	}
+}
+
+// maintenanceLoop runs database maintenance on start up and then every maintenance interval until the processor stops.
+func (p *processor) maintenanceLoop(m dal.Maintainer) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.maintenance)
+	defer ticker.Stop()
+	for {
+		if err := m.Maintain(p.ctx); err != nil && p.ctx.Err() == nil {
+			zap.S().Errorw("database maintenance error", "error", err)
+		}
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (p *processor) signalHandler() {
 	sig := <-p.sigChannel
 	zap.S().Infow("received sigint or sigterm", "signal", sig)
@@ -48,61 +166,136 @@ func (p *processor) Start() {
 	signal.Notify(p.sigChannel, syscall.SIGINT, syscall.SIGTERM)
 	go p.signalHandler()
 
+	if m, ok := p.scanEntryDB.(dal.Maintainer); ok && p.maintenance > 0 {
+		p.wg.Add(1)
+		go p.maintenanceLoop(m)
+	}
+
+	if p.retention != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.retention.Run(p.ctx)
+		}()
+	}
+
+	if p.filter != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.filter.Run(p.ctx)
+		}()
+	}
+
+	if p.geoip != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.geoip.Run(p.ctx)
+		}()
+	}
+
+	if p.fingerprints != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.fingerprints.Run(p.ctx)
+		}()
+	}
+
+	if p.flow != nil {
+		p.wg.Add(1)
+		go p.adaptLoop()
+	}
+
 	p.wg.Add(1)
 	go p.receiveLoop()
 
 	<-p.ctx.Done()
-	p.wg.Done()
+	p.wg.Wait()
+	if p.coalescer != nil {
+		// The buffered scans are still written, although their messages may be redelivered as the subscription has
+		// stopped; writing a scan again is harmless as upserts only replace older entries
+		p.coalescer.Close()
+	}
 }
 
 func (p *processor) Stop() {
 	p.cancelFunc()
 }
 
+// HandleMessage passes a received message through the pipeline, which acknowledges it once it has been handled.
+// With adaptive flow control the message first waits until fewer messages than the limit are being handled; it is
+// nacked if the receiver stops first.
 func (p *processor) HandleMessage(ctx context.Context, msg *pubsub.Message) {
-	zap.S().Errorw("received message", "message", msg.Data)
-	var tempScan scanning.Scan
-	err := json.Unmarshal(msg.Data, &tempScan)
-	if err != nil {
-		zap.S().Errorw("failed to unmarshal scanning.Scan message", "error", err)
-		msg.Nack()
-		return
-	}
-	var scan scanning.Scan
-	switch tempScan.DataVersion {
-	case scanning.V1:
-		scan.Data = &scanning.V1Data{}
-	case scanning.V2:
-		scan.Data = &scanning.V2Data{}
-	default:
-		zap.S().Errorw("unknown data version", "data_version", tempScan.DataVersion)
-		msg.Nack()
-		return
-	}
-	err = json.Unmarshal(msg.Data, &scan)
-	entry, err := models.NewScanEntry(scan)
-	if err != nil {
-		zap.S().Errorw("failed to unmarshal full scan entry", "error", err)
-		msg.Nack()
-		return
+	zap.S().Debugw("received message", "message_id", msg.ID)
+	if p.flow != nil {
+		if err := p.flow.Acquire(ctx); err != nil {
+			msg.Nack()
+			return
+		}
+		defer p.flow.Release()
+	}
+	m := &pipeline.Message{
+		ID:              msg.ID,
+		Data:            msg.Data,
+		Attributes:      msg.Attributes,
+		PublishTime:     msg.PublishTime,
+		DeliveryAttempt: msg.DeliveryAttempt,
+	}
+	m.Set(pubsubMessageKey{}, msg)
+	p.handler.Handle(ctx, m)
+}
+
+// upsert makes a single attempt to write the entry once the circuit breaker lets it through, recording its result.
+// While the database is down messages are held rather than nacked, which pauses intake once every outstanding message
+// is held.
+func (p *processor) upsert(ctx context.Context, entry *models.ScanEntry) error {
+	if p.breaker != nil {
+		if err := p.breaker.Wait(ctx); err != nil {
+			return err
+		}
 	}
-	if err = p.scanEntryDB.Upsert(entry); err != nil {
-		zap.S().Errorw("failed to upsert full scan entry", "error", err)
-		msg.Nack()
-		return
+	start := time.Now()
+	err := p.scanEntryDB.Upsert(ctx, entry)
+	if p.flow != nil && ctx.Err() == nil {
+		// Writes abandoned as the receiver stops say nothing of the health of the database
+		p.flow.Observe(time.Since(start), true, err)
 	}
-	msg.Ack()
+	if p.breaker != nil {
+		p.breaker.Record(err)
+	}
+	return err
 }
 
 // New takes a processor Config instance and attempts to create a new processor from it.
 // If the Config.Validate() function fails, the processor will not be created and the resulting error will be returned.
-func New(cfg *Config, seDB dal.Scan) (*processor, error) {
+// Options are applied once the processor has been created and any error will also be returned.
+func New(cfg *Config, seDB dal.Scan, opts ...Option) (*processor, error) {
 	err := cfg.Validate()
 	if err != nil {
 		zap.S().Errorw("configuration error for new client", "error", err)
 		return nil, err
 	}
-	proc := &processor{}
+	portRules, err := cfg.ScanPortRules()
+	if err != nil {
+		return nil, err
+	}
+	proc := &processor{
+		validator:   models.NewValidator(portRules),
+		maintenance: cfg.MaintenanceEvery(),
+		settings:    cfg.ReceiveSettings(),
+		retryPolicy: cfg.RetryPolicy(),
+	}
+	if threshold := cfg.BreakerThreshold(); threshold > 0 {
+		proc.breaker = retry.NewBreaker(threshold, cfg.BreakerCooldownPeriod(), func(state retry.State) {
+			zap.S().Warnw("database circuit breaker changed state", "state", state.String())
+		})
+	}
+	if cfg.ReceiveAdaptive {
+		proc.flow = NewFlowControl(proc.settings.MaxOutstandingMessages, cfg.MinOutstanding(), cfg.LatencyTarget(), cfg.ErrorRateTarget())
+		proc.flowInterval = cfg.AdaptiveEvery()
+	}
 	proc.ctx, proc.cancelFunc = context.WithCancel(context.Background())
 	proc.client, err = pubsub.NewClient(proc.ctx, cfg.ProjectID)
 	if err != nil {
@@ -116,6 +309,37 @@ func New(cfg *Config, seDB dal.Scan) (*processor, error) {
 		zap.S().Errorw("could not validate subscription", "error", err)
 		return nil, errors.New("subscription does not exist")
 	}
+	subscriptionCfg, err := proc.subscription.Config(proc.ctx)
+	if err != nil {
+		zap.S().Errorw("could not read subscription configuration", "error", err)
+		return nil, err
+	}
+	if subscriptionCfg.RetryPolicy != nil {
+		zap.S().Infow("redelivery of nacked messages is delayed by the subscription retry policy",
+			"minimum_backoff", subscriptionCfg.RetryPolicy.MinimumBackoff, "maximum_backoff", subscriptionCfg.RetryPolicy.MaximumBackoff)
+	} else {
+		delays, err := cfg.NackSchedule()
+		if err != nil {
+			return nil, err
+		}
+		proc.redelivery = NewRedelivery(delays)
+	}
 	proc.scanEntryDB = seDB
+	if cfg.CoalesceWindow > 0 {
+		proc.coalescer = coalesce.New(seDB, cfg.CoalesceWindow, cfg.CoalesceLimit())
+	}
+	for _, opt := range opts {
+		if err = opt(proc); err != nil {
+			zap.S().Errorw("processor option error", "error", err)
+			return nil, err
+		}
+	}
+	proc.pipeline = proc.newPipeline()
+	if err = proc.pipeline.Apply(pipeline.Registered()...); err != nil {
+		zap.S().Errorw("pipeline stage error", "error", err)
+		return nil, err
+	}
+	zap.S().Infow("message pipeline", "stages", proc.pipeline.Stages())
+	proc.handler = proc.pipeline.Handler()
 	return proc, nil
 }
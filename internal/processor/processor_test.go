@@ -3,20 +3,34 @@ package processor_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
 	"github.com/jackc/pgx/v5/pgxpool"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	. "github.com/censys/scan-takehome/_test"
 	"github.com/censys/scan-takehome/internal/database"
 	"github.com/censys/scan-takehome/internal/database/config"
-	"github.com/censys/scan-takehome/internal/database/models"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/database/memory"
 	_ "github.com/censys/scan-takehome/internal/database/noop"
 	_ "github.com/censys/scan-takehome/internal/database/psql"
+	"github.com/censys/scan-takehome/internal/dedup"
+	"github.com/censys/scan-takehome/internal/fingerprint"
+	"github.com/censys/scan-takehome/internal/metrics"
 	"github.com/censys/scan-takehome/internal/processor"
+	"github.com/censys/scan-takehome/pkg/models"
+	"github.com/censys/scan-takehome/pkg/pipeline"
 	"github.com/censys/scan-takehome/pkg/scanning"
 )
 
@@ -165,13 +179,15 @@ var _ = Describe("Instantiation (integration test)", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			proc.HandleMessage(context.Background(), msg)
-			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP, entry.Port, entry.Service)
+			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service)
 			Expect(checkErr).ToNot(HaveOccurred())
 			defer rows.Close()
 			Expect(rows.Next()).To(BeTrue())
 			var fetchedEntry models.ScanEntry
-			checkErr = rows.Scan(&fetchedEntry.IP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
+			var fetchedIP string
+			checkErr = rows.Scan(&fetchedIP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
 			Expect(checkErr).ToNot(HaveOccurred())
+			fetchedEntry.IP = netip.MustParseAddr(fetchedIP)
 			Expect(fetchedEntry).To(Equal(*entry))
 		})
 		It("should handle a V2Data scan message", func() {
@@ -191,15 +207,380 @@ var _ = Describe("Instantiation (integration test)", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			proc.HandleMessage(context.Background(), msg)
-			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP, entry.Port, entry.Service)
+			rows, checkErr := pgxPool.Query(ctx, `SELECT ip, port, service, scan_date, response FROM scan_data WHERE ip=$1 AND port=$2 AND service=$3`, entry.IP.String(), entry.Port, entry.Service)
 			Expect(checkErr).ToNot(HaveOccurred())
 			defer rows.Close()
 			Expect(rows.Next()).To(BeTrue())
 			var fetchedEntry models.ScanEntry
-			checkErr = rows.Scan(&fetchedEntry.IP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
+			var fetchedIP string
+			checkErr = rows.Scan(&fetchedIP, &fetchedEntry.Port, &fetchedEntry.Service, &fetchedEntry.ScanTimestamp, &fetchedEntry.Response)
 			Expect(checkErr).ToNot(HaveOccurred())
+			fetchedEntry.IP = netip.MustParseAddr(fetchedIP)
 			Expect(fetchedEntry).To(Equal(*entry))
 			proc.HandleMessage(context.Background(), msg)
 		})
+		It("should store an IPv6 scan under its canonical address", func() {
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			db, err := database.New()
+			Expect(err).ToNot(HaveOccurred())
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			scan.Ip = "2001:0DB8:0000:0000:0000:0000:0000:0001"
+			scan.DataVersion = scanning.V2
+			scan.Data = &v2data
+			data, err := json.Marshal(scan)
+			Expect(err).ToNot(HaveOccurred())
+
+			proc.HandleMessage(context.Background(), &pubsub.Message{Data: data})
+			var count int
+			checkErr := pgxPool.QueryRow(ctx, `SELECT count(*) FROM scan_data WHERE ip=$1`, "2001:db8::1").Scan(&count)
+			Expect(checkErr).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+		It("should drop a scan message which fails validation", func() {
+			Expect(terminatingErr).ToNot(HaveOccurred())
+			db, err := database.New()
+			Expect(err).ToNot(HaveOccurred())
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			// Port 0 is only accepted over UDP by the default port rules
+			scan.Port = 0
+			scan.DataVersion = scanning.V2
+			scan.Data = &v2data
+			data, err := json.Marshal(scan)
+			Expect(err).ToNot(HaveOccurred())
+
+			proc.HandleMessage(context.Background(), &pubsub.Message{Data: data})
+			var count int
+			checkErr := pgxPool.QueryRow(ctx, `SELECT count(*) FROM scan_data`).Scan(&count)
+			Expect(checkErr).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
+	})
+	Describe("Integration Testing For Processing With The Memory Database", func() {
+		var (
+			envVars = EnvMap{
+				VAR_PROJECT_ID:      StringPointer("test-project"),
+				VAR_SUBSCRIPTION_ID: StringPointer("scan-sub"),
+				VAR_TOPIC_ID:        StringPointer("scan-topic"),
+				VAR_PUBSUB_HOST:     StringPointer("localhost:8085"),
+			}
+			restoreMap EnvMap
+			db         *memory.DB
+		)
+		messageOn := func(port uint32, timestamp, nanos int64, response string) *pubsub.Message {
+			data, err := json.Marshal(scanning.Scan{
+				Ip:             "192.168.1.1",
+				Port:           port,
+				Service:        "http",
+				Timestamp:      timestamp,
+				TimestampNanos: nanos,
+				DataVersion:    scanning.V2,
+				Data:           &scanning.V2Data{ResponseStr: response},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return &pubsub.Message{Data: data}
+		}
+		messageAt := func(timestamp, nanos int64, response string) *pubsub.Message {
+			return messageOn(80, timestamp, nanos, response)
+		}
+		message := func(timestamp int64, response string) *pubsub.Message {
+			return messageAt(timestamp, 0, response)
+		}
+		BeforeEach(func() {
+			restoreMap = envVars.SetupEnv()
+			db = memory.NewDB()
+		})
+		AfterEach(func() {
+			restoreMap.SetupEnv()
+		})
+		It("should store only the latest scan of a service", func() {
+			// This test requires a running Pub/Sub emulator with a topic "scan-topic" and subscription "scan-sub"
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			proc.HandleMessage(context.Background(), message(200, "newer"))
+			proc.HandleMessage(context.Background(), message(100, "older"))
+			Expect(db.Entries()).To(Equal([]*models.ScanEntry{{
+				IP:            netip.MustParseAddr("192.168.1.1"),
+				Port:          80,
+				Service:       "http",
+				ScanTimestamp: 200,
+				Response:      "newer",
+				ParseError:    `malformed banner: invalid HTTP status line "newer"`,
+			}}))
+		})
+		It("should order scans within the same second by their sub-second timestamp", func() {
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			proc.HandleMessage(context.Background(), messageAt(200, 500, "later"))
+			proc.HandleMessage(context.Background(), messageAt(200, 499, "earlier"))
+			Expect(db.Entries()).To(Equal([]*models.ScanEntry{{
+				IP:            netip.MustParseAddr("192.168.1.1"),
+				Port:          80,
+				Service:       "http",
+				ScanTimestamp: 200,
+				ScanNanos:     500,
+				Response:      "later",
+				ParseError:    `malformed banner: invalid HTTP status line "later"`,
+			}}))
+		})
+		It("should write only the newest scan of a service buffered within the coalescing window", func() {
+			restore := EnvMap{VAR_COALESCE_WINDOW: StringPointer("50ms")}.SetupEnv()
+			defer restore.SetupEnv()
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			proc.HandleMessage(context.Background(), message(200, "newer"))
+			proc.HandleMessage(context.Background(), message(300, "newest"))
+			proc.HandleMessage(context.Background(), message(100, "older"))
+			Expect(db.Entries()).To(BeEmpty())
+			Eventually(db.Entries).Should(Equal([]*models.ScanEntry{{
+				IP:            netip.MustParseAddr("192.168.1.1"),
+				Port:          80,
+				Service:       "http",
+				ScanTimestamp: 300,
+				Response:      "newest",
+				ParseError:    `malformed banner: invalid HTTP status line "newest"`,
+			}}))
+		})
+		It("should store the fields parsed from the response", func() {
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			proc.HandleMessage(context.Background(), message(100, "HTTP/1.1 200 OK\r\nServer: nginx\r\n\r\n"))
+			Expect(db.Entries()).To(ConsistOf(HaveField("Parsed", map[string]string{
+				"version": "HTTP/1.1", "status": "200", "reason": "OK", "server": "nginx",
+			})))
+		})
+		It("should store the fingerprint of the response", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "fingerprint.rules")
+			Expect(os.WriteFile(path, []byte(`HTTP m|^server: nginx/([\d.]+)|im vendor=f5 product=nginx version=$1`), 0o644)).To(Succeed())
+			matcher, err := fingerprint.Load(path, 0)
+			Expect(err).ToNot(HaveOccurred())
+			proc, err := processor.New(processor.ConfigFromEnv(), db, processor.WithFingerprints(matcher))
+			Expect(err).ToNot(HaveOccurred())
+			matched := metrics.Fingerprints.WithLabelValues("HTTP", metrics.ResultOK)
+			unmatched := metrics.Fingerprints.WithLabelValues(metrics.ServiceOther, metrics.ResultNotFound)
+			beforeMatched, beforeUnmatched := testutil.ToFloat64(matched), testutil.ToFloat64(unmatched)
+			proc.HandleMessage(context.Background(), message(100, "HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\n\r\n"))
+			Expect(db.Entries()).To(ConsistOf(HaveField("Fingerprint", models.Fingerprint{
+				Vendor: "f5", Product: "nginx", Version: "1.18.0", CPE: "cpe:2.3:a:f5:nginx:1.18.0:*:*:*:*:*:*:*",
+			})))
+			// Responses which no rule matches are counted as other services, whatever service the message names
+			proc.HandleMessage(context.Background(), message(200, "HTTP/1.1 200 OK\r\nServer: apache\r\n\r\n"))
+			Expect(testutil.ToFloat64(matched)).To(Equal(beforeMatched + 1))
+			Expect(testutil.ToFloat64(unmatched)).To(Equal(beforeUnmatched + 1))
+		})
+		It("should not store a scan when the database fails", func() {
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			db.SetFaults(memory.Faults{FailNext: 1})
+			proc.HandleMessage(context.Background(), message(100, "failed"))
+			Expect(db.Entries()).To(BeEmpty())
+		})
+		It("should retry a write which fails with a transient error", func() {
+			restore := EnvMap{VAR_RETRY_INITIAL_BACKOFF: StringPointer("1ms"), VAR_RETRY_MAX_BACKOFF: StringPointer("1ms")}.SetupEnv()
+			defer restore.SetupEnv()
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			db.SetFaults(memory.Faults{FailNext: 2, Err: dal.Transient(errors.New("connection reset"))})
+			proc.HandleMessage(context.Background(), message(100, "retried"))
+			Expect(db.Entries()).To(HaveLen(1))
+		})
+		It("should not interrupt the messages being handled when the limit of messages handled changes", func() {
+			restore := EnvMap{
+				VAR_RECEIVE_ADAPTIVE:          StringPointer("true"),
+				VAR_RECEIVE_ADAPTIVE_INTERVAL: StringPointer("10ms"),
+				VAR_RECEIVE_ERROR_RATE_TARGET: StringPointer("0.1"),
+			}.SetupEnv()
+			defer restore.SetupEnv()
+			// Scans of port 81 fail, lowering the limit while the scan of port 80 is being written
+			var mu sync.Mutex
+			attempts := map[uint32]int{}
+			db.SetFaults(memory.Faults{Latency: 300 * time.Millisecond, FailWhen: func(entry *models.ScanEntry) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts[entry.Port]++
+				return entry.Port == 81
+			}})
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				proc.Start()
+			}()
+			defer func() {
+				proc.Stop()
+				Eventually(stopped, 5*time.Second).Should(BeClosed())
+			}()
+
+			client, err := pubsub.NewClient(context.Background(), "test-project")
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+			topic := client.Topic("scan-topic")
+			defer topic.Stop()
+			_, err = topic.Publish(context.Background(), messageOn(81, 100, 0, "failing")).Get(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			time.Sleep(150 * time.Millisecond)
+			_, err = topic.Publish(context.Background(), messageOn(80, 100, 0, "written")).Get(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(db.Entries, 5*time.Second).Should(ConsistOf(HaveField("Port", uint32(80))))
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(attempts[80]).To(Equal(1))
+		})
+		It("should keep holding messages for redelivery when the limit of messages handled changes", func() {
+			restore := EnvMap{
+				VAR_RECEIVE_ADAPTIVE:          StringPointer("true"),
+				VAR_RECEIVE_ADAPTIVE_INTERVAL: StringPointer("10ms"),
+				VAR_RECEIVE_ERROR_RATE_TARGET: StringPointer("0.1"),
+				VAR_RETRY_MAX_ATTEMPTS:        StringPointer("1"),
+				VAR_NACK_DELAYS:               StringPointer("5s"),
+			}.SetupEnv()
+			defer restore.SetupEnv()
+			// The transient failure lowers the limit while its message is held for redelivery
+			var mu sync.Mutex
+			attempts := 0
+			db.SetFaults(memory.Faults{Err: dal.Transient(errors.New("connection reset")), FailWhen: func(*models.ScanEntry) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts++
+				return true
+			}})
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				proc.Start()
+			}()
+			defer func() {
+				proc.Stop()
+				Eventually(stopped, 5*time.Second).Should(BeClosed())
+			}()
+
+			client, err := pubsub.NewClient(context.Background(), "test-project")
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+			topic := client.Topic("scan-topic")
+			defer topic.Stop()
+			_, err = topic.Publish(context.Background(), message(100, "held")).Get(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			handled := func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return attempts
+			}
+			Eventually(handled, 5*time.Second).Should(Equal(1))
+			// A released message would be nacked and redelivered at once rather than after its delay
+			Consistently(handled, time.Second).Should(Equal(1))
+		})
+		It("should abandon the write when the context is cancelled", func() {
+			proc, err := processor.New(processor.ConfigFromEnv(), db)
+			Expect(err).ToNot(HaveOccurred())
+			db.SetFaults(memory.Faults{Latency: time.Minute})
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				proc.HandleMessage(ctx, message(100, "cancelled"))
+			}()
+			cancel()
+			Eventually(done).Should(BeClosed())
+			Expect(db.Entries()).To(BeEmpty())
+		})
+	})
+})
+
+// The in-process Pub/Sub server counts the deliveries and acks of each message, which the emulator does not expose.
+var _ = Describe("In-Process Pub/Sub Server", func() {
+	const topicName = "projects/test-project/topics/scan-topic"
+	var (
+		server *pstest.Server
+		db     *memory.DB
+		ctx    = context.Background()
+	)
+	BeforeEach(func() {
+		server = pstest.NewServer()
+		DeferCleanup(server.Close)
+		restore := EnvMap{
+			VAR_PROJECT_ID:      StringPointer("test-project"),
+			VAR_SUBSCRIPTION_ID: StringPointer("scan-sub"),
+			VAR_TOPIC_ID:        StringPointer("scan-topic"),
+			VAR_PUBSUB_HOST:     StringPointer(server.Addr),
+		}.SetupEnv()
+		DeferCleanup(restore.SetupEnv)
+		client, err := pubsub.NewClient(ctx, "test-project")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+		topic, err := client.CreateTopic(ctx, "scan-topic")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = client.CreateSubscription(ctx, "scan-sub", pubsub.SubscriptionConfig{Topic: topic})
+		Expect(err).ToNot(HaveOccurred())
+		db = memory.NewDB()
+	})
+	start := func(proc interface {
+		Start()
+		Stop()
+	}) {
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			proc.Start()
+		}()
+		DeferCleanup(func() {
+			proc.Stop()
+			Eventually(stopped, 5*time.Second).Should(BeClosed())
+		})
+	}
+	scanData := func(response string) []byte {
+		data, err := json.Marshal(scanning.Scan{
+			Ip:          "192.168.1.1",
+			Port:        80,
+			Service:     "http",
+			Timestamp:   100,
+			DataVersion: scanning.V2,
+			Data:        &scanning.V2Data{ResponseStr: response},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return data
+	}
+
+	It("should not ack a duplicate of a message being handled until the message is processed", func() {
+		// The original message fails once the duplicate has arrived, and is nacked
+		db.SetFaults(memory.Faults{Latency: 300 * time.Millisecond, FailNext: 1, Err: errors.New("rejected")})
+		deduplicator := dedup.NewDeduplicator(dedup.NewMemoryStore(10), true, time.Minute, time.Minute)
+		proc, err := processor.New(processor.ConfigFromEnv(), db, processor.WithDedup(deduplicator))
+		Expect(err).ToNot(HaveOccurred())
+		start(proc)
+
+		data := scanData("duplicated")
+		original := server.Publish(topicName, data, nil)
+		Eventually(func() int { return server.Message(original).Deliveries }).Should(Equal(1))
+		duplicate := server.Publish(topicName, data, nil)
+
+		// Had the duplicate been acked while the original was handled, its scan would be lost when the original fails
+		Eventually(func() int { return server.Message(duplicate).Acks }, 5*time.Second).Should(Equal(1))
+		Expect(db.Entries()).To(ConsistOf(HaveField("Response", "duplicated")))
+		Expect(server.Message(duplicate).Deliveries).To(BeNumerically(">", 1))
+		Eventually(func() int { return server.Message(original).Acks }).Should(Equal(1))
+	})
+	It("should add registered stages around the optional stages which are not configured", func() {
+		// The registration is kept for the rest of the suite, which the stage does not affect as it only counts messages
+		var handled atomic.Int32
+		pipeline.RegisterBefore(pipeline.StageGeoIP, pipeline.Stage{Name: "count", Middleware: func(next pipeline.Handler) pipeline.Handler {
+			return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+				handled.Add(1)
+				return next.Handle(ctx, m)
+			})
+		}})
+		proc, err := processor.New(processor.ConfigFromEnv(), db)
+		Expect(err).ToNot(HaveOccurred())
+		start(proc)
+
+		server.Publish(topicName, scanData("counted"), nil)
+		Eventually(db.Entries).Should(ConsistOf(HaveField("Response", "counted")))
+		Expect(handled.Load()).To(BeEquivalentTo(1))
 	})
 })
@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// trackedDeliveries bounds the failed deliveries counted for messages without a delivery attempt; the counts are
+	// reset once it is reached, which only restarts the delay schedule of the messages being retried.
+	trackedDeliveries = 100000
+)
+
+// ParseDelays parses a comma separated schedule of redelivery delays (e.g. "1s,5s,30s").
+func ParseDelays(s string) ([]time.Duration, error) {
+	delays := []time.Duration{}
+	for _, item := range strings.Split(s, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("invalid redelivery delay %q: %w", item, err)
+		}
+		if delay < 0 {
+			return nil, fmt.Errorf("invalid redelivery delay %q: must not be negative", item)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
+// Redelivery delays the redelivery of messages which failed with transient errors, so that a struggling database is
+// not hammered by immediate redeliveries. A message is held (the receiver keeps extending its ack deadline while it is
+// outstanding) for the delay of its failed delivery in the schedule and then nacked; deliveries past the end of the
+// schedule use its last delay.
+type Redelivery struct {
+	delays []time.Duration
+
+	mu       sync.Mutex
+	failures map[string]int
+	held     map[*time.Timer]func()
+}
+
+// NewRedelivery creates a Redelivery for the schedule of delays, which must not be empty.
+func NewRedelivery(delays []time.Duration) *Redelivery {
+	return &Redelivery{delays: delays, failures: map[string]int{}, held: map[*time.Timer]func(){}}
+}
+
+// Delay returns the delay before redelivering the message with the id. The delivery attempt of the message is used
+// when the subscription provides it (it has a dead letter policy), otherwise the failed deliveries of the id are
+// counted.
+func (r *Redelivery) Delay(id string, deliveryAttempt *int) time.Duration {
+	failure := 0
+	if deliveryAttempt != nil {
+		failure = *deliveryAttempt
+	} else {
+		r.mu.Lock()
+		if len(r.failures) >= trackedDeliveries {
+			r.failures = map[string]int{}
+		}
+		r.failures[id]++
+		failure = r.failures[id]
+		r.mu.Unlock()
+	}
+	return r.delays[min(max(failure, 1), len(r.delays))-1]
+}
+
+// Forget stops counting the failed deliveries of a message once it is acked.
+func (r *Redelivery) Forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, id)
+}
+
+// Hold calls nack once the delay has passed, or as soon as the held messages are released.
+func (r *Redelivery) Hold(delay time.Duration, nack func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var timer *time.Timer
+	// The timer cannot fire before it is recorded as the lock is held until then
+	timer = time.AfterFunc(delay, func() {
+		r.mu.Lock()
+		_, held := r.held[timer]
+		delete(r.held, timer)
+		r.mu.Unlock()
+		if held {
+			nack()
+		}
+	})
+	r.held[timer] = nack
+}
+
+// Held returns the number of messages held.
+func (r *Redelivery) Held() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.held)
+}
+
+// Release nacks every held message immediately. The receiver only stops once each of its messages is acked or
+// nacked, so the held messages are released when the processor stops.
+func (r *Redelivery) Release() {
+	r.mu.Lock()
+	held := r.held
+	r.held = map[*time.Timer]func(){}
+	r.mu.Unlock()
+	for timer, nack := range held {
+		timer.Stop()
+		nack()
+	}
+}
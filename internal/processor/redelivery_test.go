@@ -0,0 +1,64 @@
+package processor_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/processor"
+)
+
+var _ = Describe("ParseDelays", func() {
+	It("should parse a schedule of delays", func() {
+		Expect(processor.ParseDelays("0s, 250ms,1m")).To(Equal([]time.Duration{0, 250 * time.Millisecond, time.Minute}))
+	})
+	It("should reject invalid and negative delays", func() {
+		_, err := processor.ParseDelays("1s,,2s")
+		Expect(err).To(MatchError(ContainSubstring(`invalid redelivery delay ""`)))
+		_, err = processor.ParseDelays("-1s")
+		Expect(err).To(MatchError(ContainSubstring("must not be negative")))
+	})
+})
+
+var _ = Describe("Redelivery", func() {
+	var redelivery *processor.Redelivery
+	BeforeEach(func() {
+		redelivery = processor.NewRedelivery([]time.Duration{time.Second, 5 * time.Second, 30 * time.Second})
+	})
+
+	It("should increase the delay with each failed delivery of a message up to the last delay", func() {
+		Expect(redelivery.Delay("a", nil)).To(Equal(time.Second))
+		Expect(redelivery.Delay("a", nil)).To(Equal(5 * time.Second))
+		Expect(redelivery.Delay("b", nil)).To(Equal(time.Second))
+		Expect(redelivery.Delay("a", nil)).To(Equal(30 * time.Second))
+		Expect(redelivery.Delay("a", nil)).To(Equal(30 * time.Second))
+	})
+	It("should restart the schedule of a message once it is forgotten", func() {
+		redelivery.Delay("a", nil)
+		redelivery.Forget("a")
+		Expect(redelivery.Delay("a", nil)).To(Equal(time.Second))
+	})
+	It("should use the delivery attempt of the message when it is provided", func() {
+		attempt := 2
+		Expect(redelivery.Delay("a", &attempt)).To(Equal(5 * time.Second))
+		Expect(redelivery.Delay("a", &attempt)).To(Equal(5 * time.Second))
+	})
+	It("should nack a held message once its delay has passed", func() {
+		var nacked atomic.Int32
+		redelivery.Hold(20*time.Millisecond, func() { nacked.Add(1) })
+		Expect(redelivery.Held()).To(Equal(1))
+		Consistently(nacked.Load, 10*time.Millisecond).Should(BeZero())
+		Eventually(nacked.Load).Should(Equal(int32(1)))
+		Expect(redelivery.Held()).To(BeZero())
+	})
+	It("should nack the held messages immediately once they are released", func() {
+		var nacked atomic.Int32
+		redelivery.Hold(time.Hour, func() { nacked.Add(1) })
+		redelivery.Hold(time.Hour, func() { nacked.Add(1) })
+		redelivery.Release()
+		Expect(nacked.Load()).To(Equal(int32(2)))
+		Expect(redelivery.Held()).To(BeZero())
+	})
+})
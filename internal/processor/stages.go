@@ -0,0 +1,295 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/dedup"
+	"github.com/censys/scan-takehome/internal/fingerprint"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/internal/retry"
+	"github.com/censys/scan-takehome/pkg/banner"
+	"github.com/censys/scan-takehome/pkg/models"
+	"github.com/censys/scan-takehome/pkg/pipeline"
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+var (
+	// ErrFiltered is the reason messages whose scan is excluded by the filter rules are dropped.
+	ErrFiltered = errors.New("scan excluded by filter rules")
+
+	errDuplicate = errors.New("duplicate message")
+	// errProcessing is the error of a duplicate of a message which is being handled, which is nacked rather than
+	// dropped as the message being handled may still fail.
+	errProcessing = errors.New("duplicate of a message being handled")
+)
+
+// The keys of the values the stages store in each message.
+type (
+	pubsubMessageKey struct{}
+	dedupKey         struct{}
+)
+
+// newPipeline creates the processor's pipeline:
+// ack → dedup → decode → validate → filter → geoip → parse → fingerprint → transform → store.
+// The optional stages which are not configured are kept without a middleware, so that stages can always be added
+// around them.
+func (p *processor) newPipeline() *pipeline.Pipeline {
+	optional := func(enabled bool, middleware pipeline.Middleware) pipeline.Middleware {
+		if !enabled {
+			return nil
+		}
+		return middleware
+	}
+	return pipeline.New(
+		pipeline.Stage{Name: pipeline.StageAck, Middleware: pipeline.Ack(p.acknowledge)},
+		pipeline.Stage{Name: pipeline.StageDedup, Middleware: optional(p.dedup != nil, p.deduplicate)},
+		pipeline.Stage{Name: pipeline.StageDecode, Middleware: p.decode},
+		pipeline.Stage{Name: pipeline.StageValidate, Middleware: p.validate},
+		pipeline.Stage{Name: pipeline.StageFilter, Middleware: optional(p.filter != nil, p.filterScans)},
+		pipeline.Stage{Name: pipeline.StageGeoIP, Middleware: optional(p.geoip != nil, p.enrich)},
+		pipeline.Stage{Name: pipeline.StageParse, Middleware: p.parse},
+		pipeline.Stage{Name: pipeline.StageFingerprint, Middleware: optional(p.fingerprints != nil, p.fingerprint)},
+		pipeline.Stage{Name: pipeline.StageTransform},
+		pipeline.Stage{Name: pipeline.StageStore, Middleware: p.store},
+	)
+}
+
+// acknowledge acks a message which was handled or dropped and nacks it otherwise.
+func (p *processor) acknowledge(m *pipeline.Message, err error) {
+	msg := m.Value(pubsubMessageKey{}).(*pubsub.Message)
+	switch {
+	case err == nil:
+		key, _ := m.Value(dedupKey{}).(string)
+		p.ack(msg, key)
+	case errors.Is(err, pipeline.ErrDrop):
+		// Redelivery cannot change the outcome of a dropped message, so it is acked rather than nacked, and its claim
+		// is left to expire
+		zap.S().Infow("dropping message", "reason", err, "message_id", msg.ID)
+		msg.Ack()
+	default:
+		key, _ := m.Value(dedupKey{}).(string)
+		p.release(msg, key)
+		p.nack(msg, err)
+	}
+}
+
+// ack acks a message whose scan was written, remembering it by its deduplication key.
+func (p *processor) ack(msg *pubsub.Message, key string) {
+	if p.redelivery != nil {
+		p.redelivery.Forget(msg.ID)
+	}
+	if key != "" {
+		// The message is remembered even if the processor is stopping
+		if err := p.dedup.Mark(context.WithoutCancel(p.ctx), key); err != nil {
+			zap.S().Warnw("failed to remember processed message", "error", err, "message_id", msg.ID)
+		}
+	}
+	msg.Ack()
+}
+
+// release removes the deduplication claim of a message which is nacked, so that its redelivery is handled.
+func (p *processor) release(msg *pubsub.Message, key string) {
+	if key == "" {
+		return
+	}
+	if err := p.dedup.Release(context.WithoutCancel(p.ctx), key); err != nil {
+		zap.S().Warnw("failed to release the claim of a message", "error", err, "message_id", msg.ID)
+	}
+}
+
+// nack nacks a message whose scan could not be written. Unless the subscription delays redelivery itself, a message
+// which failed with a transient error, or which duplicates a message being handled, is held for the next delay of the
+// nack schedule first, so that a struggling database is not hammered by immediate redeliveries; held messages still
+// count against the outstanding messages.
+func (p *processor) nack(msg *pubsub.Message, err error) {
+	if p.redelivery == nil || !(dal.IsTransient(err) || errors.Is(err, errProcessing)) {
+		msg.Nack()
+		return
+	}
+	delay := p.redelivery.Delay(msg.ID, msg.DeliveryAttempt)
+	if delay <= 0 {
+		msg.Nack()
+		return
+	}
+	zap.S().Warnw("delaying redelivery of message", "message_id", msg.ID, "delay", delay)
+	p.redelivery.Hold(delay, msg.Nack)
+}
+
+// deduplicate drops messages which were already processed within the deduplication window, and nacks those which
+// duplicate a message being handled, as its scan is only stored once it succeeds.
+func (p *processor) deduplicate(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		key := p.dedup.Key(m.ID, m.Data)
+		// A store which cannot be reached lets messages through, as handling a message twice is harmless to the state
+		claim, err := p.dedup.Claim(ctx, key)
+		if err != nil {
+			zap.S().Warnw("failed to check for a duplicate message", "error", err, "message_id", m.ID)
+			return next.Handle(ctx, m)
+		}
+		switch claim {
+		case dedup.Processed:
+			return pipeline.Drop(errDuplicate)
+		case dedup.Processing:
+			return errProcessing
+		}
+		// The claim is marked processed once the message is acked, or released if it is nacked
+		m.Set(dedupKey{}, key)
+		return next.Handle(ctx, m)
+	})
+}
+
+// decode unmarshals the scan of the message for its data version.
+func (p *processor) decode(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		var tempScan scanning.Scan
+		if err := json.Unmarshal(m.Data, &tempScan); err != nil {
+			zap.S().Errorw("failed to unmarshal scanning.Scan message", "error", err)
+			return err
+		}
+		scan := &scanning.Scan{}
+		switch tempScan.DataVersion {
+		case scanning.V1:
+			scan.Data = &scanning.V1Data{}
+		case scanning.V2:
+			scan.Data = &scanning.V2Data{}
+		default:
+			zap.S().Errorw("unknown data version", "data_version", tempScan.DataVersion)
+			return fmt.Errorf("unknown data version %d", tempScan.DataVersion)
+		}
+		if err := json.Unmarshal(m.Data, scan); err != nil {
+			zap.S().Errorw("failed to unmarshal full scan message", "error", err)
+			return err
+		}
+		m.Scan = scan
+		return next.Handle(ctx, m)
+	})
+}
+
+// validate converts the scan into an entry and validates it, dropping invalid entries.
+func (p *processor) validate(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		entry, err := models.NewScanEntry(*m.Scan)
+		if err == nil {
+			err = p.validator.Validate(entry)
+		}
+		if errors.Is(err, models.ErrInvalidEntry) {
+			return pipeline.Drop(err)
+		}
+		if err != nil {
+			zap.S().Errorw("failed to unmarshal full scan entry", "error", err)
+			return err
+		}
+		m.Entry = entry
+		return next.Handle(ctx, m)
+	})
+}
+
+// filterScans drops the scans excluded by the filter rules, counting them by the kind of rule which excluded them.
+func (p *processor) filterScans(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		entry := m.Entry
+		if allowed, kind := p.filter.Allow(entry.IP, entry.Port, entry.Service); !allowed {
+			metrics.MessagesFiltered.WithLabelValues(kind).Inc()
+			return pipeline.Drop(fmt.Errorf("%w: %s", ErrFiltered, kind))
+		}
+		return next.Handle(ctx, m)
+	})
+}
+
+// enrich sets the ASN, organization and country of the entry. A failed lookup does not hold back the write; the entry
+// is stored with what was found.
+func (p *processor) enrich(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		entry := m.Entry
+		result := metrics.ResultOK
+		if err := p.geoip.Enrich(entry); err != nil {
+			zap.S().Warnw("failed to look up the geoip data of the scan", "error", err, "ip", entry.IP, "message_id", m.ID)
+			result = metrics.ResultError
+		} else if entry.ASN == 0 && entry.Country == "" {
+			result = metrics.ResultNotFound
+		}
+		metrics.GeoIPLookups.WithLabelValues(result).Inc()
+		return next.Handle(ctx, m)
+	})
+}
+
+// parse extracts the fields of the response with the parser of the service, if it has one. A response which cannot be
+// parsed does not hold back the write; the entry is stored with the parse error and the fields which were extracted.
+func (p *processor) parse(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		entry := m.Entry
+		parser := banner.Lookup(entry.Service)
+		if parser == nil {
+			return next.Handle(ctx, m)
+		}
+		fields, err := parser.Parse(entry.Response)
+		entry.Parsed, entry.ParseError = fields, ""
+		result := metrics.ResultOK
+		if err != nil {
+			zap.S().Debugw("failed to parse the response of the scan", "error", err, "service", entry.Service, "message_id", m.ID)
+			entry.ParseError = err.Error()
+			result = metrics.ResultError
+		}
+		metrics.BannerParses.WithLabelValues(strings.ToUpper(entry.Service), result).Inc()
+		return next.Handle(ctx, m)
+	})
+}
+
+// fingerprint sets the fingerprint of the entry from the first rule matching its response, or clears it if none does.
+func (p *processor) fingerprint(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		entry := m.Entry
+		rule, fp := p.fingerprints.Match(entry.Service, entry.Response)
+		entry.Fingerprint = fp
+		// The service is labelled from the rules rather than the message, so that the label values are bounded
+		service, result := metrics.ServiceOther, metrics.ResultNotFound
+		if rule != nil {
+			result = metrics.ResultOK
+			if rule.Service != fingerprint.AnyService {
+				service = rule.Service
+			}
+		}
+		metrics.Fingerprints.WithLabelValues(service, result).Inc()
+		return next.Handle(ctx, m)
+	})
+}
+
+// store writes the entry of the message, either directly with retries or through the coalescing buffer, which acks
+// the message once the newest scan of its service within the window is written.
+func (p *processor) store(next pipeline.Handler) pipeline.Handler {
+	return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+		entry := m.Entry
+		if p.coalescer != nil {
+			if p.breaker != nil {
+				if err := p.breaker.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			done := m.Defer()
+			p.coalescer.Add(entry, func(err error) {
+				if p.flow != nil {
+					p.flow.Observe(0, false, err)
+				}
+				if p.breaker != nil {
+					p.breaker.Record(err)
+				}
+				done(err)
+			})
+			return next.Handle(ctx, m)
+		}
+		// ctx is cancelled when the processor stops, which abandons the write and leaves the message for redelivery
+		if err := retry.Do(ctx, p.retryPolicy, func(ctx context.Context) error { return p.upsert(ctx, entry) }); err != nil {
+			zap.S().Errorw("failed to upsert full scan entry", "error", err)
+			return err
+		}
+		return next.Handle(ctx, m)
+	})
+}
@@ -0,0 +1,102 @@
+// Package reload keeps the rules parsed from a file up to date with the file, reloading them when it changes or on
+// SIGHUP without restarting the processor.
+package reload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Rules are what a file is parsed into.
+type Rules interface {
+	// Len returns the number of rules, which is logged when the file is loaded.
+	Len() int
+}
+
+// File holds the rules parsed from a file.
+type File[T Rules] struct {
+	name     string
+	path     string
+	interval time.Duration
+	parse    func(io.Reader) (T, error)
+
+	mu     sync.RWMutex
+	rules  T
+	loaded bool
+	sum    [sha256.Size]byte
+}
+
+// Load parses the file at path with parse, which Run reloads every interval once it changes. The name describes the
+// rules in the log, e.g. "filter rules".
+func Load[T Rules](name, path string, interval time.Duration, parse func(io.Reader) (T, error)) (*File[T], error) {
+	f := &File[T]{name: name, path: path, interval: interval, parse: parse}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Rules returns the rules last loaded; they must not be modified.
+func (f *File[T]) Rules() T {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules
+}
+
+// Reload reads the file again and replaces the rules if it changed, reporting whether it did.
+// The rules are kept if the file cannot be read or parsed.
+func (f *File[T]) Reload() (bool, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(data)
+	f.mu.RLock()
+	unchanged := f.loaded && sum == f.sum
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	rules, err := f.parse(bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	f.rules, f.loaded, f.sum = rules, true, sum
+	f.mu.Unlock()
+	zap.S().Infow("loaded "+f.name, "file", f.path, "rules", rules.Len())
+	return true, nil
+}
+
+// Run reloads the rules every interval, when the file changed, and on SIGHUP until the context is cancelled.
+func (f *File[T]) Run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	var tick <-chan time.Time
+	if f.interval > 0 {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-hangup:
+		}
+		if _, err := f.Reload(); err != nil {
+			zap.S().Errorw("failed to reload "+f.name+", keeping the current rules", "error", err, "file", f.path)
+		}
+	}
+}
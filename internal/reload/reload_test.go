@@ -0,0 +1,85 @@
+package reload_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/reload"
+)
+
+// lines are rules of one rule per line.
+type lines []string
+
+func (l lines) Len() int { return len(l) }
+
+var _ = Describe("File", func() {
+	var (
+		path   string
+		parses int
+	)
+	parse := func(r io.Reader) (lines, error) {
+		parses++
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(string(data), "invalid") {
+			return nil, errors.New("invalid rule")
+		}
+		return strings.Split(string(data), "\n"), nil
+	}
+	write := func(rules ...string) {
+		Expect(os.WriteFile(path, []byte(strings.Join(rules, "\n")), 0o644)).To(Succeed())
+	}
+	BeforeEach(func() {
+		path, parses = filepath.Join(GinkgoT().TempDir(), "test.rules"), 0
+	})
+
+	It("should fail to load a missing or invalid file", func() {
+		_, err := reload.Load("test rules", path, 0, parse)
+		Expect(err).To(HaveOccurred())
+		write("invalid")
+		_, err = reload.Load("test rules", path, 0, parse)
+		Expect(err).To(MatchError("invalid rule"))
+	})
+	It("should only parse the file again once it changes and keep the rules if it becomes invalid", func() {
+		write("a", "b")
+		f, err := reload.Load("test rules", path, 0, parse)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Rules()).To(Equal(lines{"a", "b"}))
+		Expect(f.Reload()).To(BeFalse())
+		Expect(parses).To(Equal(1))
+
+		write("c")
+		Expect(f.Reload()).To(BeTrue())
+		Expect(f.Rules()).To(Equal(lines{"c"}))
+
+		write("invalid")
+		_, err = f.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(f.Rules()).To(Equal(lines{"c"}))
+	})
+	It("should reload the rules every interval while running", func() {
+		write("a")
+		f, err := reload.Load("test rules", path, 10*time.Millisecond, parse)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f.Run(ctx)
+		}()
+		write("b")
+		Eventually(f.Rules).Should(Equal(lines{"b"}))
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+})
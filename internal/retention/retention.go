@@ -0,0 +1,174 @@
+// Package retention expires services which have not been seen for longer than a configurable age.
+// Retention can be run periodically (see Job.Run) or as a one-shot (see Job.RunOnce) against any dal.Expirer.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+const (
+	DefaultInterval = time.Hour
+)
+
+// Config holds the retention configuration.
+type Config struct {
+	// MaxAge is how long after it was last scanned a service is kept; zero keeps services forever.
+	MaxAge time.Duration `env:"RETENTION_MAX_AGE" validate:"min=0"`
+	// ServiceMaxAge overrides MaxAge per service (e.g. "HTTP=720h,SSH=2160h"); zero keeps that service forever.
+	ServiceMaxAge string `env:"RETENTION_SERVICE_MAX_AGE"`
+	// Interval is how often the retention job runs in the processor; zero uses DefaultInterval.
+	Interval time.Duration `env:"RETENTION_INTERVAL" validate:"min=0"`
+}
+
+// ConfigFromEnv returns a retention configuration which has been pre-loaded from the environment.
+func ConfigFromEnv() *Config {
+	cfg := &Config{}
+	env.Parse(cfg)
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err := validate.Struct(c)
+	if _, parseErr := ParseServiceMaxAge(c.ServiceMaxAge); parseErr != nil {
+		err = errors.Join(err, parseErr)
+	}
+	return err
+}
+
+// Policy returns the retention policy described by the configuration.
+func (c *Config) Policy() (Policy, error) {
+	services, err := ParseServiceMaxAge(c.ServiceMaxAge)
+	if err != nil {
+		return Policy{}, err
+	}
+	return Policy{MaxAge: c.MaxAge, ServiceMaxAge: services}, nil
+}
+
+// RunEvery returns the configured Interval or the default if it is not set.
+func (c *Config) RunEvery() time.Duration {
+	if c.Interval == 0 {
+		return DefaultInterval
+	}
+	return c.Interval
+}
+
+// ParseServiceMaxAge parses per-service ages of the form "HTTP=720h,SSH=2160h".
+func ParseServiceMaxAge(s string) (map[string]time.Duration, error) {
+	ages := map[string]time.Duration{}
+	if strings.TrimSpace(s) == "" {
+		return ages, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		service, age, found := strings.Cut(strings.TrimSpace(item), "=")
+		service = strings.TrimSpace(service)
+		if !found || service == "" {
+			return nil, fmt.Errorf("invalid service max age %q: expected <service>=<duration>", item)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(age))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid service max age %q: invalid duration", item)
+		}
+		ages[service] = d
+	}
+	return ages, nil
+}
+
+// Policy defines how long services are kept after they were last scanned.
+type Policy struct {
+	// MaxAge applies to every service without an override; zero keeps them forever.
+	MaxAge time.Duration
+	// ServiceMaxAge overrides MaxAge per service; zero keeps that service forever.
+	ServiceMaxAge map[string]time.Duration
+}
+
+// Enabled reports whether the policy would ever expire anything.
+func (p Policy) Enabled() bool {
+	if p.MaxAge > 0 {
+		return true
+	}
+	for _, age := range p.ServiceMaxAge {
+		if age > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules returns the expiry rules for the policy at the time now.
+// Services are returned in a stable order, followed by the rule for all remaining services.
+func (p Policy) Rules(now time.Time) []dal.ExpiryRule {
+	services := make([]string, 0, len(p.ServiceMaxAge))
+	for service := range p.ServiceMaxAge {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	rules := []dal.ExpiryRule{}
+	for _, service := range services {
+		if age := p.ServiceMaxAge[service]; age > 0 {
+			rules = append(rules, dal.ExpiryRule{Service: service, Before: now.Add(-age).Unix()})
+		}
+	}
+	if p.MaxAge > 0 {
+		rules = append(rules, dal.ExpiryRule{Exclude: services, Before: now.Add(-p.MaxAge).Unix()})
+	}
+	return rules
+}
+
+// Job applies a retention policy to a database.
+type Job struct {
+	db       dal.Expirer
+	policy   Policy
+	interval time.Duration
+}
+
+// NewJob creates a retention job; interval is only used by Run.
+func NewJob(db dal.Expirer, policy Policy, interval time.Duration) *Job {
+	return &Job{db: db, policy: policy, interval: interval}
+}
+
+// RunOnce applies every rule of the policy at the time now and returns the total number of entries expired.
+// See dal.Expirer for the dryRun and visit semantics.
+func (j *Job) RunOnce(ctx context.Context, now time.Time, dryRun bool, visit func(*models.ScanEntry) error) (int64, error) {
+	var total int64
+	for _, rule := range j.policy.Rules(now) {
+		count, err := j.db.Expire(ctx, rule, dryRun, visit)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Run applies the policy every interval until the context is cancelled.
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		count, err := j.RunOnce(ctx, time.Now(), false, nil)
+		if err != nil && ctx.Err() == nil {
+			zap.S().Errorw("retention error", "error", err)
+		} else if count > 0 {
+			zap.S().Infow("expired stale services", "count", count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
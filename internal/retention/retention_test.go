@@ -0,0 +1,170 @@
+package retention_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/retention"
+	"github.com/censys/scan-takehome/pkg/models"
+)
+
+// fakeExpirer records the rules it is called with and returns a fixed count per rule.
+type fakeExpirer struct {
+	mu      sync.Mutex
+	rules   []dal.ExpiryRule
+	dryRuns []bool
+	count   int64
+	err     error
+}
+
+func (f *fakeExpirer) Expire(_ context.Context, rule dal.ExpiryRule, dryRun bool, visit func(*models.ScanEntry) error) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule)
+	f.dryRuns = append(f.dryRuns, dryRun)
+	if visit != nil {
+		if err := visit(&models.ScanEntry{Service: rule.Service}); err != nil {
+			return 0, err
+		}
+	}
+	return f.count, f.err
+}
+
+var _ = Describe("Retention", func() {
+	const (
+		VAR_MAX_AGE         = "RETENTION_MAX_AGE"
+		VAR_SERVICE_MAX_AGE = "RETENTION_SERVICE_MAX_AGE"
+		VAR_INTERVAL        = "RETENTION_INTERVAL"
+	)
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	DescribeTable("Configuration from environment validation",
+		func(vars EnvMap, expConfig *retention.Config, expErrRegex ...string) {
+			restoreMap := vars.SetupEnv()
+			defer restoreMap.SetupEnv()
+
+			cfg := retention.ConfigFromEnv()
+			err := cfg.Validate()
+
+			Expect(cfg).To(Equal(expConfig))
+			if len(expErrRegex) == 0 {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(HaveOccurred())
+			for _, v := range expErrRegex {
+				Expect(err.Error()).To(MatchRegexp(v))
+			}
+		},
+		Entry("nothing configured",
+			EnvMap{VAR_MAX_AGE: nil, VAR_SERVICE_MAX_AGE: nil, VAR_INTERVAL: nil},
+			&retention.Config{},
+		),
+		Entry("all items valid",
+			EnvMap{VAR_MAX_AGE: StringPointer("720h"), VAR_SERVICE_MAX_AGE: StringPointer("SSH=24h"), VAR_INTERVAL: StringPointer("5m")},
+			&retention.Config{MaxAge: 720 * time.Hour, ServiceMaxAge: "SSH=24h", Interval: 5 * time.Minute},
+		),
+		Entry("negative max age",
+			EnvMap{VAR_MAX_AGE: StringPointer("-1h"), VAR_SERVICE_MAX_AGE: nil, VAR_INTERVAL: nil},
+			&retention.Config{MaxAge: -time.Hour},
+			`.*'Config\.MaxAge'.* for 'MaxAge' failed on the 'min' tag`,
+		),
+		Entry("invalid service max age",
+			EnvMap{VAR_MAX_AGE: nil, VAR_SERVICE_MAX_AGE: StringPointer("SSH"), VAR_INTERVAL: nil},
+			&retention.Config{ServiceMaxAge: "SSH"},
+			`invalid service max age "SSH": expected <service>=<duration>`,
+		),
+	)
+
+	DescribeTable("ParseServiceMaxAge",
+		func(input string, expected map[string]time.Duration, expErrRegex ...string) {
+			ages, err := retention.ParseServiceMaxAge(input)
+			if len(expErrRegex) > 0 {
+				Expect(err).To(MatchError(MatchRegexp(expErrRegex[0])))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ages).To(Equal(expected))
+		},
+		Entry("empty", "", map[string]time.Duration{}),
+		Entry("multiple services", "HTTP=720h, SSH = 48h", map[string]time.Duration{"HTTP": 720 * time.Hour, "SSH": 48 * time.Hour}),
+		Entry("invalid duration", "HTTP=forever", nil, `invalid service max age "HTTP=forever": invalid duration`),
+		Entry("negative duration", "HTTP=-1h", nil, `invalid duration`),
+	)
+
+	Context("Policy", func() {
+		It("should be disabled when nothing expires", func() {
+			Expect(retention.Policy{}.Enabled()).To(BeFalse())
+			Expect(retention.Policy{ServiceMaxAge: map[string]time.Duration{"SSH": 0}}.Enabled()).To(BeFalse())
+			Expect(retention.Policy{ServiceMaxAge: map[string]time.Duration{"SSH": time.Hour}}.Enabled()).To(BeTrue())
+			Expect(retention.Policy{MaxAge: time.Hour}.Enabled()).To(BeTrue())
+		})
+		It("should produce per-service rules followed by the default rule", func() {
+			policy := retention.Policy{
+				MaxAge:        48 * time.Hour,
+				ServiceMaxAge: map[string]time.Duration{"SSH": time.Hour, "HTTP": 0, "DNS": 2 * time.Hour},
+			}
+			Expect(policy.Rules(now)).To(Equal([]dal.ExpiryRule{
+				{Service: "DNS", Before: now.Add(-2 * time.Hour).Unix()},
+				{Service: "SSH", Before: now.Add(-time.Hour).Unix()},
+				// HTTP is excluded from the default rule as it is kept forever
+				{Exclude: []string{"DNS", "HTTP", "SSH"}, Before: now.Add(-48 * time.Hour).Unix()},
+			}))
+		})
+		It("should build the policy from the configuration", func() {
+			cfg := &retention.Config{MaxAge: time.Hour, ServiceMaxAge: "SSH=2h"}
+			policy, err := cfg.Policy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy).To(Equal(retention.Policy{MaxAge: time.Hour, ServiceMaxAge: map[string]time.Duration{"SSH": 2 * time.Hour}}))
+			Expect(cfg.RunEvery()).To(Equal(retention.DefaultInterval))
+		})
+	})
+
+	Context("Job", func() {
+		It("should apply every rule and total the results", func() {
+			db := &fakeExpirer{count: 2}
+			visited := []string{}
+			job := retention.NewJob(db, retention.Policy{MaxAge: time.Hour, ServiceMaxAge: map[string]time.Duration{"SSH": time.Minute}}, time.Hour)
+			count, err := job.RunOnce(context.Background(), now, true, func(e *models.ScanEntry) error {
+				visited = append(visited, e.Service)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(4)))
+			Expect(db.rules).To(HaveLen(2))
+			Expect(db.dryRuns).To(Equal([]bool{true, true}))
+			Expect(visited).To(Equal([]string{"SSH", ""}))
+		})
+		It("should stop at the first error", func() {
+			db := &fakeExpirer{count: 1, err: errors.New("boom")}
+			job := retention.NewJob(db, retention.Policy{MaxAge: time.Hour, ServiceMaxAge: map[string]time.Duration{"SSH": time.Minute}}, time.Hour)
+			count, err := job.RunOnce(context.Background(), now, false, nil)
+			Expect(err).To(MatchError("boom"))
+			Expect(count).To(Equal(int64(1)))
+			Expect(db.rules).To(HaveLen(1))
+		})
+		It("should run until the context is cancelled", func() {
+			db := &fakeExpirer{}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				retention.NewJob(db, retention.Policy{MaxAge: time.Hour}, 10*time.Millisecond).Run(ctx)
+			}()
+			Eventually(func() int {
+				db.mu.Lock()
+				defer db.mu.Unlock()
+				return len(db.rules)
+			}).Should(BeNumerically(">=", 2))
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+})
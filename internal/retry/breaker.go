@@ -0,0 +1,143 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets every operation through.
+	Closed State = iota
+	// Open stops every operation until the cool down has passed.
+	Open
+	// HalfOpen lets a single probe through, whose result closes or reopens the breaker.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker is a circuit breaker which opens after a number of consecutive transient failures, stopping operations
+// for a cool down, and then lets a single probe through to find out whether the database has recovered.
+// Callers wait for the breaker before each operation (Wait) and record its result (Record).
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// changed is closed, and replaced, whenever the state changes to wake the waiting callers
+	changed chan struct{}
+	// onChange is called with each new state, for logging and metrics
+	onChange func(State)
+}
+
+// NewBreaker creates a closed breaker which opens after threshold consecutive transient failures for cooldown.
+// onChange, if not nil, is called with each new state while the breaker is locked, so it must not call the breaker.
+func NewBreaker(threshold int, cooldown time.Duration, onChange func(State)) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, changed: make(chan struct{}), onChange: onChange}
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState changes the state and wakes the waiting callers; b.mu must be held.
+func (b *Breaker) setState(state State) {
+	if state == b.state {
+		return
+	}
+	b.state = state
+	if state == Open {
+		b.openedAt = time.Now()
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
+
+// Wait returns once an operation may be attempted: immediately while the breaker is closed, or as the probe once the
+// cool down of an open breaker has passed. Other callers wait for the result of the probe.
+// The context error is returned if ctx is done first.
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		state, changed, remaining := b.state, b.changed, time.Until(b.openedAt.Add(b.cooldown))
+		if state == Closed {
+			b.mu.Unlock()
+			return nil
+		}
+		if state == Open && remaining <= 0 {
+			b.setState(HalfOpen)
+			b.mu.Unlock()
+			return nil
+		}
+		b.mu.Unlock()
+
+		if err := waitChange(ctx, changed, state == Open, remaining); err != nil {
+			return err
+		}
+	}
+}
+
+// waitChange waits for the state to change, for the remaining cool down of an open breaker to pass, or for ctx.
+func waitChange(ctx context.Context, changed <-chan struct{}, open bool, remaining time.Duration) error {
+	var cooled <-chan time.Time
+	if open {
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		cooled = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-changed:
+	case <-cooled:
+	}
+	return nil
+}
+
+// Record records the result of an operation: transient failures (see dal.IsTransient) count towards opening the
+// breaker and reopen a half-open breaker, while any other result shows the database is up and closes it.
+// Cancelled operations say nothing of the database; a cancelled probe lets the next caller probe instead.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		if b.state == HalfOpen {
+			b.setState(Open)
+			b.openedAt = time.Now().Add(-b.cooldown)
+		}
+		return
+	}
+	if !dal.IsTransient(err) {
+		b.failures = 0
+		b.setState(Closed)
+		return
+	}
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.setState(Open)
+	}
+}
@@ -0,0 +1,54 @@
+// Package retry retries database writes which fail with transient errors (see dal.IsTransient) with a bounded,
+// jittered exponential backoff, and stops writes altogether while the database is down with a circuit breaker
+// (see Breaker), so that a blip of the database does not become a storm of redelivered messages.
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+)
+
+// Policy bounds the attempts of an operation and the backoff between them.
+type Policy struct {
+	// MaxAttempts is the most attempts of an operation, including the first; one attempt does not retry.
+	MaxAttempts int
+	// InitialBackoff is the longest wait before the first retry, which doubles with each retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Backoff returns the wait before the retry following the given attempt (counting from one).
+// The wait is drawn uniformly from zero to the exponential bound ("full jitter"), so that the retries of many
+// concurrent writes are spread out rather than arriving at the database together.
+func (p Policy) Backoff(attempt int) time.Duration {
+	bound := p.InitialBackoff
+	for i := 1; i < attempt && bound < p.MaxBackoff; i++ {
+		bound *= 2
+	}
+	bound = min(bound, p.MaxBackoff)
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound + 1)
+}
+
+// Do calls op until it succeeds, fails with an error which is not transient, the attempts are exhausted, or ctx is
+// done. The error of the last attempt is returned, or the context error if ctx is done while waiting to retry.
+func Do(ctx context.Context, p Policy, op func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !dal.IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
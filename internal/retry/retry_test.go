@@ -0,0 +1,143 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/internal/database/dal"
+	"github.com/censys/scan-takehome/internal/retry"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		policy    = retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+		transient = dal.Transient(errors.New("connection reset"))
+		permanent = errors.New("unique violation")
+		ctx       = context.Background()
+	)
+	// failing returns an operation which fails with the errors in turn and then succeeds, counting its attempts
+	failing := func(attempts *int, errs ...error) func(context.Context) error {
+		return func(context.Context) error {
+			*attempts++
+			if *attempts <= len(errs) {
+				return errs[*attempts-1]
+			}
+			return nil
+		}
+	}
+
+	It("should retry transient errors until the operation succeeds", func() {
+		attempts := 0
+		Expect(retry.Do(ctx, policy, failing(&attempts, transient, transient))).To(Succeed())
+		Expect(attempts).To(Equal(3))
+	})
+	It("should return the last error once the attempts are exhausted", func() {
+		attempts := 0
+		Expect(retry.Do(ctx, policy, failing(&attempts, transient, transient, transient, transient))).To(MatchError(transient))
+		Expect(attempts).To(Equal(3))
+	})
+	It("should not retry errors which are not transient", func() {
+		attempts := 0
+		Expect(retry.Do(ctx, policy, failing(&attempts, permanent))).To(MatchError(permanent))
+		Expect(attempts).To(Equal(1))
+	})
+	It("should stop waiting to retry when the context is done", func() {
+		slow := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+		cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		attempts := 0
+		// The backoff is drawn from zero to an hour, so retrying within the timeout is unlikely but possible
+		Expect(retry.Do(cancelled, slow, failing(&attempts, transient, transient, transient))).To(
+			SatisfyAny(MatchError(context.DeadlineExceeded), MatchError(transient)))
+	})
+	It("should bound the backoff, doubling the bound with each attempt", func() {
+		for range 100 {
+			Expect(policy.Backoff(1)).To(BeNumerically("<=", time.Millisecond))
+			Expect(policy.Backoff(2)).To(BeNumerically("<=", 2*time.Millisecond))
+			Expect(policy.Backoff(10)).To(BeNumerically("<=", 4*time.Millisecond))
+		}
+		Expect(retry.Policy{}.Backoff(1)).To(BeZero())
+	})
+	DescribeTable("should classify errors as transient",
+		func(err error, transient bool) {
+			Expect(dal.IsTransient(err)).To(Equal(transient))
+		},
+		Entry("marked as transient", dal.Transient(errors.New("serialization failure")), true),
+		Entry("timeout", context.DeadlineExceeded, true),
+		Entry("cancellation", context.Canceled, false),
+		Entry("other error", errors.New("invalid entry"), false),
+		Entry("no error", nil, false),
+	)
+})
+
+var _ = Describe("Breaker", func() {
+	var (
+		breaker *retry.Breaker
+		changes chan retry.State
+		failure = dal.Transient(errors.New("connection refused"))
+		ctx     = context.Background()
+	)
+	BeforeEach(func() {
+		changes = make(chan retry.State, 10)
+		breaker = retry.NewBreaker(2, 20*time.Millisecond, func(s retry.State) { changes <- s })
+	})
+	open := func() {
+		breaker.Record(failure)
+		breaker.Record(failure)
+		Expect(breaker.State()).To(Equal(retry.Open))
+	}
+
+	It("should stay closed until the failures are consecutive", func() {
+		breaker.Record(failure)
+		breaker.Record(nil)
+		breaker.Record(failure)
+		Expect(breaker.State()).To(Equal(retry.Closed))
+		Expect(breaker.Wait(ctx)).To(Succeed())
+	})
+	It("should not count errors which are not transient", func() {
+		breaker.Record(errors.New("invalid entry"))
+		breaker.Record(errors.New("invalid entry"))
+		Expect(breaker.State()).To(Equal(retry.Closed))
+	})
+	It("should stop operations while open, and let a probe through after the cool down", func() {
+		open()
+		short, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+		defer cancel()
+		Expect(breaker.Wait(short)).To(MatchError(context.DeadlineExceeded))
+
+		start := time.Now()
+		Expect(breaker.Wait(ctx)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(breaker.State()).To(Equal(retry.HalfOpen))
+
+		// Other callers wait for the result of the probe
+		waited := make(chan error, 1)
+		go func() { waited <- breaker.Wait(ctx) }()
+		Consistently(waited).WithTimeout(30 * time.Millisecond).ShouldNot(Receive())
+		breaker.Record(nil)
+		Eventually(waited).Should(Receive(BeNil()))
+		Expect(breaker.State()).To(Equal(retry.Closed))
+		Expect(changes).To(HaveLen(3))
+		Expect([]retry.State{<-changes, <-changes, <-changes}).To(Equal([]retry.State{retry.Open, retry.HalfOpen, retry.Closed}))
+	})
+	It("should reopen when the probe fails", func() {
+		open()
+		Expect(breaker.Wait(ctx)).To(Succeed())
+		breaker.Record(failure)
+		Expect(breaker.State()).To(Equal(retry.Open))
+	})
+	It("should let another caller probe when the probe is cancelled", func() {
+		open()
+		Expect(breaker.Wait(ctx)).To(Succeed())
+		breaker.Record(context.Canceled)
+		Expect(breaker.State()).To(Equal(retry.Open))
+		short, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+		defer cancel()
+		Expect(breaker.Wait(short)).To(Succeed())
+		Expect(breaker.State()).To(Equal(retry.HalfOpen))
+	})
+})
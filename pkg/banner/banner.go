@@ -0,0 +1,77 @@
+// Package banner extracts structured fields from the responses (banners) of scanned services, so that they can be
+// queried without parsing the free-form response, e.g. the server header of HTTP services or the software of SSH
+// services.
+//
+// Parsers are registered by service in a registry, which holds parsers for HTTP, SSH and DNS. Parsers for other
+// services are registered with Register from the init function of a package imported by a wrapper binary, as
+// databases and pipeline stages are.
+package banner
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxQuoted is the longest part of a response quoted in a parse error.
+	maxQuoted = 64
+)
+
+var (
+	// ErrMalformed is returned by parsers for responses which are not a banner of their service.
+	ErrMalformed = errors.New("malformed banner")
+)
+
+// Parser extracts the fields of the responses of a service.
+type Parser interface {
+	// Parse returns the fields of the response. The fields which could be extracted may be returned along with an
+	// error for a response which is only partly understood.
+	Parse(response string) (map[string]string, error)
+}
+
+// ParserFunc is a function used as a Parser.
+type ParserFunc func(response string) (map[string]string, error)
+
+func (f ParserFunc) Parse(response string) (map[string]string, error) {
+	return f(response)
+}
+
+// The parsers by service, in upper case.
+var registry = map[string]Parser{}
+
+// Register registers the parser of a service, replacing the parser registered for it before; a nil parser removes it,
+// so that the service is no longer parsed. Services are matched regardless of case.
+func Register(service string, parser Parser) {
+	if parser == nil {
+		delete(registry, strings.ToUpper(service))
+		return
+	}
+	registry[strings.ToUpper(service)] = parser
+}
+
+// Lookup returns the parser of a service, or nil if none is registered.
+func Lookup(service string) Parser {
+	return registry[strings.ToUpper(service)]
+}
+
+// Services returns the services which have a parser, in upper case and sorted.
+func Services() []string {
+	return slices.Sorted(maps.Keys(registry))
+}
+
+// malformed returns an ErrMalformed for the part of a response, which is quoted up to maxQuoted bytes.
+func malformed(reason, part string) error {
+	if len(part) > maxQuoted {
+		part = part[:maxQuoted] + "…"
+	}
+	return fmt.Errorf("%w: %s %q", ErrMalformed, reason, part)
+}
+
+// firstLine returns the first line of s, without its line ending, and the rest of s.
+func firstLine(s string) (string, string) {
+	line, rest, _ := strings.Cut(s, "\n")
+	return strings.TrimSuffix(line, "\r"), rest
+}
@@ -0,0 +1,65 @@
+package banner_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/pkg/banner"
+)
+
+var _ = Describe("Registry", func() {
+	It("should hold the parsers of the built-in services regardless of case", func() {
+		Expect(banner.Services()).To(ContainElements("DNS", "HTTP", "SSH"))
+		Expect(banner.Lookup("http")).ToNot(BeNil())
+		Expect(banner.Lookup("TELNET")).To(BeNil())
+	})
+	It("should register and remove the parsers of new services", func() {
+		banner.Register("Telnet", banner.ParserFunc(func(response string) (map[string]string, error) {
+			return map[string]string{"prompt": strings.TrimSpace(response)}, nil
+		}))
+		Expect(banner.Services()).To(ContainElement("TELNET"))
+		Expect(banner.Lookup("telnet").Parse("login: ")).To(Equal(map[string]string{"prompt": "login:"}))
+		banner.Register("TELNET", nil)
+		Expect(banner.Services()).ToNot(ContainElement("TELNET"))
+		Expect(banner.Lookup("telnet")).To(BeNil())
+	})
+})
+
+var _ = Describe("Parsers", func() {
+	DescribeTable("should extract the fields of a response",
+		func(service, response string, expected map[string]string) {
+			Expect(banner.Lookup(service).Parse(response)).To(Equal(expected))
+		},
+		Entry("HTTP response", "HTTP", "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nServer: nginx/1.18.0 (Ubuntu)\r\n\r\n"+
+			"<html><head><TITLE lang=\"en\">Welcome to\n  nginx &amp; friends</TITLE></head></html>",
+			map[string]string{"version": "HTTP/1.1", "status": "200", "reason": "OK", "server": "nginx/1.18.0 (Ubuntu)",
+				"title": "Welcome to nginx & friends"}),
+		Entry("HTTP status line only", "HTTP", "HTTP/2 404", map[string]string{"version": "HTTP/2", "status": "404"}),
+		Entry("HTTP title in the headers is ignored", "HTTP", "HTTP/1.0 302 Found\nX-Note: <title>no</title>\n",
+			map[string]string{"version": "HTTP/1.0", "status": "302", "reason": "Found"}),
+		Entry("SSH identification string", "SSH", "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6\r\n",
+			map[string]string{"protocol": "2.0", "software": "OpenSSH_8.9p1", "comments": "Ubuntu-3ubuntu0.6"}),
+		Entry("SSH identification string after other lines", "SSH", "Welcome\r\nSSH-1.99-Cisco-1.25\r\n",
+			map[string]string{"protocol": "1.99", "software": "Cisco-1.25"}),
+		Entry("DNS version text", "DNS", "9.18.1-1ubuntu1-Ubuntu\n", map[string]string{"version": "9.18.1-1ubuntu1-Ubuntu"}),
+		Entry("DNS quoted version", "DNS", `"dnsmasq-2.80"`, map[string]string{"version": "dnsmasq-2.80"}),
+		Entry("DNS TXT record", "DNS", "version.bind.\t0\tCH\tTXT\t\"PowerDNS\" \" Recursor \\\"4.1\\\"\"",
+			map[string]string{"name": "version.bind", "version": `PowerDNS Recursor "4.1"`}),
+	)
+	DescribeTable("should reject a response which is not a banner of the service",
+		func(service, response, expected string) {
+			fields, err := banner.Lookup(service).Parse(response)
+			Expect(err).To(MatchError(banner.ErrMalformed))
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+			Expect(fields).To(BeNil())
+		},
+		Entry("HTTP without a status line", "HTTP", "<html></html>", `invalid HTTP status line "<html></html>"`),
+		Entry("HTTP with a long first line", "HTTP", strings.Repeat("x", 100), strings.Repeat("x", 64)+`…"`),
+		Entry("SSH without an identification string", "SSH", "Protocol mismatch.\n", `no SSH identification string "Protocol mismatch."`),
+		Entry("SSH without a software version", "SSH", "SSH-2.0-\r\n", `invalid SSH identification string "SSH-2.0-"`),
+		Entry("DNS without a version", "DNS", " \n", "invalid DNS version response"),
+		Entry("DNS over several lines", "DNS", "line one\nline two", `invalid DNS version response "line one"`),
+	)
+})
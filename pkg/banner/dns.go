@@ -0,0 +1,58 @@
+package banner
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	ServiceDNS = "DNS"
+
+	// The fields of DNS version responses.
+	FieldDNSName    = "name"
+	FieldDNSVersion = "version"
+)
+
+var (
+	// txtRecord matches a TXT record in presentation format: name, optional ttl and class, TXT and the text
+	txtRecord    = regexp.MustCompile(`(?i)^(\S+)\s+(?:\d+\s+)?(?:(?:IN|CH|CHAOS|HS)\s+)?(?:\d+\s+)?TXT\s+(.*)$`)
+	quotedString = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+	escapedChar  = regexp.MustCompile(`\\(.)`)
+)
+
+func init() {
+	Register(ServiceDNS, ParserFunc(ParseDNS))
+}
+
+// ParseDNS extracts the version a DNS server reports in the CHAOS TXT record of version.bind (or version.server). The
+// response is either the text of the record, quoted or not, or a record in presentation format, e.g.
+//
+//	version.bind. 0 CH TXT "9.18.1-1ubuntu1-Ubuntu"
+//
+// whose name is extracted as well. The character strings of a record are joined as a single version.
+func ParseDNS(response string) (map[string]string, error) {
+	fields := map[string]string{}
+	text := strings.TrimSpace(response)
+	for rest := text; rest != ""; {
+		var line string
+		line, rest = firstLine(rest)
+		if match := txtRecord.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			fields[FieldDNSName] = strings.ToLower(strings.TrimSuffix(match[1], "."))
+			text = match[2]
+			break
+		}
+	}
+	if quoted := quotedString.FindAllStringSubmatch(text, -1); quoted != nil {
+		var version strings.Builder
+		for _, match := range quoted {
+			version.WriteString(escapedChar.ReplaceAllString(match[1], "$1"))
+		}
+		text = version.String()
+	}
+	if text = strings.TrimSpace(text); text == "" || strings.ContainsAny(text, "\r\n") {
+		line, _ := firstLine(strings.TrimSpace(response))
+		return nil, malformed("invalid DNS version response", line)
+	}
+	fields[FieldDNSVersion] = text
+	return fields, nil
+}
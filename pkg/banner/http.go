@@ -0,0 +1,57 @@
+package banner
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	ServiceHTTP = "HTTP"
+
+	// The fields of HTTP responses.
+	FieldHTTPVersion = "version"
+	FieldHTTPStatus  = "status"
+	FieldHTTPReason  = "reason"
+	FieldHTTPServer  = "server"
+	FieldHTTPTitle   = "title"
+)
+
+var (
+	statusLine = regexp.MustCompile(`^(HTTP/\d(?:\.\d)?) (\d{3})(?: (.*))?$`)
+	titleTag   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+func init() {
+	Register(ServiceHTTP, ParserFunc(ParseHTTP))
+}
+
+// ParseHTTP extracts the version, status code and reason of the status line, the server header and the title of an
+// HTML body from an HTTP response. The headers and body are optional, as scanners may only keep the start of a response.
+func ParseHTTP(response string) (map[string]string, error) {
+	line, rest := firstLine(response)
+	match := statusLine.FindStringSubmatch(line)
+	if match == nil {
+		return nil, malformed("invalid HTTP status line", line)
+	}
+	fields := map[string]string{FieldHTTPVersion: match[1], FieldHTTPStatus: match[2]}
+	if reason := strings.TrimSpace(match[3]); reason != "" {
+		fields[FieldHTTPReason] = reason
+	}
+	for rest != "" {
+		line, rest = firstLine(rest)
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), "server") {
+			fields[FieldHTTPServer] = strings.TrimSpace(value)
+		}
+	}
+	if match := titleTag.FindStringSubmatch(rest); match != nil {
+		if title := strings.Join(strings.Fields(html.UnescapeString(match[1])), " "); title != "" {
+			fields[FieldHTTPTitle] = title
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,48 @@
+package banner
+
+import (
+	"strings"
+)
+
+const (
+	ServiceSSH = "SSH"
+
+	// The fields of SSH identification strings.
+	FieldSSHProtocol = "protocol"
+	FieldSSHSoftware = "software"
+	FieldSSHComments = "comments"
+)
+
+func init() {
+	Register(ServiceSSH, ParserFunc(ParseSSH))
+}
+
+// ParseSSH extracts the protocol version, software version and comments of the identification string of an SSH server
+// (RFC 4253, section 4.2), "SSH-protoversion-softwareversion SP comments". The lines a server may send before its
+// identification string are skipped.
+func ParseSSH(response string) (map[string]string, error) {
+	rest := response
+	for rest != "" {
+		var line string
+		line, rest = firstLine(rest)
+		ident, found := strings.CutPrefix(line, "SSH-")
+		if !found {
+			continue
+		}
+		protocol, version, found := strings.Cut(ident, "-")
+		if !found || protocol == "" {
+			return nil, malformed("invalid SSH identification string", line)
+		}
+		software, comments, _ := strings.Cut(version, " ")
+		if software == "" {
+			return nil, malformed("invalid SSH identification string", line)
+		}
+		fields := map[string]string{FieldSSHProtocol: protocol, FieldSSHSoftware: software}
+		if comments = strings.TrimSpace(comments); comments != "" {
+			fields[FieldSSHComments] = comments
+		}
+		return fields, nil
+	}
+	line, _ := firstLine(response)
+	return nil, malformed("no SSH identification string", line)
+}
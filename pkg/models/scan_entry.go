@@ -0,0 +1,125 @@
+// Package models defines the scan entry which the processor builds from each scan and stores, and its validation.
+package models
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+type ScanEntry struct {
+	// IP is always held in canonical form (see CanonicalAddr) so that IPv4-mapped and zoned IPv6 variants
+	// of the same address resolve to the same stored entry.
+	IP netip.Addr
+	// Port is validated against the PortRules for Transport rather than a fixed range.
+	Port uint32
+	// Transport is not persisted; it is only used to select the applicable port rule (empty means DefaultTransport).
+	Transport     string
+	Service       string `validate:"required"`
+	ScanTimestamp int64  `validate:"required"`
+	// ScanNanos is the sub-second part of ScanTimestamp, in nanoseconds.
+	ScanNanos int64  `validate:"min=0,max=999999999"`
+	Response  string `validate:"required"`
+	// ASN, ASOrg and Country (an ISO 3166-1 alpha-2 code) are looked up from IP by the processor's enrichment stage;
+	// they are empty when no database is configured or the IP is not found in it.
+	ASN     uint32
+	ASOrg   string
+	Country string
+	// Parsed holds the fields the processor extracted from Response with the parser of Service (see pkg/banner), and
+	// ParseError why the response could not be parsed; both are empty for services without a parser.
+	Parsed     map[string]string
+	ParseError string
+	// Fingerprint is the software the processor identified from Response; it is empty when no rule matched.
+	Fingerprint Fingerprint
+}
+
+// Fingerprint identifies the software serving a scanned service.
+type Fingerprint struct {
+	Vendor  string
+	Product string
+	Version string
+	// CPE is the CPE 2.3 name of the software, e.g. cpe:2.3:a:openbsd:openssh:9.6:*:*:*:*:*:*:*.
+	CPE string
+}
+
+// Validate validates the entry against DefaultPortRules.
+// Use a Validator to validate against a different set of rules.
+func (s *ScanEntry) Validate() error {
+	return defaultValidator.Validate(s)
+}
+
+// NewScanEntry converts a scanning.Scan into a ScanEntry with a canonical IP address.
+// An unparsable IP address is returned as a *ValidationError; the entry is not otherwise validated.
+func NewScanEntry(se scanning.Scan) (*ScanEntry, error) {
+	ip, err := ParseIP(se.Ip)
+	if err != nil {
+		return nil, err
+	}
+	entry := &ScanEntry{
+		IP:            ip,
+		Port:          se.Port,
+		Transport:     strings.ToLower(strings.TrimSpace(se.Transport)),
+		Service:       se.Service,
+		ScanTimestamp: se.Timestamp,
+		ScanNanos:     se.TimestampNanos,
+	}
+
+	switch se.DataVersion {
+	case scanning.V1:
+		entry.Response = string(se.Data.(*scanning.V1Data).ResponseBytesUtf8)
+	case scanning.V2:
+		entry.Response = se.Data.(*scanning.V2Data).ResponseStr
+	default:
+		return nil, errors.New("invalid data version")
+	}
+
+	return entry, nil
+}
+
+// MarshalParsed returns the JSON encoding of the parsed fields, or nil if there are none.
+func (s *ScanEntry) MarshalParsed() []byte {
+	if len(s.Parsed) == 0 {
+		return nil
+	}
+	// A map of strings always encodes
+	data, _ := json.Marshal(s.Parsed)
+	return data
+}
+
+// UnmarshalParsed sets the parsed fields from their JSON encoding; empty data (or a JSON null) leaves none.
+func (s *ScanEntry) UnmarshalParsed(data []byte) error {
+	s.Parsed = nil
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.Parsed); err != nil {
+		return err
+	}
+	if len(s.Parsed) == 0 {
+		s.Parsed = nil
+	}
+	return nil
+}
+
+// ResponseHash returns the SHA-256 hash of the response, which breaks ties between scans taken at the same instant.
+func (s *ScanEntry) ResponseHash() []byte {
+	hash := sha256.Sum256([]byte(s.Response))
+	return hash[:]
+}
+
+// NewerThan reports whether the entry replaces other as the latest scan of the same service.
+// Scans are ordered by ScanTimestamp, then ScanNanos, then ResponseHash, so every database converges on the same
+// entry regardless of the order in which scans are delivered. Redelivering an entry never changes what is stored.
+func (s *ScanEntry) NewerThan(other *ScanEntry) bool {
+	return cmp.Or(
+		cmp.Compare(s.ScanTimestamp, other.ScanTimestamp),
+		cmp.Compare(s.ScanNanos, other.ScanNanos),
+		bytes.Compare(s.ResponseHash(), other.ResponseHash()),
+	) > 0
+}
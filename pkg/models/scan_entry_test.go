@@ -0,0 +1,104 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/pkg/models"
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+var _ = Describe("ScanEntry", func() {
+	Context("Creation of a ScanEntry instance", func() {
+		It("should create a valid ScanEntry from a valid scanning.Scan with V1Data", func() {
+			scan := scanning.Scan{
+				Ip:          "192.168.0.1",
+				Port:        80,
+				Service:     "http",
+				Timestamp:   1625077800,
+				DataVersion: scanning.V1,
+				Data: &scanning.V1Data{
+					ResponseBytesUtf8: []byte("HTTP/1.1 200 OK"),
+				},
+			}
+			scanEntry, err := models.NewScanEntry(scan)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scanEntry.IP.String()).To(Equal(scan.Ip))
+			Expect(scanEntry.Port).To(Equal(scan.Port))
+			Expect(scanEntry.Service).To(Equal(scan.Service))
+			Expect(scanEntry.ScanTimestamp).To(Equal(scan.Timestamp))
+			Expect(scanEntry.Response).To(Equal("HTTP/1.1 200 OK"))
+			err = scanEntry.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("should create a valid ScanEntry from a valid scanning.Scan with V2Data", func() {
+			scan := scanning.Scan{
+				Ip:          "192.168.0.1",
+				Port:        80,
+				Service:     "http",
+				Timestamp:   1625077800,
+				DataVersion: scanning.V2,
+				Data: &scanning.V2Data{
+					ResponseStr: "HTTP/1.1 200 OK",
+				},
+			}
+			scanEntry, err := models.NewScanEntry(scan)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scanEntry.IP.String()).To(Equal(scan.Ip))
+			Expect(scanEntry.Port).To(Equal(scan.Port))
+			Expect(scanEntry.Service).To(Equal(scan.Service))
+			Expect(scanEntry.ScanTimestamp).To(Equal(scan.Timestamp))
+			Expect(scanEntry.Response).To(Equal("HTTP/1.1 200 OK"))
+			err = scanEntry.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+	It("should set the sub-second part of the timestamp", func() {
+		scan := scanning.Scan{
+			Ip:             "192.168.0.1",
+			Port:           80,
+			Service:        "HTTP",
+			Timestamp:      1234567890,
+			TimestampNanos: 123456789,
+			DataVersion:    scanning.V2,
+			Data:           &scanning.V2Data{ResponseStr: "HTTP/1.1 200 OK"},
+		}
+		scanEntry, err := models.NewScanEntry(scan)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scanEntry.ScanNanos).To(Equal(int64(123456789)))
+		Expect(scanEntry.Validate()).To(Succeed())
+	})
+	DescribeTable("NewerThan",
+		func(timestamp, nanos int64, response string, otherTimestamp, otherNanos int64, otherResponse string, expected bool) {
+			entry := &models.ScanEntry{ScanTimestamp: timestamp, ScanNanos: nanos, Response: response}
+			other := &models.ScanEntry{ScanTimestamp: otherTimestamp, ScanNanos: otherNanos, Response: otherResponse}
+			Expect(entry.NewerThan(other)).To(Equal(expected))
+		},
+		Entry("newer scan", int64(200), int64(0), "a", int64(100), int64(0), "a", true),
+		Entry("older scan", int64(100), int64(0), "a", int64(200), int64(0), "a", false),
+		Entry("newer second with fewer nanos", int64(200), int64(0), "a", int64(100), int64(999999999), "a", true),
+		Entry("newer nanos in the same second", int64(100), int64(2), "a", int64(100), int64(1), "a", true),
+		Entry("older nanos in the same second", int64(100), int64(1), "a", int64(100), int64(2), "a", false),
+		// sha256("first") = a793..., sha256("second") = 1636...
+		Entry("larger response hash at the same instant", int64(100), int64(0), "first", int64(100), int64(0), "second", true),
+		Entry("smaller response hash at the same instant", int64(100), int64(0), "second", int64(100), int64(0), "first", false),
+		Entry("identical scan", int64(100), int64(1), "a", int64(100), int64(1), "a", false),
+	)
+	It("should encode the parsed fields as JSON and decode them back", func() {
+		entry := &models.ScanEntry{Parsed: map[string]string{"status": "200", "server": "nginx"}}
+		data := entry.MarshalParsed()
+		Expect(data).To(MatchJSON(`{"status":"200","server":"nginx"}`))
+		decoded := &models.ScanEntry{}
+		Expect(decoded.UnmarshalParsed(data)).To(Succeed())
+		Expect(decoded.Parsed).To(Equal(entry.Parsed))
+	})
+	It("should encode no parsed fields as nothing and decode nothing, null or an empty object as none", func() {
+		Expect((&models.ScanEntry{Parsed: map[string]string{}}).MarshalParsed()).To(BeNil())
+		for _, data := range []string{"", "null", "{}"} {
+			entry := &models.ScanEntry{Parsed: map[string]string{"stale": "field"}}
+			Expect(entry.UnmarshalParsed([]byte(data))).To(Succeed())
+			Expect(entry.Parsed).To(BeNil())
+		}
+		Expect((&models.ScanEntry{}).UnmarshalParsed([]byte("[1]"))).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	TransportTCP = "tcp"
+	TransportUDP = "udp"
+
+	// DefaultTransport is assumed when a scan does not report the transport it was collected over.
+	DefaultTransport = TransportTCP
+)
+
+var (
+	// ErrInvalidEntry is matched (via errors.Is) by every validation failure for a ScanEntry.
+	// Callers can use it to classify the failure as permanent; re-processing the same entry will never succeed.
+	ErrInvalidEntry = errors.New("invalid scan entry")
+
+	// DefaultPortRules rejects port 0 for TCP but allows it for UDP, where some services legitimately report it.
+	DefaultPortRules = PortRules{
+		TransportTCP: {Min: 1, Max: 65535},
+		TransportUDP: {Min: 0, Max: 65535},
+	}
+
+	defaultValidator = NewValidator(DefaultPortRules)
+	structValidator  = validator.New(validator.WithRequiredStructEnabled())
+)
+
+// FieldError describes a single ScanEntry field which failed validation.
+type FieldError struct {
+	// Field is the name of the ScanEntry field (e.g. "Port").
+	Field string
+	// Rule is the short name of the rule which failed (e.g. "required", "ip", "port_range").
+	Rule string
+	// Value is the offending value.
+	Value any
+}
+
+func (f FieldError) String() string {
+	return fmt.Sprintf("%s failed on the '%s' rule (value: %v)", f.Field, f.Rule, f.Value)
+}
+
+// ValidationError is returned when a ScanEntry fails validation and lists every failing field.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.String())
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidEntry, strings.Join(parts, "; "))
+}
+
+// Is allows errors.Is(err, ErrInvalidEntry) to match any ValidationError.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidEntry
+}
+
+// PortRange is an inclusive range of acceptable port numbers.
+type PortRange struct {
+	Min uint32
+	Max uint32
+}
+
+// Contains reports whether port falls inside the range.
+func (r PortRange) Contains(port uint32) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// PortRules maps a transport (e.g. "tcp") to the port range accepted for it.
+// A transport which is not present in the rules is rejected.
+type PortRules map[string]PortRange
+
+// ParsePortRules parses rules of the form "tcp=1-65535,udp=0-65535".
+// A single port may be given instead of a range (e.g. "udp=0").
+// An empty string returns DefaultPortRules.
+func ParsePortRules(s string) (PortRules, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultPortRules, nil
+	}
+	rules := PortRules{}
+	for _, item := range strings.Split(s, ",") {
+		transport, bounds, found := strings.Cut(strings.TrimSpace(item), "=")
+		transport = strings.ToLower(strings.TrimSpace(transport))
+		if !found || transport == "" {
+			return nil, fmt.Errorf("invalid port rule %q: expected <transport>=<min>-<max>", item)
+		}
+		lo, hi, isRange := strings.Cut(bounds, "-")
+		if !isRange {
+			hi = lo
+		}
+		minPort, err := parsePort(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port rule %q: %w", item, err)
+		}
+		maxPort, err := parsePort(hi)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port rule %q: %w", item, err)
+		}
+		if minPort > maxPort {
+			return nil, fmt.Errorf("invalid port rule %q: minimum is greater than maximum", item)
+		}
+		rules[transport] = PortRange{Min: minPort, Max: maxPort}
+	}
+	return rules, nil
+}
+
+func parsePort(s string) (uint32, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint32(v), nil
+}
+
+// CanonicalAddr returns the canonical form of an address used for storage and comparison.
+// IPv4-mapped IPv6 addresses are unmapped to IPv4 and any IPv6 zone is removed.
+func CanonicalAddr(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
+
+// ParseIP parses and canonicalizes an IPv4 or IPv6 address.
+// A parse failure is returned as a *ValidationError.
+func ParseIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, &ValidationError{Fields: []FieldError{{Field: "IP", Rule: "ip", Value: s}}}
+	}
+	return CanonicalAddr(addr), nil
+}
+
+// Validator validates ScanEntry instances against a set of port rules.
+type Validator struct {
+	portRules PortRules
+}
+
+// NewValidator creates a Validator for the given port rules; nil rules fall back to DefaultPortRules.
+func NewValidator(rules PortRules) *Validator {
+	if rules == nil {
+		rules = DefaultPortRules
+	}
+	return &Validator{portRules: rules}
+}
+
+// Validate checks the entry and returns a *ValidationError describing every failing field, or nil.
+func (v *Validator) Validate(s *ScanEntry) error {
+	if s == nil {
+		return &ValidationError{Fields: []FieldError{{Field: "ScanEntry", Rule: "required"}}}
+	}
+	fields, err := storableFieldErrors(s)
+	if err != nil {
+		return err
+	}
+	transport := s.Transport
+	if transport == "" {
+		transport = DefaultTransport
+	}
+	if rng, found := v.portRules[transport]; !found {
+		fields = append(fields, FieldError{Field: "Transport", Rule: "transport", Value: s.Transport})
+	} else if !rng.Contains(s.Port) {
+		fields = append(fields, FieldError{Field: "Port", Rule: "port_range", Value: s.Port})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// ValidateStorable checks the fields every database relies on to store an entry (a canonical IP, the service,
+// scan timestamp and response) and returns a *ValidationError, or nil.
+// Unlike a Validator it does not apply port rules, as those are configured by the processor.
+func ValidateStorable(s *ScanEntry) error {
+	if s == nil {
+		return &ValidationError{Fields: []FieldError{{Field: "ScanEntry", Rule: "required"}}}
+	}
+	fields, err := storableFieldErrors(s)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func storableFieldErrors(s *ScanEntry) ([]FieldError, error) {
+	var fields []FieldError
+	if err := structValidator.Struct(s); err != nil {
+		var verrs validator.ValidationErrors
+		if !errors.As(err, &verrs) {
+			return nil, err
+		}
+		for _, fe := range verrs {
+			fields = append(fields, FieldError{Field: fe.Field(), Rule: fe.Tag(), Value: fe.Value()})
+		}
+	}
+	if !s.IP.IsValid() {
+		fields = append(fields, FieldError{Field: "IP", Rule: "required", Value: s.IP})
+	} else if s.IP != CanonicalAddr(s.IP) {
+		fields = append(fields, FieldError{Field: "IP", Rule: "canonical", Value: s.IP})
+	}
+	return fields, nil
+}
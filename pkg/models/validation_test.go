@@ -0,0 +1,170 @@
+package models_test
+
+import (
+	"errors"
+	"net/netip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/pkg/models"
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+var _ = Describe("Validation", func() {
+	Context("IP parsing and canonicalization", func() {
+		DescribeTable("ParseIP",
+			func(input string, expected string) {
+				addr, err := models.ParseIP(input)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(addr.String()).To(Equal(expected))
+			},
+			Entry("IPv4", "192.168.0.1", "192.168.0.1"),
+			Entry("IPv6 long form", "2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::1"),
+			Entry("IPv6 upper case", "2001:DB8::A", "2001:db8::a"),
+			Entry("IPv4-mapped IPv6", "::ffff:10.0.0.1", "10.0.0.1"),
+			Entry("IPv6 with zone", "fe80::1%eth0", "fe80::1"),
+			Entry("surrounding whitespace", " 10.0.0.1 ", "10.0.0.1"),
+		)
+		It("should return a structured validation error for an unparsable IP", func() {
+			_, err := models.ParseIP("not-an-ip")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, models.ErrInvalidEntry)).To(BeTrue())
+			var verr *models.ValidationError
+			Expect(errors.As(err, &verr)).To(BeTrue())
+			Expect(verr.Fields).To(ConsistOf(models.FieldError{Field: "IP", Rule: "ip", Value: "not-an-ip"}))
+		})
+		It("should canonicalize the IP when creating a ScanEntry", func() {
+			entry, err := models.NewScanEntry(scanning.Scan{
+				Ip:          "::FFFF:192.168.0.1",
+				Port:        53,
+				Transport:   " UDP ",
+				Service:     "dns",
+				Timestamp:   1625077800,
+				DataVersion: scanning.V2,
+				Data:        &scanning.V2Data{ResponseStr: "ok"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.IP).To(Equal(netip.MustParseAddr("192.168.0.1")))
+			Expect(entry.Transport).To(Equal(models.TransportUDP))
+		})
+		It("should return a validation error when creating a ScanEntry with an invalid IP", func() {
+			entry, err := models.NewScanEntry(scanning.Scan{Ip: "1.2.3", DataVersion: scanning.V2, Data: &scanning.V2Data{}})
+			Expect(errors.Is(err, models.ErrInvalidEntry)).To(BeTrue())
+			Expect(entry).To(BeNil())
+		})
+	})
+
+	Context("ScanEntry validation", func() {
+		validEntry := func() *models.ScanEntry {
+			return &models.ScanEntry{
+				IP:            netip.MustParseAddr("2001:db8::1"),
+				Port:          443,
+				Service:       "https",
+				ScanTimestamp: 1625077800,
+				Response:      "HTTP/1.1 200 OK",
+			}
+		}
+		DescribeTable("Validate with default port rules",
+			func(modify func(e *models.ScanEntry), expected ...models.FieldError) {
+				entry := validEntry()
+				modify(entry)
+				err := entry.Validate()
+				if len(expected) == 0 {
+					Expect(err).ToNot(HaveOccurred())
+					return
+				}
+				Expect(errors.Is(err, models.ErrInvalidEntry)).To(BeTrue())
+				var verr *models.ValidationError
+				Expect(errors.As(err, &verr)).To(BeTrue())
+				Expect(verr.Fields).To(ConsistOf(expected))
+			},
+			Entry("valid IPv6 entry", func(e *models.ScanEntry) {}),
+			Entry("valid IPv4 entry", func(e *models.ScanEntry) { e.IP = netip.MustParseAddr("10.0.0.1") }),
+			Entry("port 0 over udp", func(e *models.ScanEntry) { e.Port = 0; e.Transport = models.TransportUDP }),
+			Entry("port 0 over the default transport",
+				func(e *models.ScanEntry) { e.Port = 0 },
+				models.FieldError{Field: "Port", Rule: "port_range", Value: uint32(0)},
+			),
+			Entry("port above 65535",
+				func(e *models.ScanEntry) { e.Port = 70000; e.Transport = models.TransportUDP },
+				models.FieldError{Field: "Port", Rule: "port_range", Value: uint32(70000)},
+			),
+			Entry("unknown transport",
+				func(e *models.ScanEntry) { e.Transport = "sctp" },
+				models.FieldError{Field: "Transport", Rule: "transport", Value: "sctp"},
+			),
+			Entry("missing IP",
+				func(e *models.ScanEntry) { e.IP = netip.Addr{} },
+				models.FieldError{Field: "IP", Rule: "required", Value: netip.Addr{}},
+			),
+			Entry("non-canonical IP",
+				func(e *models.ScanEntry) { e.IP = netip.MustParseAddr("::ffff:10.0.0.1") },
+				models.FieldError{Field: "IP", Rule: "canonical", Value: netip.MustParseAddr("::ffff:10.0.0.1")},
+			),
+			Entry("missing service, timestamp and response",
+				func(e *models.ScanEntry) { e.Service = ""; e.ScanTimestamp = 0; e.Response = "" },
+				models.FieldError{Field: "Service", Rule: "required", Value: ""},
+				models.FieldError{Field: "ScanTimestamp", Rule: "required", Value: int64(0)},
+				models.FieldError{Field: "Response", Rule: "required", Value: ""},
+			),
+		)
+		It("should reject a nil entry", func() {
+			Expect(errors.Is(models.NewValidator(nil).Validate(nil), models.ErrInvalidEntry)).To(BeTrue())
+		})
+		It("should apply custom port rules", func() {
+			rules, err := models.ParsePortRules("tcp=0-65535")
+			Expect(err).ToNot(HaveOccurred())
+			v := models.NewValidator(rules)
+			entry := validEntry()
+			entry.Port = 0
+			Expect(v.Validate(entry)).To(Succeed())
+			entry.Transport = models.TransportUDP
+			Expect(errors.Is(v.Validate(entry), models.ErrInvalidEntry)).To(BeTrue())
+		})
+		It("should include every failing field in the error message", func() {
+			entry := validEntry()
+			entry.Port = 0
+			entry.Service = ""
+			err := entry.Validate()
+			Expect(err).To(MatchError(MatchRegexp(`^invalid scan entry: .*Service failed on the 'required' rule.*; Port failed on the 'port_range' rule \(value: 0\)$`)))
+		})
+		It("should check the storable fields without applying port rules", func() {
+			entry := validEntry()
+			entry.Port = 0
+			entry.Transport = "sctp"
+			Expect(models.ValidateStorable(entry)).To(Succeed())
+			entry.IP = netip.MustParseAddr("::ffff:10.0.0.1")
+			entry.Response = ""
+			err := models.ValidateStorable(entry)
+			Expect(err).To(MatchError(models.ErrInvalidEntry))
+			Expect(err.(*models.ValidationError).Fields).To(ConsistOf(
+				models.FieldError{Field: "Response", Rule: "required", Value: ""},
+				models.FieldError{Field: "IP", Rule: "canonical", Value: entry.IP},
+			))
+			Expect(models.ValidateStorable(nil)).To(MatchError(models.ErrInvalidEntry))
+		})
+	})
+
+	Context("Port rule parsing", func() {
+		DescribeTable("ParsePortRules",
+			func(input string, expected models.PortRules, expErrRegex ...string) {
+				rules, err := models.ParsePortRules(input)
+				if len(expErrRegex) > 0 {
+					Expect(err).To(MatchError(MatchRegexp(expErrRegex[0])))
+					return
+				}
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rules).To(Equal(expected))
+			},
+			Entry("empty uses the defaults", "", models.DefaultPortRules),
+			Entry("ranges and single ports", "TCP=1-1024, udp=0", models.PortRules{
+				"tcp": {Min: 1, Max: 1024},
+				"udp": {Min: 0, Max: 0},
+			}),
+			Entry("missing separator", "tcp", nil, `invalid port rule "tcp"`),
+			Entry("invalid port", "tcp=1-70000", nil, `invalid port "70000"`),
+			Entry("inverted range", "tcp=10-1", nil, `minimum is greater than maximum`),
+		)
+	})
+})
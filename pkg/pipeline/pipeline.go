@@ -0,0 +1,218 @@
+// Package pipeline is the middleware pipeline through which the processor handles each scan message.
+//
+// A message passes through named stages, each a Middleware which does its work and hands the message to the next
+// stage. The processor's pipeline is
+//
+//	ack → dedup → decode → validate → filter → geoip → parse → fingerprint → transform → store
+//
+// where ack acknowledges the message with the result of the stages after it, decode sets Message.Scan, validate sets
+// Message.Entry, and store writes the entry. The dedup, filter, geoip and fingerprint stages are optional, and pass
+// messages straight through when they are not configured, so every stage is always in the pipeline. Stages are added
+// around the processor's stages by name, either with RegisterBefore/RegisterAfter from the init function of a package
+// imported by a wrapper binary which runs the processor with processor.Run (pkg/processor), or directly on a Pipeline.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/censys/scan-takehome/pkg/models"
+	"github.com/censys/scan-takehome/pkg/scanning"
+)
+
+// The stages of the processor's pipeline, in order.
+const (
+	StageAck = "ack"
+	// StageDedup drops duplicate messages when deduplication is enabled.
+	StageDedup    = "dedup"
+	StageDecode   = "decode"
+	StageValidate = "validate"
+	// StageFilter drops scans excluded by the filter rules when a filter is configured.
+	StageFilter = "filter"
+	// StageGeoIP sets the ASN, organization and country of the entry when geoip databases are configured.
+	StageGeoIP = "geoip"
+	// StageParse extracts the fields of the response of the entry with the parser of its service (see pkg/banner).
+	StageParse = "parse"
+	// StageFingerprint sets the fingerprint of the entry from its response when fingerprint rules are configured.
+	StageFingerprint = "fingerprint"
+	// StageTransform marks where stages which modify the entry are added.
+	StageTransform = "transform"
+	StageStore     = "store"
+)
+
+var (
+	// ErrDrop marks the error of a message which is acknowledged without being stored, as handling it again would not
+	// change the outcome (e.g. it is invalid or filtered out).
+	ErrDrop = errors.New("message dropped")
+	// ErrUnknownStage is returned when adding a stage around a stage which is not in the pipeline.
+	ErrUnknownStage = errors.New("unknown pipeline stage")
+)
+
+// Drop marks err as the reason a message is dropped; a nil err drops the message without a reason.
+func Drop(err error) error {
+	if err == nil {
+		return ErrDrop
+	}
+	return fmt.Errorf("%w: %w", ErrDrop, err)
+}
+
+// Message is a message being handled by the pipeline.
+type Message struct {
+	// ID, Data, Attributes, PublishTime and DeliveryAttempt are those of the received Pub/Sub message.
+	ID              string
+	Data            []byte
+	Attributes      map[string]string
+	PublishTime     time.Time
+	DeliveryAttempt *int
+	// Scan is set by the decode stage.
+	Scan *scanning.Scan
+	// Entry is the scan entry converted from Scan, set by the validate stage; stages after it can read and modify the
+	// entry before it is stored.
+	Entry *models.ScanEntry
+
+	mu       sync.Mutex
+	values   map[any]any
+	deferred bool
+	complete func(err error)
+	once     sync.Once
+}
+
+// Set stores a value for the later stages of the message under a key, which should be of an unexported type of the
+// package setting it as with context.WithValue.
+func (m *Message) Set(key, value any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = map[any]any{}
+	}
+	m.values[key] = value
+}
+
+// Value returns the value stored under the key, or nil.
+func (m *Message) Value(key any) any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+// Defer takes over acknowledging the message from the ack stage, for stages which finish handling the message after
+// they return. The returned function must be called once with the result of the message.
+func (m *Message) Defer() func(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deferred = true
+	return m.finish
+}
+
+func (m *Message) finish(err error) {
+	m.once.Do(func() {
+		if m.complete != nil {
+			m.complete(err)
+		}
+	})
+}
+
+// Handler handles a message.
+type Handler interface {
+	Handle(ctx context.Context, m *Message) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, m *Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, m *Message) error {
+	return f(ctx, m)
+}
+
+// Middleware wraps the handler of the following stages; it returns the error of the message, whether its own or that
+// of the following stages.
+type Middleware func(next Handler) Handler
+
+// Ack is the stage which acknowledges messages: ack is called with the error returned by the following stages once they
+// return, or with the result passed to Message.Defer. Errors marked with Drop are meant to be acked and other errors
+// nacked.
+func Ack(ack func(m *Message, err error)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, m *Message) error {
+			m.complete = func(err error) { ack(m, err) }
+			err := next.Handle(ctx, m)
+			m.mu.Lock()
+			deferred := m.deferred
+			m.mu.Unlock()
+			if !deferred {
+				m.finish(err)
+			}
+			return err
+		})
+	}
+}
+
+// Stage is a named step of a pipeline. A stage without a Middleware passes messages straight through; it marks a
+// position at which other stages can be added.
+type Stage struct {
+	Name       string
+	Middleware Middleware
+}
+
+// Pipeline is an ordered list of stages.
+type Pipeline struct {
+	stages []Stage
+}
+
+// New creates a pipeline of the stages, in the order messages pass through them.
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: slices.Clone(stages)}
+}
+
+// Stages returns the names of the stages in order.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+// Append adds the stages at the end of the pipeline.
+func (p *Pipeline) Append(stages ...Stage) {
+	p.stages = append(p.stages, stages...)
+}
+
+// InsertBefore adds the stages before the first stage with the name.
+func (p *Pipeline) InsertBefore(name string, stages ...Stage) error {
+	i := p.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrUnknownStage, name)
+	}
+	p.stages = slices.Insert(p.stages, i, stages...)
+	return nil
+}
+
+// InsertAfter adds the stages after the first stage with the name.
+func (p *Pipeline) InsertAfter(name string, stages ...Stage) error {
+	i := p.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrUnknownStage, name)
+	}
+	p.stages = slices.Insert(p.stages, i+1, stages...)
+	return nil
+}
+
+func (p *Pipeline) index(name string) int {
+	return slices.IndexFunc(p.stages, func(stage Stage) bool { return stage.Name == name })
+}
+
+// Handler chains the stages into a handler; the last stage is followed by a handler which does nothing.
+func (p *Pipeline) Handler() Handler {
+	var handler Handler = HandlerFunc(func(context.Context, *Message) error { return nil })
+	for _, stage := range slices.Backward(p.stages) {
+		if stage.Middleware != nil {
+			handler = stage.Middleware(handler)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,128 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/censys/scan-takehome/pkg/pipeline"
+)
+
+var _ = Describe("Pipeline", func() {
+	var (
+		ctx   = context.Background()
+		trace []string
+	)
+	// recorder is a stage which records its name as messages pass through it
+	recorder := func(name string) pipeline.Stage {
+		return pipeline.Stage{Name: name, Middleware: func(next pipeline.Handler) pipeline.Handler {
+			return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+				trace = append(trace, name)
+				return next.Handle(ctx, m)
+			})
+		}}
+	}
+	// failing is a stage which returns err without passing messages on
+	failing := func(name string, err error) pipeline.Stage {
+		return pipeline.Stage{Name: name, Middleware: func(next pipeline.Handler) pipeline.Handler {
+			return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+				trace = append(trace, name)
+				return err
+			})
+		}}
+	}
+	BeforeEach(func() {
+		trace = nil
+	})
+
+	It("should pass messages through the stages in order, skipping marker stages", func() {
+		pl := pipeline.New(recorder("a"), pipeline.Stage{Name: "marker"}, recorder("b"))
+		Expect(pl.Handler().Handle(ctx, &pipeline.Message{})).To(Succeed())
+		Expect(trace).To(Equal([]string{"a", "b"}))
+	})
+	It("should add stages around existing stages", func() {
+		pl := pipeline.New(recorder("a"), pipeline.Stage{Name: "marker"}, recorder("c"))
+		Expect(pl.InsertBefore("marker", recorder("b"))).To(Succeed())
+		Expect(pl.InsertAfter("c", recorder("d"))).To(Succeed())
+		pl.Append(recorder("e"))
+		Expect(pl.Stages()).To(Equal([]string{"a", "b", "marker", "c", "d", "e"}))
+		Expect(pl.Handler().Handle(ctx, &pipeline.Message{})).To(Succeed())
+		Expect(trace).To(Equal([]string{"a", "b", "c", "d", "e"}))
+	})
+	It("should refuse to add stages around an unknown stage", func() {
+		pl := pipeline.New(recorder("a"))
+		Expect(pl.InsertBefore("b", recorder("c"))).To(MatchError(pipeline.ErrUnknownStage))
+		Expect(pl.InsertAfter("b", recorder("c"))).To(MatchError(pipeline.ErrUnknownStage))
+	})
+	It("should stop at the stage which fails", func() {
+		failure := errors.New("failed")
+		pl := pipeline.New(recorder("a"), failing("b", failure), recorder("c"))
+		Expect(pl.Handler().Handle(ctx, &pipeline.Message{})).To(MatchError(failure))
+		Expect(trace).To(Equal([]string{"a", "b"}))
+	})
+	It("should apply registrations before and after stages", func() {
+		pl := pipeline.New(recorder("a"), recorder("c"))
+		Expect(pl.Apply(
+			pipeline.Registration{Stage: recorder("b"), Before: "c"},
+			pipeline.Registration{Stage: recorder("d"), After: "c"},
+		)).To(Succeed())
+		Expect(pl.Stages()).To(Equal([]string{"a", "b", "c", "d"}))
+		Expect(pl.Apply(pipeline.Registration{Stage: recorder("e"), After: "z"})).To(MatchError(pipeline.ErrUnknownStage))
+	})
+	It("should keep the registered stages", func() {
+		pipeline.RegisterBefore(pipeline.StageStore, recorder("registered"))
+		Expect(pipeline.Registered()).To(ContainElement(HaveField("Before", pipeline.StageStore)))
+	})
+
+	Describe("Message", func() {
+		type key struct{}
+		It("should keep values for later stages", func() {
+			m := &pipeline.Message{}
+			Expect(m.Value(key{})).To(BeNil())
+			m.Set(key{}, "value")
+			Expect(m.Value(key{})).To(Equal("value"))
+		})
+	})
+
+	Describe("Ack", func() {
+		var results []error
+		ack := pipeline.Stage{Name: pipeline.StageAck, Middleware: pipeline.Ack(func(m *pipeline.Message, err error) {
+			results = append(results, err)
+		})}
+		BeforeEach(func() {
+			results = nil
+		})
+
+		It("should acknowledge a message with the result of the stages", func() {
+			failure := errors.New("failed")
+			Expect(pipeline.New(ack, recorder("a")).Handler().Handle(ctx, &pipeline.Message{})).To(Succeed())
+			Expect(pipeline.New(ack, failing("a", failure)).Handler().Handle(ctx, &pipeline.Message{})).To(MatchError(failure))
+			Expect(results).To(Equal([]error{nil, failure}))
+		})
+		It("should mark dropped messages", func() {
+			reason := errors.New("filtered")
+			Expect(pipeline.New(ack, failing("a", pipeline.Drop(reason))).Handler().Handle(ctx, &pipeline.Message{})).To(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0]).To(MatchError(pipeline.ErrDrop))
+			Expect(results[0]).To(MatchError(reason))
+			Expect(pipeline.Drop(nil)).To(Equal(pipeline.ErrDrop))
+		})
+		It("should leave deferred messages to be acknowledged once, by the stage which deferred them", func() {
+			var done func(error)
+			deferring := pipeline.Stage{Name: "deferring", Middleware: func(next pipeline.Handler) pipeline.Handler {
+				return pipeline.HandlerFunc(func(ctx context.Context, m *pipeline.Message) error {
+					done = m.Defer()
+					return next.Handle(ctx, m)
+				})
+			}}
+			Expect(pipeline.New(ack, deferring).Handler().Handle(ctx, &pipeline.Message{})).To(Succeed())
+			Expect(results).To(BeEmpty())
+			failure := errors.New("failed later")
+			done(failure)
+			done(nil)
+			Expect(results).To(Equal([]error{failure}))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"slices"
+)
+
+// Registration is a stage registered to be added to the processor's pipeline, before or after an existing stage.
+type Registration struct {
+	Stage  Stage
+	Before string
+	After  string
+}
+
+var registry []Registration
+
+// RegisterBefore registers a stage to be added to the processor's pipeline before the stage with the name.
+// Stages are registered from the init function of a package imported by a wrapper binary, as databases are.
+func RegisterBefore(name string, stage Stage) {
+	registry = append(registry, Registration{Stage: stage, Before: name})
+}
+
+// RegisterAfter registers a stage to be added to the processor's pipeline after the stage with the name.
+func RegisterAfter(name string, stage Stage) {
+	registry = append(registry, Registration{Stage: stage, After: name})
+}
+
+// Registered returns the registered stages in the order they were registered.
+func Registered() []Registration {
+	return slices.Clone(registry)
+}
+
+// Apply adds the registrations to the pipeline in order.
+func (p *Pipeline) Apply(registrations ...Registration) error {
+	for _, r := range registrations {
+		var err error
+		if r.Before != "" {
+			err = p.InsertBefore(r.Before, r.Stage)
+		} else {
+			err = p.InsertAfter(r.After, r.Stage)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Package processor runs the scan processor, configured from the environment, exactly as the processor binary does.
+//
+// It is the entry point of wrapper binaries which extend the processor's pipeline: a wrapper imports the packages
+// registering its stages (see pipeline.RegisterBefore and pipeline.RegisterAfter) for their side effect and calls Run
+// from its main function. The stages read and modify the scan entry of each message through pipeline.Message.Entry.
+package processor
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/censys/scan-takehome/internal/database"
+	"github.com/censys/scan-takehome/internal/dedup"
+	"github.com/censys/scan-takehome/internal/filter"
+	"github.com/censys/scan-takehome/internal/fingerprint"
+	"github.com/censys/scan-takehome/internal/geoip"
+	"github.com/censys/scan-takehome/internal/metrics"
+	"github.com/censys/scan-takehome/internal/processor"
+	"github.com/censys/scan-takehome/internal/retention"
+
+	// import the database implementations for the registration side effect
+	_ "github.com/censys/scan-takehome/internal/database/bolt"
+	_ "github.com/censys/scan-takehome/internal/database/clickhouse"
+	_ "github.com/censys/scan-takehome/internal/database/fanout"
+	_ "github.com/censys/scan-takehome/internal/database/psql"
+	_ "github.com/censys/scan-takehome/internal/database/redis"
+)
+
+// Run creates the processor, with the stages registered in the pipeline package, and handles scan messages until it
+// receives SIGINT or SIGTERM. It returns an error if the processor cannot be created.
+func Run() error {
+	zap.ReplaceGlobals(zap.L().Named("processor"))
+	if addr := metrics.ConfigFromEnv().Addr; addr != "" {
+		go func() {
+			if err := metrics.Serve(context.Background(), addr); err != nil {
+				zap.S().Errorw("failed to serve metrics", "error", err)
+			}
+		}()
+	}
+	db, err := database.New()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// Refuse to start against an out of date schema; migrations are applied with `dbctl migrate up`
+	if err = database.VerifySchema(context.Background()); err != nil {
+		return err
+	}
+	retentionCfg := retention.ConfigFromEnv()
+	if err = retentionCfg.Validate(); err != nil {
+		return err
+	}
+	// Validate has already verified the policy can be parsed
+	policy, _ := retentionCfg.Policy()
+	dedupe, err := dedup.New(dedup.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	if dedupe != nil {
+		defer dedupe.Close()
+	}
+	scanFilter, err := filter.New(filter.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	enricher, err := geoip.New(geoip.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	if enricher != nil {
+		defer enricher.Close()
+	}
+	fingerprints, err := fingerprint.New(fingerprint.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	proc, err := processor.New(processor.ConfigFromEnv(), db,
+		processor.WithRetention(policy, retentionCfg.RunEvery()), processor.WithDedup(dedupe),
+		processor.WithFilter(scanFilter), processor.WithGeoIP(enricher), processor.WithFingerprints(fingerprints))
+	if err != nil {
+		return err
+	}
+	proc.Start()
+	return nil
+}
@@ -0,0 +1,35 @@
+package processor_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/censys/scan-takehome/_test"
+	_ "github.com/censys/scan-takehome/internal/database/noop"
+	"github.com/censys/scan-takehome/pkg/processor"
+)
+
+var _ = Describe("Run", func() {
+	var restoreMap EnvMap
+	AfterEach(func() {
+		restoreMap.SetupEnv()
+	})
+	It("should return an error when the database cannot be created", func() {
+		restoreMap = EnvMap{"DATABASE_TYPE": StringPointer("unknown")}.SetupEnv()
+		Expect(processor.Run()).ToNot(Succeed())
+	})
+	It("should return an error when the processor configuration is invalid", func() {
+		restoreMap = EnvMap{
+			"DATABASE_TYPE":          StringPointer("noop"),
+			"DATABASE_HOST":          StringPointer("localhost"),
+			"DATABASE_USER":          StringPointer("testUser"),
+			"DATABASE_PASSWORD":      StringPointer("testPass"),
+			"DATABASE_PORT":          StringPointer("5432"),
+			"DATABASE_NAME":          StringPointer("scans"),
+			"PUBSUB_PROJECT_ID":      nil,
+			"PUBSUB_SUBSCRIPTION_ID": nil,
+			"PUBSUB_TOPIC_ID":        nil,
+		}.SetupEnv()
+		Expect(processor.Run()).To(MatchError(ContainSubstring("ProjectID")))
+	})
+})
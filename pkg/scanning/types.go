@@ -7,12 +7,15 @@ const (
 )
 
 type Scan struct {
-	Ip          string      `json:"ip"`
-	Port        uint32      `json:"port"`
-	Service     string      `json:"service"`
-	Timestamp   int64       `json:"timestamp"`
-	DataVersion int         `json:"data_version"`
-	Data        interface{} `json:"data"`
+	Ip        string `json:"ip"`
+	Port      uint32 `json:"port"`
+	Transport string `json:"transport,omitempty"`
+	Service   string `json:"service"`
+	Timestamp int64  `json:"timestamp"`
+	// TimestampNanos is the optional sub-second part of Timestamp, in nanoseconds.
+	TimestampNanos int64       `json:"timestamp_nanos,omitempty"`
+	DataVersion    int         `json:"data_version"`
+	Data           interface{} `json:"data"`
 }
 
 type V1Data struct {